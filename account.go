@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// signIn marks the session as authenticated for user. The session epoch is
+// compared against User.SessionEpoch by authMiddleware, so bumping the epoch
+// in the database signs out every other device holding a cookie.
+func signIn(session sessions.Session, user User) {
+	session.Set("user_id", user.ID)
+	session.Set("session_epoch", user.SessionEpoch)
+	session.Save()
+}
+
+const (
+	oidcAuthAtKey   = "oidc_auth_at"
+	oidcAuthUserKey = "oidc_auth_user"
+)
+
+// reauthWindow is how long a provider login confirms an OIDC-only account.
+var reauthWindow = 10 * time.Minute
+
+// confirmIdentity records a provider login that resolved to user. Only an
+// identity already linked to them counts, never one being linked now.
+func confirmIdentity(session sessions.Session, user User) {
+	session.Set(oidcAuthAtKey, time.Now().Unix())
+	session.Set(oidcAuthUserKey, user.ID)
+}
+
+// reauthenticated confirms a sensitive account change with the password.
+// Accounts created via OIDC have none; they need a provider login within
+// reauthWindow instead, so a stolen session alone cannot take them over.
+func reauthenticated(c *gin.Context, user User, password string) bool {
+	if user.Password == "" {
+		session := sessions.Default(c)
+		at, ok := session.Get(oidcAuthAtKey).(int64)
+		who, _ := session.Get(oidcAuthUserKey).(uint)
+		return ok && who == user.ID && time.Since(time.Unix(at, 0)) < reauthWindow
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+}
+
+// reauthError is the notice for a failed reauthenticated check.
+func reauthError(c *gin.Context, user User, key string) notice {
+	if user.Password == "" {
+		key = "account.err.reauth"
+	}
+	return accountError(c, key)
+}
+
+// ==================== ACCOUNT SETTINGS ====================
+type accountView struct {
+	page
+	Notice      notice
+	Username    string
+	HasPassword bool
+	Reauthed    bool // an OIDC-only account confirmed itself recently
+	TOTPEnabled bool
+	IsModerator bool
+	Locales     []option
+	Overlay     []overlayLink
+	ExportAreas []option
+	Webhooks    bool
+	Bot         bool
+	ChatLinks   []ChatLink
+	Providers   []linkedProvider
+}
+
+func (s *server) accountPage(c *gin.Context) {
+	s.renderAccount(c, http.StatusOK, notice{})
+}
+
+func (s *server) renderAccount(c *gin.Context, status int, n notice) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	user, err := s.reqStore(c).UserByID(userID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/logout")
+		return
+	}
+	c.HTML(status, "account", accountView{
+		page:        newPage(c, "title.account"),
+		Notice:      n,
+		Username:    user.Username,
+		HasPassword: user.Password != "",
+		Reauthed:    user.Password == "" && reauthenticated(c, user, ""),
+		TOTPEnabled: user.TOTPEnabled,
+		IsModerator: roleRank[user.Role] >= roleRank[RoleModerator],
+		Locales:     selectOptions(availableLocales(), loc(c).Lang, localeName),
+		Overlay:     s.overlayLinks(c, user.OverlayToken),
+		ExportAreas: exportAreas(loc(c)),
+		Webhooks:    s.cfg.Features.Webhooks,
+		Bot:         s.cfg.Bot.Enabled(),
+		ChatLinks:   s.chatLinks(c.Request.Context(), user.ID),
+		Providers:   s.linkedProviders(c.Request.Context(), user.ID),
+	})
+}
+
+func (s *server) changePasswordHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	userID := session.Get("user_id").(uint)
+	current := c.PostForm("current_password")
+	next := c.PostForm("new_password")
+
+	user, err := s.reqStore(c).UserByID(userID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/logout")
+		return
+	}
+	if !reauthenticated(c, user, current) {
+		s.renderAccount(c, http.StatusUnauthorized, reauthError(c, user, "account.err.current_password"))
+		return
+	}
+	if next == "" || next != c.PostForm("confirm_password") {
+		s.renderAccount(c, http.StatusBadRequest, accountError(c, "account.err.password_mismatch"))
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(next), bcrypt.DefaultCost)
+	if err == nil {
+		user.Password = string(hashed)
+		user.SessionEpoch++
+		err = s.reqStore(c).UpdateUser(user.ID, map[string]any{"password": user.Password, "session_epoch": user.SessionEpoch})
+	}
+	if err != nil {
+		s.renderAccount(c, http.StatusInternalServerError, accountError(c, "account.err.password_failed"))
+		return
+	}
+
+	// Other devices are signed out by the epoch bump; keep this one.
+	signIn(session, user)
+	s.renderAccount(c, http.StatusOK, accountNotice(c, "account.ok.password"))
+}
+
+func (s *server) changeUsernameHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	username := strings.TrimSpace(c.PostForm("username"))
+	if username == "" {
+		s.renderAccount(c, http.StatusBadRequest, accountError(c, "account.err.empty_name"))
+		return
+	}
+
+	user, err := s.reqStore(c).UserByID(userID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/logout")
+		return
+	}
+	if !reauthenticated(c, user, c.PostForm("password")) {
+		s.renderAccount(c, http.StatusUnauthorized, reauthError(c, user, "account.err.password"))
+		return
+	}
+	if taken, _ := s.reqStore(c).UsernameTaken(username, user.ID); taken {
+		s.renderAccount(c, http.StatusConflict, accountError(c, "account.err.name_taken"))
+		return
+	}
+	// The unique index still guards against a concurrent rename racing us.
+	if err := s.reqStore(c).UpdateUser(user.ID, map[string]any{"username": username}); err != nil {
+		s.renderAccount(c, http.StatusConflict, accountError(c, "account.err.name_taken"))
+		return
+	}
+	s.renderAccount(c, http.StatusOK, accountNotice(c, "account.ok.renamed"))
+}
+
+// changeLocaleHandler saves the preferred language on the account and in the
+// session, so it also sticks before the next sign-in.
+func (s *server) changeLocaleHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	userID := session.Get("user_id").(uint)
+	code := c.PostForm("locale")
+	if _, ok := catalogues[code]; !ok {
+		c.String(http.StatusBadRequest, "unknown locale")
+		return
+	}
+	if err := s.reqStore(c).UpdateUser(userID, map[string]any{"locale": code}); err != nil {
+		c.String(http.StatusInternalServerError, "locale: %v", err)
+		return
+	}
+	session.Set(localeSessionKey, code)
+	session.Save()
+	c.Set(localeKey, newLocalizer(code))
+	s.renderAccount(c, http.StatusOK, accountNotice(c, "account.ok.language"))
+}
+
+func (s *server) deleteAccountHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	userID := session.Get("user_id").(uint)
+
+	user, err := s.reqStore(c).UserByID(userID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/logout")
+		return
+	}
+	if c.PostForm("confirm") != user.Username {
+		s.renderAccount(c, http.StatusUnauthorized, accountError(c, "account.err.delete_confirm"))
+		return
+	}
+	if !reauthenticated(c, user, c.PostForm("password")) {
+		s.renderAccount(c, http.StatusUnauthorized, reauthError(c, user, "account.err.delete_confirm"))
+		return
+	}
+	// Leaving first hands a team the player owns to someone else.
+	if err := s.leaveTeam(c.Request.Context(), user.ID); err != nil {
+		s.renderAccount(c, http.StatusInternalServerError, accountError(c, "account.err.delete_failed"))
+		return
+	}
+	// Cookie sessions cannot be revoked server-side; they die because
+	// authMiddleware no longer finds the user.
+	if err := s.reqStore(c).DeleteUser(user.ID); err != nil {
+		s.renderAccount(c, http.StatusInternalServerError, accountError(c, "account.err.delete_failed"))
+		return
+	}
+
+	session.Clear()
+	session.Save()
+	c.Redirect(http.StatusFound, "/register")
+}
+
+// accountError and accountNotice translate a message key for the account page.
+func accountError(c *gin.Context, key string) notice {
+	return notice{Text: loc(c).T(key), Error: true}
+}
+
+func accountNotice(c *gin.Context, key string) notice { return notice{Text: loc(c).T(key)} }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestChangeUsername(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		ts.user(t, "bob")
+		c := ts.user(t, "alice")
+
+		if res := c.post("/account/username", url.Values{"username": {"alicia"}, "password": {"wrong"}}); res.Status != http.StatusUnauthorized {
+			t.Errorf("rename with a wrong password: %d", res.Status)
+		}
+		if res := c.post("/account/username", url.Values{"username": {"bob"}, "password": {testPassword}}); res.Status != http.StatusConflict {
+			t.Errorf("rename to a taken name: %d", res.Status)
+		}
+		if res := c.post("/account/username", url.Values{"username": {"alicia"}, "password": {testPassword}}); res.Status != http.StatusOK {
+			t.Fatalf("rename: %d", res.Status)
+		}
+		if _, err := ts.store.UserByUsername("alicia"); err != nil {
+			t.Error(err)
+		}
+		if _, err := ts.store.UserByUsername("alice"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("old name still resolves: %v", err)
+		}
+	})
+}
+
+func TestDeleteAccount(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		c := ts.user(t, "alice")
+		c.post("/log-run", url.Values{"area": {"Mephisto"}, "difficulty": {"Hell"}, "runes": {`[{"rune":"Ber","qty":1}]`}})
+		user, _ := ts.store.UserByUsername("alice")
+
+		if res := c.post("/account/delete", url.Values{"confirm": {"nope"}, "password": {testPassword}}); res.Status != http.StatusUnauthorized {
+			t.Errorf("delete without confirmation: %d", res.Status)
+		}
+		if res := c.post("/account/delete", url.Values{"confirm": {"alice"}, "password": {testPassword}}); res.Location != "/register" {
+			t.Fatalf("delete: %d %s", res.Status, res.Location)
+		}
+		if _, err := ts.store.UserByID(user.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("user still stored: %v", err)
+		}
+		if runs, _ := ts.store.RunsByUser(user.ID); len(runs) != 0 {
+			t.Errorf("runs survived: %+v", runs)
+		}
+		if rows, _ := ts.store.Leaderboard(LeaderboardFilter{}, 10); len(rows) != 0 {
+			t.Errorf("leaderboard still lists the account: %+v", rows)
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+
+// requireRole lets the request through only if the signed-in user holds at
+// least the given role. It must run after authMiddleware, which puts the role
+// into the context.
+func requireRole(min string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if roleRank[c.GetString("role")] < roleRank[min] {
+			c.HTML(http.StatusForbidden, "message", messageView{page: newPage(c, "title.forbidden"), Text: loc(c).T("forbidden"), Error: true})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ==================== ADMIN: USERS ====================
+type adminUsersView struct {
+	page
+	Reset   *tempPassword
+	Query   string
+	IsAdmin bool
+	Users   []adminUserRow
+}
+
+type adminUserRow struct {
+	User
+	Runs  int
+	Roles []option
+}
+
+// tempPassword is shown once after an admin resets someone's password.
+type tempPassword struct {
+	Username, Password string
+}
+
+var roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func roleLabel(l *localizer) func(string) string {
+	return func(role string) string { return l.T("role." + role) }
+}
+
+func (s *server) adminUsersPage(c *gin.Context) {
+	s.renderAdminUsers(c, nil)
+}
+
+func (s *server) renderAdminUsers(c *gin.Context, reset *tempPassword) {
+	q := strings.TrimSpace(c.Query("q"))
+	users, err := s.reqStore(c).ListUsers(q, 200)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "users: %v", err)
+		return
+	}
+	runs, _ := s.reqStore(c).RunCountsByUser()
+	l := loc(c)
+
+	rows := make([]adminUserRow, len(users))
+	for i, u := range users {
+		rows[i] = adminUserRow{User: u, Runs: runs[u.ID], Roles: selectOptions(roles, u.Role, roleLabel(l))}
+	}
+	c.HTML(http.StatusOK, "admin_users", adminUsersView{
+		page:    newPage(c, "title.admin_users"),
+		Reset:   reset,
+		Query:   q,
+		IsAdmin: c.GetString("role") == RoleAdmin,
+		Users:   rows,
+	})
+}
+
+// adminTarget loads the user from :id. Nobody may act on their own account,
+// and moderators may only act on plain users.
+func (s *server) adminTarget(c *gin.Context) (User, bool) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	user, err := s.reqStore(c).UserByID(uint(id))
+	if err != nil {
+		c.String(http.StatusNotFound, "user not found")
+		return user, false
+	}
+	actor := c.GetString("role")
+	if user.ID == sessions.Default(c).Get("user_id").(uint) || (roleRank[user.Role] >= roleRank[actor] && actor != RoleAdmin) {
+		c.String(http.StatusForbidden, "forbidden")
+		return user, false
+	}
+	return user, true
+}
+
+func (s *server) adminBanHandler(c *gin.Context) {
+	user, ok := s.adminTarget(c)
+	if !ok {
+		return
+	}
+	banned := strings.HasSuffix(c.FullPath(), "/ban")
+	updates := map[string]any{"banned": banned}
+	if banned {
+		updates["session_epoch"] = user.SessionEpoch + 1
+	}
+	s.reqStore(c).UpdateUser(user.ID, updates)
+	c.Redirect(http.StatusFound, "/admin")
+}
+
+func (s *server) adminResetPasswordHandler(c *gin.Context) {
+	user, ok := s.adminTarget(c)
+	if !ok {
+		return
+	}
+	b := make([]byte, 10)
+	rand.Read(b)
+	temp := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	hashed, err := bcrypt.GenerateFromPassword([]byte(temp), bcrypt.DefaultCost)
+	if err == nil {
+		err = s.reqStore(c).UpdateUser(user.ID, map[string]any{"password": string(hashed), "session_epoch": user.SessionEpoch + 1})
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "reset password: %v", err)
+		return
+	}
+	s.renderAdminUsers(c, &tempPassword{Username: user.Username, Password: temp})
+}
+
+func (s *server) adminResetTwoFAHandler(c *gin.Context) {
+	user, ok := s.adminTarget(c)
+	if !ok {
+		return
+	}
+	s.reqStore(c).ResetTwoFA(user.ID)
+	c.Redirect(http.StatusFound, "/admin")
+}
+
+func (s *server) adminRoleHandler(c *gin.Context) {
+	user, ok := s.adminTarget(c)
+	if !ok {
+		return
+	}
+	role := c.PostForm("role")
+	if _, valid := roleRank[role]; !valid {
+		c.String(http.StatusBadRequest, "unknown role")
+		return
+	}
+	s.reqStore(c).UpdateUser(user.ID, map[string]any{"role": role})
+	c.Redirect(http.StatusFound, "/admin")
+}
+
+// ==================== ADMIN: RUNS ====================
+type adminRunsView struct {
+	page
+	IsAdmin bool
+	Runs    []RunWithUser
+}
+
+func (s *server) adminRunsPage(c *gin.Context) {
+	// Highest HR first by default: that is where leaderboard cheating shows up.
+	filter := RunFilter{HiddenOnly: c.Query("hidden") == "1", Recent: c.Query("sort") == "recent", Limit: 200}
+	if uid, err := strconv.Atoi(c.Query("user")); err == nil {
+		filter.UserID = uint(uid)
+	}
+	runs, err := s.reqStore(c).ListRuns(filter)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "runs: %v", err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin_runs", adminRunsView{
+		page:    newPage(c, "title.admin_runs"),
+		IsAdmin: c.GetString("role") == RoleAdmin,
+		Runs:    runs,
+	})
+}
+
+func (s *server) adminHideRunHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	hidden := strings.HasSuffix(c.FullPath(), "/hide")
+	s.reqStore(c).SetRunHidden(uint(id), hidden)
+	if hidden {
+		s.resolveFlags(c, uint(id), "hidden")
+	}
+	s.leaderboardChanged()
+	c.Redirect(http.StatusFound, adminBack(c))
+}
+
+func (s *server) adminDeleteRunHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	s.reqStore(c).DeleteRun(uint(id))
+	s.leaderboardChanged()
+	c.Redirect(http.StatusFound, adminBack(c))
+}
+
+// adminBack returns to the admin list the action came from. Only a Referer
+// on this host under /admin/runs or /admin/flags counts, and only its path
+// and query are kept, so the header cannot send a moderator elsewhere.
+func adminBack(c *gin.Context) string {
+	ref, err := url.Parse(c.Request.Referer())
+	if err != nil || (ref.Host != "" && ref.Host != c.Request.Host) {
+		return "/admin/runs"
+	}
+	for _, list := range []string{"/admin/runs", "/admin/flags"} {
+		if ref.Path == list || strings.HasPrefix(ref.Path, list+"/") {
+			return (&url.URL{Path: ref.Path, RawQuery: ref.RawQuery}).String()
+		}
+	}
+	return "/admin/runs"
+}
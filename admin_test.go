@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// admin signs in a fresh user and promotes them to role.
+func (ts testServer) admin(t *testing.T, name, role string) *testClient {
+	c := ts.user(t, name)
+	user, _ := ts.store.UserByUsername(name)
+	if err := ts.store.UpdateUser(user.ID, map[string]any{"role": role}); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestBanSignsOut(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		mod := ts.admin(t, "mod", RoleModerator)
+		bob := ts.user(t, "bob")
+		target, _ := ts.store.UserByUsername("bob")
+
+		// Moderators cannot act on their peers or themselves.
+		peer := ts.admin(t, "peer", RoleModerator)
+		self, _ := ts.store.UserByUsername("peer")
+		if res := peer.post(fmt.Sprintf("/admin/users/%d/ban", self.ID), nil); res.Status != http.StatusForbidden {
+			t.Errorf("self-ban: %d", res.Status)
+		}
+		if bob.get("/admin").Status != http.StatusForbidden {
+			t.Error("a plain user reached /admin")
+		}
+
+		if res := mod.post(fmt.Sprintf("/admin/users/%d/ban", target.ID), nil); res.Location != "/admin" {
+			t.Fatalf("ban: %d %s", res.Status, res.Location)
+		}
+		if res := bob.get("/dashboard"); res.Location != "/login" {
+			t.Errorf("banned session still works: %d %s", res.Status, res.Location)
+		}
+		if res := bob.post("/login", url.Values{"username": {"bob"}, "password": {testPassword}}); res.Status != http.StatusForbidden {
+			t.Errorf("banned login: %d", res.Status)
+		}
+
+		mod.post(fmt.Sprintf("/admin/users/%d/unban", target.ID), nil)
+		if res := bob.post("/login", url.Values{"username": {"bob"}, "password": {testPassword}}); res.Location != "/dashboard" {
+			t.Errorf("login after unban: %d %s", res.Status, res.Location)
+		}
+	})
+}
+
+func TestHiddenRunLeavesLeaderboard(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		mod := ts.admin(t, "mod", RoleModerator)
+		bob := ts.user(t, "bob")
+		bob.post("/log-run", url.Values{"area": {"Mephisto"}, "difficulty": {"Hell"}, "runes": {`[{"rune":"Jah","qty":1}]`}})
+
+		user, _ := ts.store.UserByUsername("bob")
+		runs, err := ts.store.RunsByUser(user.ID)
+		if err != nil || len(runs) != 1 {
+			t.Fatalf("runs = %+v, %v", runs, err)
+		}
+		if rows, _ := ts.store.Leaderboard(LeaderboardFilter{}, 10); len(rows) != 1 {
+			t.Fatalf("leaderboard before hiding = %+v", rows)
+		}
+
+		mod.post(fmt.Sprintf("/admin/runs/%d/hide", runs[0].ID), nil)
+		if rows, _ := ts.store.Leaderboard(LeaderboardFilter{}, 10); len(rows) != 0 {
+			t.Errorf("leaderboard after hiding = %+v", rows)
+		}
+		mod.post(fmt.Sprintf("/admin/runs/%d/unhide", runs[0].ID), nil)
+		if rows, _ := ts.store.Leaderboard(LeaderboardFilter{}, 10); len(rows) != 1 {
+			t.Errorf("leaderboard after unhiding = %+v", rows)
+		}
+	})
+}
+
+// After an action the moderator goes back to the list they came from, but
+// never off the site.
+func TestAdminBackStaysOnSite(t *testing.T) {
+	ts := newTestServer(t, newMemStore())
+	mod := ts.admin(t, "mod", RoleModerator)
+	mod.post("/log-run", url.Values{"area": {"Mephisto"}, "difficulty": {"Hell"}})
+	user, _ := ts.store.UserByUsername("mod")
+	runs, _ := ts.store.RunsByUser(user.ID)
+	if len(runs) != 1 {
+		t.Fatalf("runs = %+v", runs)
+	}
+
+	for referer, want := range map[string]string{
+		ts.URL + "/admin/flags":             "/admin/flags",
+		ts.URL + "/admin/runs?user=bob&p=2": "/admin/runs?user=bob&p=2",
+		"/admin/runs/":                      "/admin/runs/",
+		"":                                  "/admin/runs",
+		ts.URL + "/dashboard":               "/admin/runs",
+		ts.URL + "/admin/runsx":             "/admin/runs",
+		"https://evil.example/admin/flags":  "/admin/runs",
+		"https://evil.example/?/admin/runs": "/admin/runs",
+		"//evil.example/admin/runs":         "/admin/runs",
+		"javascript:alert(1)//admin/runs":   "/admin/runs",
+	} {
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/admin/runs/%d/hide", ts.URL, runs[0].ID), nil)
+		req.Header.Set("Referer", referer)
+		if res := mod.do(req); res.Status != http.StatusFound || res.Location != want {
+			t.Errorf("Referer %q: %d %q, want %q", referer, res.Status, res.Location, want)
+		}
+	}
+}
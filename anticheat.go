@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// RunFlag records why the anti-cheat checks put a run up for review. A run
+// with any unresolved flag has Run.Flagged set and is left out of the
+// leaderboard until a moderator clears or hides it.
+type RunFlag struct {
+	ID         uint `gorm:"primaryKey"`
+	RunID      uint `gorm:"index"`
+	Rule       string
+	Detail     string // JSON arguments of the admin.flag.<rule> message
+	CreatedAt  time.Time
+	ReviewedBy *uint
+	Resolution string // "", "cleared" or "hidden"
+}
+
+const (
+	maxRunsPerHour  = 120 // a 30-second Pindleskin loop, with no town time
+	burstWindow     = time.Minute
+	maxRunsPerBurst = 6
+	maxHRPerRun     = 3
+	statsWindowRuns = 200
+	statsMinRuns    = 20
+	statsPThreshold = 1e-6
+	baselineMinRuns = 500
+	defaultHRPerRun = 0.05 // generous prior while the community baseline is thin
+)
+
+// runeCapByArea is the highest rune an area's treasure class can drop, by
+// difficulty. Anything above it is not bad luck, it is impossible.
+//
+// Only the Countess is listed, on purpose: her runes come from her own
+// treasure class with a fixed ceiling per difficulty. Every other area draws
+// runes from its monsters' level-based classes, so one cap per difficulty
+// would either wrongly flag honest drops or sit too high to catch anything.
+// Those areas are left to the hr_per_run and hr_statistics rules.
+var runeCapByArea = map[string]map[string]string{
+	"Countess (Hrabina)": {"Normal": "Ral", "Nightmare": "Io", "Hell": "Ist"},
+}
+
+var runeIndex = func() map[string]int {
+	m := make(map[string]int, len(runeOrder))
+	for i, r := range runeOrder {
+		m[r] = i
+	}
+	return m
+}()
+
+// inspectRun runs every anti-cheat rule against a freshly stored run and
+// flags it if any of them fire. It never rejects the submission: false
+// positives are resolved by a moderator, not by the player losing data.
+func (s *server) inspectRun(ctx context.Context, run Run, drops []RuneDrop) []RunFlag {
+	store := s.store.WithContext(ctx)
+	var flags []RunFlag
+	add := func(rule string, args ...any) {
+		detail, _ := json.Marshal(args)
+		flags = append(flags, RunFlag{RunID: run.ID, Rule: rule, Detail: string(detail)})
+	}
+
+	// The rate rules count everything logged since shortly before the run,
+	// which only describes the run if it was logged just now, not imported.
+	if run.ExternalID == "" {
+		lastHour, _ := store.CountRunsSince(run.UserID, run.Timestamp.Add(-time.Hour))
+		lastBurst, _ := store.CountRunsSince(run.UserID, run.Timestamp.Add(-burstWindow))
+		if lastHour > maxRunsPerHour {
+			add("runs_per_hour", lastHour, maxRunsPerHour)
+		}
+		if lastBurst > maxRunsPerBurst {
+			add("burst", lastBurst, burstWindow.Seconds())
+		}
+	}
+
+	if run.HRCount > maxHRPerRun {
+		add("hr_per_run", run.HRCount)
+	}
+	if caps, ok := runeCapByArea[run.Area]; ok {
+		if top, ok := caps[run.Difficulty]; ok {
+			for _, d := range drops {
+				if i, known := runeIndex[d.Rune]; !known || i > runeIndex[top] {
+					add("treasure_class", d.Rune, top)
+				}
+			}
+		}
+	}
+
+	if p, mine, expected := s.hrLuckPValue(ctx, run); p < statsPThreshold {
+		add("hr_statistics", mine.HR, mine.Runs, strconv.FormatFloat(expected, 'f', 1, 64), strconv.FormatFloat(p, 'e', 1, 64))
+	}
+
+	if len(flags) == 0 {
+		return nil
+	}
+	if err := store.FlagRun(run.ID, flags); err != nil {
+		ctxLog(ctx).Error("anti-cheat: storing flags failed", "run_id", run.ID, "user_id", run.UserID, "err", err)
+	}
+	return flags
+}
+
+// hrLuckPValue asks how likely the player's recent HR haul in this area is
+// if they were dropping at the community rate, and returns that haul with
+// the HR the rate predicts for it. The rate comes from unflagged, visible
+// runs by other players and is never below defaultHRPerRun.
+func (s *server) hrLuckPValue(ctx context.Context, run Run) (float64, RunHR, float64) {
+	store := s.store.WithContext(ctx)
+	mine, err := store.RecentAreaHR(run.UserID, run.Area, run.Difficulty, statsWindowRuns)
+	if err != nil || mine.Runs < statsMinRuns || mine.HR == 0 {
+		return 1, mine, 0
+	}
+	base, err := store.AreaBaseline(run.UserID, run.Area, run.Difficulty)
+	if err != nil {
+		return 1, mine, 0
+	}
+	rate := defaultHRPerRun
+	if base.Runs >= baselineMinRuns {
+		rate = math.Max(rate, float64(base.HR)/float64(base.Runs))
+	}
+
+	expected := rate * float64(mine.Runs)
+	return poissonTail(int(mine.HR), expected), mine, expected
+}
+
+// poissonTail returns P(X >= k) for X ~ Poisson(lambda).
+func poissonTail(k int, lambda float64) float64 {
+	if k <= 0 {
+		return 1
+	}
+	term := math.Exp(-lambda)
+	cdf := term
+	for i := 1; i < k; i++ {
+		term *= lambda / float64(i)
+		cdf += term
+	}
+	return math.Max(0, 1-cdf)
+}
+
+// ==================== ADMIN: FLAGS ====================
+type adminFlagsView struct {
+	page
+	Runs    []RunWithUser
+	Reasons map[uint][]RunFlag // open flags by run
+}
+
+// Reason is a flag's detail in the reader's language.
+func (v adminFlagsView) Reason(f RunFlag) string {
+	var args []any
+	if err := json.Unmarshal([]byte(f.Detail), &args); err != nil {
+		return v.T("admin.flag.unknown")
+	}
+	return v.T("admin.flag."+f.Rule, args...)
+}
+
+func (s *server) adminFlagsPage(c *gin.Context) {
+	runs, err := s.reqStore(c).ListRuns(RunFilter{FlaggedOnly: true, Recent: true, Limit: 200})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "flags: %v", err)
+		return
+	}
+
+	ids := make([]uint, len(runs))
+	for i, r := range runs {
+		ids[i] = r.ID
+	}
+	flags, _ := s.reqStore(c).OpenFlags(ids)
+	reasons := map[uint][]RunFlag{}
+	for _, f := range flags {
+		reasons[f.RunID] = append(reasons[f.RunID], f)
+	}
+	c.HTML(http.StatusOK, "admin_flags", adminFlagsView{page: newPage(c, "title.admin_flags"), Runs: runs, Reasons: reasons})
+}
+
+func (s *server) adminClearRunHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	s.resolveFlags(c, uint(id), "cleared")
+	s.leaderboardChanged()
+	c.Redirect(http.StatusFound, "/admin/flags")
+}
+
+// resolveFlags closes the open flags on a run and takes it out of the review
+// queue. Hiding is handled by the caller; this only records the outcome.
+func (s *server) resolveFlags(c *gin.Context, runID uint, resolution string) {
+	reviewer := sessions.Default(c).Get("user_id").(uint)
+	if err := s.reqStore(c).ResolveFlags(runID, resolution, reviewer); err != nil {
+		reqLog(c).Error("anti-cheat: resolving flags failed", "run_id", runID, "err", err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTreasureClassCap(t *testing.T) {
+	cfg := testConfig()
+	s := newServer(&cfg, newMemStore())
+	user := User{Username: "alice"}
+	if err := s.store.CreateUser(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		area, difficulty, rune string
+		flagged                bool
+	}{
+		{"Countess (Hrabina)", "Hell", "Ist", false},
+		{"Countess (Hrabina)", "Hell", "Ber", true},
+		{"Countess (Hrabina)", "Normal", "Ral", false},
+		{"Countess (Hrabina)", "Normal", "Ort", true},
+		{"Countess (Hrabina)", "Nightmare", "Nope", true},
+		// Uncapped on purpose, see runeCapByArea.
+		{"Pindleskin", "Normal", "Zod", false},
+	}
+	for i, tc := range cases {
+		run := Run{UserID: user.ID, Area: tc.area, Difficulty: tc.difficulty, Timestamp: time.Now(),
+			ExternalID: fmt.Sprint("import-", i)}
+		drops := []RuneDrop{{Rune: tc.rune, Qty: 1}}
+		if err := s.store.CreateRun(&run, drops); err != nil {
+			t.Fatal(err)
+		}
+		got := false
+		for _, f := range s.inspectRun(context.Background(), run, drops) {
+			got = got || f.Rule == "treasure_class"
+		}
+		if got != tc.flagged {
+			t.Errorf("%s %s %s: flagged=%v, want %v", tc.area, tc.difficulty, tc.rune, got, tc.flagged)
+		}
+	}
+}
+
+func TestFlagReasonIsTranslated(t *testing.T) {
+	cfg := testConfig()
+	s := newServer(&cfg, newMemStore())
+	run := Run{UserID: 1, Area: "Mephisto", Difficulty: "Hell", HRCount: 5, Timestamp: time.Now(), ExternalID: "import-1"}
+	flags := s.inspectRun(context.Background(), run, nil)
+	if len(flags) != 1 {
+		t.Fatalf("flags = %+v", flags)
+	}
+
+	for lang, want := range map[string]string{"en": "5 HR in a single run", "pl": "5 HR w jednej rundzie"} {
+		view := adminFlagsView{page: page{localizer: newLocalizer(lang)}}
+		if got := view.Reason(flags[0]); got != want {
+			t.Errorf("%s: %q, want %q", lang, got, want)
+		}
+	}
+	// A detail that is not an argument list shows no raw text.
+	garbled := RunFlag{Rule: "hr_per_run", Detail: "5 HR w jednej rundzie"}
+	if got := (adminFlagsView{page: page{localizer: newLocalizer("en")}}).Reason(garbled); got != "Unknown reason" {
+		t.Errorf("unreadable detail = %q", got)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== STATIC ASSETS ====================
+
+//go:embed static
+var staticFiles embed.FS
+
+// staticAsset is one embedded file. Pages link to it by a URL carrying a hash
+// of its content, so it can be cached forever and a new build busts it.
+type staticAsset struct {
+	body  []byte
+	etag  string
+	ctype string
+	url   string // /static/css/app.<hash>.css
+}
+
+var (
+	staticAssets = loadStaticAssets() // by name relative to static/, e.g. "css/app.css"
+	staticByURL  = indexStaticURLs(staticAssets)
+)
+
+func loadStaticAssets() map[string]*staticAsset {
+	assets := map[string]*staticAsset{}
+	err := fs.WalkDir(staticFiles, "static", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		body, err := staticFiles.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])[:10]
+		name := strings.TrimPrefix(p, "static/")
+		ext := path.Ext(name)
+		ctype := mime.TypeByExtension(ext)
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		assets[name] = &staticAsset{
+			body:  body,
+			etag:  `"` + hash + `"`,
+			ctype: ctype,
+			url:   "/static/" + strings.TrimSuffix(name, ext) + "." + hash + ext,
+		}
+		return nil
+	})
+	if err != nil {
+		panic("static assets: " + err.Error())
+	}
+	return assets
+}
+
+func indexStaticURLs(assets map[string]*staticAsset) map[string]*staticAsset {
+	byURL := make(map[string]*staticAsset, len(assets))
+	for _, a := range assets {
+		byURL[a.url] = a
+	}
+	return byURL
+}
+
+// asset returns the content-hashed URL of an embedded file. Unknown names
+// fall back to the plain path, which staticHandler answers with a 404.
+func asset(name string) string {
+	if a, ok := staticAssets[name]; ok {
+		return a.url
+	}
+	return "/static/" + name
+}
+
+// staticHandler serves embedded files. Hashed URLs are immutable; the plain
+// path still works for anything linking to it directly, but must revalidate.
+func staticHandler(c *gin.Context) {
+	p := c.Request.URL.Path
+	a, hashed := staticByURL[p]
+	if !hashed {
+		a = staticAssets[strings.TrimPrefix(p, "/static/")]
+	}
+	if a == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if hashed {
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		c.Header("Cache-Control", "public, no-cache")
+	}
+	c.Header("ETag", a.etag)
+	c.Header("Content-Type", a.ctype)
+	c.Header("X-Content-Type-Options", "nosniff")
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(a.body))
+}
+
+// runeIconURLs points every rune at its embedded icon.
+func runeIconURLs() map[string]string {
+	icons := make(map[string]string, len(runeOrder))
+	for _, r := range runeOrder {
+		icons[r] = asset("runes/" + r + ".svg")
+	}
+	return icons
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Benchmarks and query plan checks, run from the CLI:
+//
+//	bench-queries [n]      stats queries over n seeded runs, then explain
+//	explain                query plans of the configured database
+//
+// The leaderboard benchmarks are BenchmarkLeaderboard* in leaderboard_test.go.
+
+const benchUsers = 1000
+
+// benchDB opens a throwaway SQLite database at the current schema and seeds
+// it with n runs. The returned func removes it.
+func benchDB(n int) (*gorm.DB, func()) {
+	dir, err := os.MkdirTemp("", "d2r-bench")
+	if err != nil {
+		fatal("bench: database setup failed", "err", err)
+	}
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "bench.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		fatal("bench: database setup failed", "err", err)
+	}
+	if _, err := migrateUp(db); err != nil {
+		fatal("bench: database setup failed", "err", err)
+	}
+
+	start := time.Now()
+	if err := seedRuns(db, benchUsers, n); err != nil {
+		fatal("bench: seeding failed", "err", err)
+	}
+	fmt.Printf("%-20s %s\n", "seed", newLocalizer(defaultLocale).T("cli.bench_seeded", n, time.Since(start).Round(time.Millisecond)))
+	return db, func() { os.RemoveAll(dir) }
+}
+
+// benchQueries times the per-user and anti-cheat queries that hit the runs
+// and rune_drops tables, then checks their plans.
+func benchQueries(n int) {
+	db, cleanup := benchDB(n)
+	defer cleanup()
+
+	store := newGormStore(db)
+	user := func() uint { return uint(1 + rand.Intn(benchUsers)) }
+	timeIt("RunsByUser", 20, func() { store.RunsByUser(user()) })
+	timeIt("DropsByUser", 20, func() { store.DropsByUser(user()) })
+	timeIt("CountRunsSince", 200, func() { store.CountRunsSince(user(), time.Now().Add(-time.Hour)) })
+	timeIt("RecentAreaHR", 200, func() { store.RecentAreaHR(user(), "Chaos Sanctuary", "Hell", statsWindowRuns) })
+	timeIt("AreaBaseline", 20, func() { store.AreaBaseline(user(), "Chaos Sanctuary", "Hell") })
+	timeIt("Leaderboard (filtr)", 5, func() {
+		store.Leaderboard(LeaderboardFilter{Area: "Chaos Sanctuary", Difficulty: "Hell"}, leaderboardSize)
+	})
+
+	if bad, err := checkQueryPlans(db, os.Stdout); err != nil || len(bad) > 0 {
+		fatal("bench: full scans", "queries", bad, "err", err)
+	}
+}
+
+// seedRuns inserts users bench0..bench<users-1> and n random runs spread
+// over the last year, with a drop for every HR run. It writes the tables
+// directly, so the daily rollup stays empty.
+func seedRuns(db *gorm.DB, users, n int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		batch := make([]User, users)
+		for i := range batch {
+			batch[i] = User{Username: fmt.Sprintf("bench%d", i), Role: RoleUser}
+		}
+		if err := tx.CreateInBatches(batch, 500).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		runs := make([]Run, 0, 1000)
+		var drops []RuneDrop
+		for i := 0; i < n; i++ {
+			hr := 0
+			if rand.Intn(20) == 0 {
+				hr = 1
+			}
+			runs = append(runs, Run{
+				UserID:     batch[rand.Intn(users)].ID,
+				Area:       areas[rand.Intn(len(areas))],
+				Difficulty: difficulties[rand.Intn(len(difficulties))],
+				Uniques:    rand.Intn(4),
+				Sets:       rand.Intn(2),
+				HRCount:    hr,
+				SessionSec: 60 + rand.Intn(180),
+				Timestamp:  now.Add(-time.Duration(rand.Int63n(int64(365 * 24 * time.Hour)))),
+			})
+			if len(runs) < cap(runs) && i < n-1 {
+				continue
+			}
+			if err := tx.Create(&runs).Error; err != nil {
+				return err
+			}
+			drops = drops[:0]
+			for _, r := range runs {
+				if r.HRCount > 0 {
+					drops = append(drops, RuneDrop{RunID: r.ID, Rune: runeOrder[len(runeOrder)-1-rand.Intn(12)], Qty: 1})
+				}
+			}
+			if len(drops) > 0 {
+				if err := tx.Create(&drops).Error; err != nil {
+					return err
+				}
+			}
+			runs = runs[:0]
+		}
+		return nil
+	})
+}
+
+func timeIt(name string, reps int, fn func()) {
+	start := time.Now()
+	for i := 0; i < reps; i++ {
+		fn()
+	}
+	fmt.Printf("%-20s %10s/op (%d×)\n", name, time.Since(start)/time.Duration(reps), reps)
+}
+
+// ==================== QUERY PLANS ====================
+
+// planChecks call the store's queries on runs and rune_drops that must stay
+// off a full table scan as history grows. They run against a dry-run session,
+// so the plans are for exactly the SQL the store sends.
+var planChecks = []struct {
+	name string
+	call func(s *gormStore)
+}{
+	{"CountRunsSince", func(s *gormStore) { s.CountRunsSince(1, time.Unix(0, 0)) }},
+	{"RunsByUser", func(s *gormStore) { s.RunsByUser(1) }},
+	{"RunsPage", func(s *gormStore) {
+		s.RunsPage(RunFilter{UserID: 1, Limit: 500}, Run{ID: 1, Timestamp: time.Unix(0, 0)})
+	}},
+	{"ExternalRunIDs", func(s *gormStore) { s.ExternalRunIDs(1, []string{"a", "b"}) }},
+	{"RecentAreaHR", func(s *gormStore) { s.RecentAreaHR(1, "Chaos Sanctuary", "Hell", statsWindowRuns) }},
+	{"AreaBaseline", func(s *gormStore) { s.AreaBaseline(1, "Chaos Sanctuary", "Hell") }},
+	{"DropsByUser", func(s *gormStore) { s.DropsByUser(1) }},
+	{"DropsByRuns", func(s *gormStore) { s.DropsByRuns([]uint{1, 2}) }},
+	{"FirstDrops", func(s *gormStore) { s.FirstDrops(1, 2, []string{"Ber", "Jah"}) }},
+}
+
+// storeStatements returns the SQL call would send through db, arguments
+// inlined, without running any of it.
+func storeStatements(db *gorm.DB, call func(*gormStore)) []string {
+	rec := &sqlRecorder{}
+	call(newGormStore(db.Session(&gorm.Session{DryRun: true, Logger: rec})))
+	return rec.statements
+}
+
+// sqlRecorder is a gorm logger that keeps every traced statement.
+type sqlRecorder struct {
+	statements []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface { return r }
+func (r *sqlRecorder) Info(context.Context, string, ...any)     {}
+func (r *sqlRecorder) Warn(context.Context, string, ...any)     {}
+func (r *sqlRecorder) Error(context.Context, string, ...any)    {}
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+// fullScan matches a plan line that reads runs or rune_drops front to back:
+// "SCAN runs" in SQLite (without USING INDEX), "Seq Scan on runs" in Postgres.
+var fullScan = regexp.MustCompile(`(?m)(SCAN (TABLE )?(runs|rune_drops)( AS \w+)?\s*$|Seq Scan on (runs|rune_drops)\b)`)
+
+// checkQueryPlans writes the plan of every planCheck to out and returns the
+// names of those that fall back to a full scan.
+func checkQueryPlans(db *gorm.DB, out io.Writer) ([]string, error) {
+	var bad []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		prefix := "EXPLAIN QUERY PLAN "
+		if tx.Dialector.Name() == "postgres" {
+			prefix = "EXPLAIN "
+			// On small tables Postgres rightly prefers a seq scan; with it
+			// discouraged the plan shows whether an index can be used at all.
+			if err := tx.Exec("SET LOCAL enable_seqscan = off").Error; err != nil {
+				return err
+			}
+		}
+		for _, pc := range planChecks {
+			statements := storeStatements(tx, pc.call)
+			if len(statements) == 0 {
+				return fmt.Errorf("%s: the store sent no query", pc.name)
+			}
+			var plan []string
+			for _, sql := range statements {
+				lines, err := explain(tx, prefix+sql)
+				if err != nil {
+					return fmt.Errorf("%s: %w", pc.name, err)
+				}
+				plan = append(plan, lines...)
+			}
+
+			text := strings.Join(plan, "\n")
+			status := "✅"
+			if fullScan.MatchString(text) {
+				status = "❌"
+				bad = append(bad, pc.name)
+			}
+			fmt.Fprintf(out, "%s %s\n   %s\n", status, pc.name, strings.ReplaceAll(text, "\n", "\n   "))
+		}
+		return nil
+	})
+	return bad, err
+}
+
+// explain returns the plan lines of an EXPLAIN statement; the plan text is
+// the last column in both drivers.
+func explain(tx *gorm.DB, sql string) ([]string, error) {
+	rows, err := tx.Raw(sql).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, _ := rows.Columns()
+	var plan []string
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		plan = append(plan, fmt.Sprint(vals[len(vals)-1]))
+	}
+	return plan, rows.Err()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// D2R_TEST_POSTGRES_DSN points the plan checks at a disposable Postgres
+// database as well; it is migrated up and left in place.
+const postgresDSNEnv = "D2R_TEST_POSTGRES_DSN"
+
+func TestQueryPlans(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		store := testGormStore(t)
+		if err := seedRuns(store.db, 20, 2000); err != nil {
+			t.Fatal(err)
+		}
+		testQueryPlans(t, store.db)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv(postgresDSNEnv)
+		if dsn == "" {
+			t.Skip(postgresDSNEnv + " not set")
+		}
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := migrateUp(db); err != nil {
+			t.Fatal(err)
+		}
+		testQueryPlans(t, db)
+	})
+}
+
+func testQueryPlans(t *testing.T, db *gorm.DB) {
+	var out strings.Builder
+	bad, err := checkQueryPlans(db, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bad) > 0 {
+		t.Errorf("full scans in %v:\n%s", bad, out.String())
+	}
+}
+
+// The plans are only worth something if they are for the statements the
+// store really sends.
+func TestStoreStatements(t *testing.T) {
+	db := testGormStore(t).db
+	got := storeStatements(db, func(s *gormStore) { s.DropsByUser(7) })
+	if len(got) != 1 || !strings.Contains(got[0], "rune_drops") || !strings.Contains(got[0], "user_id = 7") {
+		t.Fatalf("DropsByUser statements = %q", got)
+	}
+	var runs int64
+	db.Model(&Run{}).Count(&runs)
+	storeStatements(db, func(s *gormStore) { s.CreateRun(&Run{UserID: 1}, nil) })
+	var after int64
+	db.Model(&Run{}).Count(&after)
+	if after != runs {
+		t.Errorf("a dry run wrote %d runs", after-runs)
+	}
+}
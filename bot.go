@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== CHAT BOT ====================
+
+// Discord and Slack POST their slash commands to /bot/discord and /bot/slack,
+// signed with the app's key. Both envelopes are peeled into a botCommand and
+// answered by botAnswer, which logs runs through logRun and reads totals the
+// way the dashboard does. A chat user must first link their account: /link
+// answers with a signed URL that, opened while signed in, stores a ChatLink.
+
+// ChatLink ties a chat platform's user ID to a local User.
+type ChatLink struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index"`
+	Platform   string `gorm:"uniqueIndex:idx_chat_links_platform_user"`
+	ChatUserID string `gorm:"uniqueIndex:idx_chat_links_platform_user"`
+	ChatName   string // the chat display name when linked, for /account
+	CreatedAt  time.Time
+}
+
+const (
+	platformDiscord = "discord"
+	platformSlack   = "slack"
+)
+
+var botPlatformNames = map[string]string{platformDiscord: "Discord", platformSlack: "Slack"}
+
+func (c ChatLink) PlatformName() string { return botPlatformNames[c.Platform] }
+
+const (
+	botClockSkew = 5 * time.Minute  // oldest signed request we still answer
+	botLinkTTL   = 15 * time.Minute // how long a /link URL works
+	botMaxBody   = 64 << 10
+)
+
+// botCommands are the command names; anything else is read as an umbrella
+// command ("/d2r run ...") whose first word names the command.
+var botCommands = []string{"run", "stats", "link", "help"}
+
+// botCommand is a slash command with the platform envelope taken off.
+type botCommand struct {
+	Platform   string
+	ChatUserID string
+	ChatName   string
+	Locale     string // the chat client's language when the platform sends it
+	Name       string
+	Args       string
+}
+
+func newBotCommand(platform, name, args string) botCommand {
+	name = strings.ToLower(strings.TrimPrefix(name, "/"))
+	args = strings.TrimSpace(args)
+	if !slices.Contains(botCommands, name) {
+		first, rest, _ := strings.Cut(args, " ")
+		name, args = strings.ToLower(first), strings.TrimSpace(rest)
+	}
+	return botCommand{Platform: platform, Name: name, Args: args}
+}
+
+// botReply is the answer text; Public replies are shown to the whole
+// channel, the rest only to whoever typed the command.
+type botReply struct {
+	Text   string
+	Public bool
+}
+
+// ==================== CHAT BOT: COMMANDS ====================
+func (s *server) botAnswer(ctx context.Context, cmd botCommand) botReply {
+	store := s.store.WithContext(ctx)
+	l := newLocalizer(cmd.Locale)
+	var user User
+	link, err := store.ChatLinkByChatUser(cmd.Platform, cmd.ChatUserID)
+	if err == nil {
+		user, err = store.UserByID(link.UserID)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return botReply{Text: l.T("bot.err.failed")}
+	}
+	linked := err == nil
+	if linked && user.Locale != "" {
+		l = newLocalizer(user.Locale)
+	}
+
+	switch cmd.Name {
+	case "link":
+		if linked {
+			return botReply{Text: l.T("bot.linked_as", user.Username, s.botLinkURL(cmd))}
+		}
+		return botReply{Text: l.T("bot.link", s.botLinkURL(cmd))}
+	case "run", "stats":
+		if !linked {
+			return botReply{Text: l.T("bot.not_linked", s.botLinkURL(cmd))}
+		}
+		if user.Banned {
+			return botReply{Text: l.T("bot.err.banned")}
+		}
+		if cmd.Name == "stats" {
+			return s.botStats(ctx, l, user)
+		}
+		return s.botRun(ctx, l, user, cmd.Args)
+	}
+	return botReply{Text: l.T("bot.help")}
+}
+
+func (s *server) botStats(ctx context.Context, l *localizer, user User) botReply {
+	t, err := s.store.WithContext(ctx).UserTotals(user.ID)
+	if err != nil {
+		return botReply{Text: l.T("bot.err.failed")}
+	}
+	avgHR, efficiency := t.rates()
+	return botReply{Text: l.T("bot.stats", user.Username, l.N("runs", t.Runs), l.Num(t.HR), l.Dec(avgHR, 2), l.Dec(efficiency, 1), l.Num(t.Uniques), l.Num(t.Sets)), Public: true}
+}
+
+func (s *server) botRun(ctx context.Context, l *localizer, user User, args string) botReply {
+	if args == "" {
+		return botReply{Text: l.T("bot.run_usage")}
+	}
+	area, diff, drops, err := parseBotRun(l, args)
+	if err != nil {
+		return botReply{Text: err.Error() + "\n" + l.T("bot.run_usage")}
+	}
+	logged, err := s.logRun(ctx, Run{UserID: user.ID, Area: area, Difficulty: diff}, drops)
+	if err != nil {
+		return botReply{Text: l.T("bot.err.failed")}
+	}
+
+	found := l.T("bot.no_drops")
+	if len(drops) > 0 {
+		names := make([]string, len(drops))
+		for i, d := range drops {
+			names[i] = l.T("webhook.msg.rune_qty", d.Rune, d.Qty)
+		}
+		found = strings.Join(names, ", ")
+	}
+	text := l.T("bot.run_logged", user.Username, l.Area(area), l.Difficulty(diff), found, logged.Run.HRCount, l.N("runs", logged.Totals.Runs), l.Num(logged.Totals.HR))
+	if logged.Run.Flagged {
+		text += "\n" + l.T("bot.run_flagged")
+	}
+	return botReply{Text: text, Public: true}
+}
+
+// botQty is a count written apart from its rune: "x2" after it, or "2x" /
+// "2" before it.
+var botQty = regexp.MustCompile(`^(?:[x×*](\d+)|(\d+)[x×*]?)$`)
+
+// parseBotRun reads "chaos hell ber 2x tal": an area, a difficulty (Hell
+// when left out, and allowed before the area too), then the drops. Names are
+// the importer's; an area may also be cut down to the start of any word of
+// its name as long as that leaves one area.
+func parseBotRun(l *localizer, args string) (area, diff string, drops []RuneDrop, err error) {
+	words := strings.FieldsFunc(args, func(r rune) bool { return r == ' ' || r == ',' || r == ';' })
+	diff = "Hell"
+	if len(words) > 0 {
+		if d, ok := importDifficultyNames[fold(words[0])]; ok {
+			diff, words = d, words[1:]
+		}
+	}
+	if len(words) == 0 {
+		return "", "", nil, errors.New(l.T("bot.err.no_area"))
+	}
+	// The longest run of leading words that names an area wins, so
+	// "lower kurast" is not read as "lower" plus a rune.
+	var candidates []string
+	for n := len(words); n > 0 && area == ""; n-- {
+		var found []string
+		if area, found = matchArea(strings.Join(words[:n], " ")); area != "" {
+			words = words[n:]
+		} else if n == 1 {
+			candidates = found
+		}
+	}
+	switch {
+	case area == "" && len(candidates) > 0:
+		names := make([]string, len(candidates))
+		for i, a := range candidates {
+			names[i] = l.Area(a)
+		}
+		return "", "", nil, errors.New(l.T("bot.err.area_ambiguous", words[0], strings.Join(names, ", ")))
+	case area == "":
+		return "", "", nil, errors.New(l.T("bot.err.area", words[0]))
+	}
+	if len(words) > 0 {
+		if d, ok := importDifficultyNames[fold(words[0])]; ok {
+			diff, words = d, words[1:]
+		}
+	}
+
+	counts := map[string]int{}
+	last, pending := "", 0
+	for _, w := range words {
+		if m := botQty.FindStringSubmatch(w); m != nil {
+			if m[2] != "" && pending == 0 {
+				pending, _ = strconv.Atoi(m[2])
+				continue
+			}
+			if n, _ := strconv.Atoi(m[1]); m[1] != "" && last != "" && n > 0 {
+				counts[last] += n - 1
+				last = ""
+				continue
+			}
+			return "", "", nil, errors.New(l.T("bot.err.rune", w))
+		}
+		one := map[string]int{}
+		if bad := parseRuneList(w, one); bad != "" || len(one) != 1 {
+			return "", "", nil, errors.New(l.T("bot.err.rune", w))
+		}
+		for r, qty := range one {
+			if pending > 0 {
+				qty, pending = qty*pending, 0
+			}
+			counts[r] += qty
+			last = r
+		}
+	}
+	// A count with nothing after it belongs to the rune before it: "ber 2".
+	if pending > 0 {
+		if last == "" {
+			return "", "", nil, errors.New(l.T("bot.err.rune", strconv.Itoa(pending)))
+		}
+		counts[last] += pending - 1
+	}
+	for _, r := range runeOrder {
+		if qty := counts[r]; qty > 0 {
+			if qty > importMaxQty {
+				return "", "", nil, errors.New(l.T("bot.err.rune", r))
+			}
+			drops = append(drops, RuneDrop{Rune: r, Qty: qty})
+		}
+	}
+	return area, diff, drops, nil
+}
+
+// matchArea finds the area phrase names, exactly or by the start of a word
+// of any of its names. When the phrase fits several areas it returns them
+// instead, in menu order.
+func matchArea(phrase string) (string, []string) {
+	f := fold(phrase)
+	if a, ok := importAreaNames[f]; ok {
+		return a, nil
+	}
+	var found []string
+	for _, a := range areas {
+		for name, v := range importAreaNames {
+			if v == a && (strings.HasPrefix(name, f) || strings.Contains(name, " "+f)) {
+				found = append(found, a)
+				break
+			}
+		}
+	}
+	if len(found) == 1 {
+		return found[0], nil
+	}
+	return "", found
+}
+
+// ==================== CHAT BOT: PLATFORMS ====================
+
+// botRequestFresh rejects replays of an old signed request.
+func botRequestFresh(timestamp string, now time.Time) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	return err == nil && now.Sub(time.Unix(sec, 0)).Abs() <= botClockSkew
+}
+
+func readBotBody(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, botMaxBody+1))
+	if err != nil || len(body) > botMaxBody {
+		c.String(http.StatusRequestEntityTooLarge, "request too large")
+		return nil, false
+	}
+	return body, true
+}
+
+// discordSigned checks X-Signature-Ed25519 over timestamp + body. Discord
+// probes the endpoint with bad signatures and expects a 401.
+func discordSigned(key ed25519.PublicKey, h http.Header, body []byte, now time.Time) bool {
+	sig, err := hex.DecodeString(h.Get("X-Signature-Ed25519"))
+	ts := h.Get("X-Signature-Timestamp")
+	return err == nil && len(sig) == ed25519.SignatureSize && botRequestFresh(ts, now) &&
+		ed25519.Verify(key, append([]byte(ts), body...), sig)
+}
+
+// Discord interaction and response types we use.
+const (
+	discordPing          = 1
+	discordCommand       = 2
+	discordPong          = 1
+	discordMessage       = 4
+	discordSubCommand    = 1
+	discordFlagEphemeral = 64
+)
+
+type discordInteraction struct {
+	Type   int    `json:"type"`
+	Locale string `json:"locale"` // the user's client language, e.g. "pl" or "en-US"
+	Data   struct {
+		Name    string          `json:"name"`
+		Options []discordOption `json:"options"`
+	} `json:"data"`
+	Member *struct {
+		User discordUser `json:"user"`
+	} `json:"member"` // set in servers
+	User *discordUser `json:"user"` // set in DMs
+}
+
+type discordUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+}
+
+type discordOption struct {
+	Name    string          `json:"name"`
+	Type    int             `json:"type"`
+	Value   json.RawMessage `json:"value"`
+	Options []discordOption `json:"options"`
+}
+
+// command flattens the interaction. A subcommand ("/d2r run") names the
+// command; option values are joined into the argument text, so "/run" may be
+// registered with one free-text option or with several.
+func (in discordInteraction) command() botCommand {
+	name, opts := in.Data.Name, in.Data.Options
+	if len(opts) == 1 && opts[0].Type == discordSubCommand {
+		name, opts = opts[0].Name, opts[0].Options
+	}
+	var args []string
+	for _, o := range opts {
+		var v any
+		if json.Unmarshal(o.Value, &v) == nil && v != nil {
+			args = append(args, fmt.Sprint(v))
+		}
+	}
+	cmd := newBotCommand(platformDiscord, name, strings.Join(args, " "))
+	user := in.User
+	if in.Member != nil {
+		user = &in.Member.User
+	}
+	if user != nil {
+		cmd.ChatUserID, cmd.ChatName = user.ID, cmp.Or(user.GlobalName, user.Username)
+	}
+	cmd.Locale, _, _ = strings.Cut(in.Locale, "-")
+	return cmd
+}
+
+func (s *server) discordBotHandler(c *gin.Context) {
+	body, ok := readBotBody(c)
+	if !ok {
+		return
+	}
+	key, _ := hex.DecodeString(s.cfg.Bot.DiscordPublicKey)
+	if !discordSigned(key, c.Request.Header, body, time.Now()) {
+		c.String(http.StatusUnauthorized, "invalid request signature")
+		return
+	}
+	var in discordInteraction
+	if err := json.Unmarshal(body, &in); err != nil {
+		c.String(http.StatusBadRequest, "interaction: %v", err)
+		return
+	}
+	switch in.Type {
+	case discordPing:
+		c.JSON(http.StatusOK, gin.H{"type": discordPong})
+		return
+	case discordCommand:
+	default:
+		c.String(http.StatusBadRequest, "unsupported interaction type %d", in.Type)
+		return
+	}
+	cmd := in.command()
+	if cmd.ChatUserID == "" {
+		c.String(http.StatusBadRequest, "interaction without a user")
+		return
+	}
+
+	reply := s.botAnswer(c.Request.Context(), cmd)
+	data := gin.H{"content": reply.Text, "allowed_mentions": gin.H{"parse": []string{}}}
+	if !reply.Public {
+		data["flags"] = discordFlagEphemeral
+	}
+	c.JSON(http.StatusOK, gin.H{"type": discordMessage, "data": data})
+}
+
+// slackSignature is Slack's v0 request signature over timestamp and body.
+func slackSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func slackSigned(secret string, h http.Header, body []byte, now time.Time) bool {
+	ts := h.Get("X-Slack-Request-Timestamp")
+	return botRequestFresh(ts, now) && hmac.Equal([]byte(h.Get("X-Slack-Signature")), []byte(slackSignature(secret, ts, body)))
+}
+
+func (s *server) slackBotHandler(c *gin.Context) {
+	body, ok := readBotBody(c)
+	if !ok {
+		return
+	}
+	if !slackSigned(s.cfg.Bot.SlackSigningSecret, c.Request.Header, body, time.Now()) {
+		c.String(http.StatusUnauthorized, "invalid request signature")
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil || form.Get("user_id") == "" {
+		c.String(http.StatusBadRequest, "malformed slash command")
+		return
+	}
+	cmd := newBotCommand(platformSlack, form.Get("command"), form.Get("text"))
+	// Slack user IDs are only promised to be unique within a workspace.
+	cmd.ChatUserID = form.Get("team_id") + "/" + form.Get("user_id")
+	cmd.ChatName = form.Get("user_name")
+
+	reply := s.botAnswer(c.Request.Context(), cmd)
+	kind := "ephemeral"
+	if reply.Public {
+		kind = "in_channel"
+	}
+	c.JSON(http.StatusOK, gin.H{"response_type": kind, "text": reply.Text})
+}
+
+// ==================== CHAT BOT: LINKING ====================
+
+// The /link URL carries the chat identity and an expiry, signed with a key
+// derived from the session key, so nothing is stored until the player
+// confirms it and a link token can never pass as a cookie signature.
+
+func (s *server) botLinkKey() []byte {
+	mac := hmac.New(sha256.New, s.cfg.sessionKeyPairs()[0])
+	mac.Write([]byte("d2r chat link"))
+	return mac.Sum(nil)
+}
+
+func (s *server) botLinkURL(cmd botCommand) string {
+	return s.cfg.BaseURL + "/account/chat/link?t=" + url.QueryEscape(s.botLinkToken(cmd, time.Now()))
+}
+
+func (s *server) botLinkToken(cmd botCommand, now time.Time) string {
+	clean := strings.NewReplacer("\n", " ", "\r", " ")
+	payload := strings.Join([]string{cmd.Platform, cmd.ChatUserID, clean.Replace(cmd.ChatName), strconv.FormatInt(now.Add(botLinkTTL).Unix(), 10)}, "\n")
+	mac := hmac.New(sha256.New, s.botLinkKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// readBotLinkToken returns the link a token describes, without a user yet.
+func (s *server) readBotLinkToken(token string, now time.Time) (ChatLink, bool) {
+	enc, sig, _ := strings.Cut(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return ChatLink{}, false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	mac := hmac.New(sha256.New, s.botLinkKey())
+	mac.Write(payload)
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return ChatLink{}, false
+	}
+	parts := strings.Split(string(payload), "\n")
+	if len(parts) != 4 || botPlatformNames[parts[0]] == "" {
+		return ChatLink{}, false
+	}
+	expires, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return ChatLink{}, false
+	}
+	return ChatLink{Platform: parts[0], ChatUserID: parts[1], ChatName: parts[2]}, true
+}
+
+type chatLinkView struct {
+	page
+	Token    string
+	Link     ChatLink
+	Username string
+}
+
+func (s *server) chatLinkPage(c *gin.Context) {
+	token := c.Query("t")
+	link, ok := s.readBotLinkToken(token, time.Now())
+	if !ok {
+		s.chatLinkExpired(c)
+		return
+	}
+	user, err := s.reqStore(c).UserByID(sessions.Default(c).Get("user_id").(uint))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/logout")
+		return
+	}
+	c.HTML(http.StatusOK, "chat_link", chatLinkView{page: newPage(c, "title.chat_link"), Token: token, Link: link, Username: user.Username})
+}
+
+func (s *server) chatLinkHandler(c *gin.Context) {
+	link, ok := s.readBotLinkToken(c.PostForm("t"), time.Now())
+	if !ok {
+		s.chatLinkExpired(c)
+		return
+	}
+	link.UserID = sessions.Default(c).Get("user_id").(uint)
+	link.CreatedAt = time.Now()
+	if err := s.reqStore(c).LinkChat(&link); err != nil {
+		s.renderAccount(c, http.StatusInternalServerError, accountError(c, "account.err.chat_link"))
+		return
+	}
+	s.renderAccount(c, http.StatusOK, accountNotice(c, "account.ok.chat_linked"))
+}
+
+func (s *server) chatLinkExpired(c *gin.Context) {
+	l := loc(c)
+	c.HTML(http.StatusBadRequest, "message", messageView{page: newPage(c, "title.chat_link"), Text: l.T("chat.link_expired"), Error: true, Link: "/account", LinkText: l.T("chat.back")})
+}
+
+// chatLinks lists the user's chat accounts for /account, when the bot is on.
+func (s *server) chatLinks(ctx context.Context, userID uint) []ChatLink {
+	if !s.cfg.Bot.Enabled() {
+		return nil
+	}
+	links, _ := s.store.WithContext(ctx).ChatLinksByUser(userID)
+	return links
+}
+
+func (s *server) chatUnlinkHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	s.reqStore(c).DeleteChatLink(userID, uint(id))
+	c.Redirect(http.StatusFound, "/account")
+}
+
+// ==================== CHAT BOT: CLI ====================
+
+// botCommandCLI signs a slash command the way Slack does and prints the
+// answer, to try the bot against a local server without a Slack app.
+func (s *server) botCommandCLI(args []string) {
+	l := newLocalizer(defaultLocale)
+	fs := flag.NewFlagSet("bot-command", flag.ExitOnError)
+	target := fs.String("url", s.cfg.BaseURL+"/bot/slack", l.T("cli.bot_flag_url"))
+	secret := fs.String("secret", s.cfg.Bot.SlackSigningSecret, l.T("cli.bot_flag_secret"))
+	user := fs.String("user", "U0LOCAL", l.T("cli.bot_flag_user"))
+	fs.Parse(args)
+	if fs.NArg() == 0 || *secret == "" {
+		fatal(l.T("cli.usage_bot_command"))
+	}
+
+	name, text, _ := strings.Cut(strings.Join(fs.Args(), " "), " ")
+	body := []byte(url.Values{"command": {name}, "text": {text}, "team_id": {"T0LOCAL"}, "user_id": {*user}, "user_name": {*user}}.Encode())
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, *target, bytes.NewReader(body))
+	if err != nil {
+		fatal("bot-command failed", "err", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", slackSignature(*secret, ts, body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fatal("bot-command failed", "err", err)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		ResponseType string `json:"response_type"`
+		Text         string `json:"text"`
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || json.Unmarshal(raw, &reply) != nil {
+		fatal("bot-command failed", "status", resp.Status, "body", string(raw))
+	}
+	fmt.Printf("[%s]\n%s\n", reply.ResponseType, reply.Text)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// botPost sends body to a bot endpoint with the given headers.
+func botPost(t *testing.T, ts testServer, path, body string, header map[string]string) testResponse {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return ts.client(t).do(req)
+}
+
+func TestDiscordSignature(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	ts := newTestServer(t, newMemStore(), func(cfg *Config) { cfg.Bot.DiscordPublicKey = hex.EncodeToString(pub) })
+
+	const ping = `{"type":1}`
+	signed := func(key ed25519.PrivateKey, at time.Time, body string) map[string]string {
+		stamp := strconv.FormatInt(at.Unix(), 10)
+		return map[string]string{
+			"X-Signature-Timestamp": stamp,
+			"X-Signature-Ed25519":   hex.EncodeToString(ed25519.Sign(key, []byte(stamp+body))),
+		}
+	}
+	if res := botPost(t, ts, "/bot/discord", ping, signed(key, time.Now(), ping)); res.Status != http.StatusOK || res.Body != `{"type":1}` {
+		t.Fatalf("signed ping: %d %s", res.Status, res.Body)
+	}
+	for name, header := range map[string]map[string]string{
+		"unsigned":       nil,
+		"garbage":        {"X-Signature-Timestamp": strconv.FormatInt(time.Now().Unix(), 10), "X-Signature-Ed25519": "zz"},
+		"other key":      signed(other, time.Now(), ping),
+		"stale":          signed(key, time.Now().Add(-botClockSkew-time.Minute), ping),
+		"future":         signed(key, time.Now().Add(botClockSkew+time.Minute), ping),
+		"tampered body":  signed(key, time.Now(), `{"type":2}`),
+		"timestamp swap": {"X-Signature-Timestamp": "1", "X-Signature-Ed25519": signed(key, time.Now(), ping)["X-Signature-Ed25519"]},
+	} {
+		if res := botPost(t, ts, "/bot/discord", ping, header); res.Status != http.StatusUnauthorized {
+			t.Errorf("%s: %d %s", name, res.Status, res.Body)
+		}
+	}
+}
+
+func TestSlackSignature(t *testing.T) {
+	const secret = "test-signing-secret"
+	ts := newTestServer(t, newMemStore(), func(cfg *Config) { cfg.Bot.SlackSigningSecret = secret })
+
+	const help = "command=%2Fd2r&text=help&team_id=T1&user_id=U1&user_name=alice"
+	signed := func(secret string, at time.Time, body string) map[string]string {
+		stamp := strconv.FormatInt(at.Unix(), 10)
+		return map[string]string{"X-Slack-Request-Timestamp": stamp, "X-Slack-Signature": slackSignature(secret, stamp, []byte(body))}
+	}
+	if res := botPost(t, ts, "/bot/slack", help, signed(secret, time.Now(), help)); res.Status != http.StatusOK {
+		t.Fatalf("signed command: %d %s", res.Status, res.Body)
+	}
+	for name, header := range map[string]map[string]string{
+		"unsigned":      nil,
+		"wrong secret":  signed("guess", time.Now(), help),
+		"stale":         signed(secret, time.Now().Add(-botClockSkew-time.Minute), help),
+		"tampered body": signed(secret, time.Now(), strings.Replace(help, "U1", "U2", 1)),
+	} {
+		if res := botPost(t, ts, "/bot/slack", help, header); res.Status != http.StatusUnauthorized {
+			t.Errorf("%s: %d %s", name, res.Status, res.Body)
+		}
+	}
+}
+
+func TestBotLinkToken(t *testing.T) {
+	ts := newTestServer(t, newMemStore())
+	now := time.Now()
+	cmd := botCommand{Platform: platformSlack, ChatUserID: "T1/U1", ChatName: "alice\nadmin"}
+	token := ts.botLinkToken(cmd, now)
+
+	link, ok := ts.readBotLinkToken(token, now.Add(botLinkTTL-time.Second))
+	if !ok || link.Platform != platformSlack || link.ChatUserID != "T1/U1" || link.ChatName != "alice admin" {
+		t.Fatalf("fresh token: %+v %v", link, ok)
+	}
+
+	// Another instance with other session keys signs differently.
+	cfg := testConfig()
+	cfg.Session.Keys = []string{"another-session-key-0123456789abc"}
+	foreign := newServer(&cfg, newMemStore()).botLinkToken(cmd, now)
+
+	payload, sig, _ := strings.Cut(token, ".")
+	forged := ts.botLinkToken(botCommand{Platform: platformSlack, ChatUserID: "T1/U2"}, now)
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	for name, bad := range map[string]string{
+		"expired":          token,
+		"other key":        foreign,
+		"swapped payload":  forgedPayload + "." + sig,
+		"no signature":     payload,
+		"empty":            "",
+		"unknown platform": ts.botLinkToken(botCommand{Platform: "irc", ChatUserID: "U1"}, now),
+	} {
+		at := now
+		if name == "expired" {
+			at = now.Add(botLinkTTL + time.Second)
+		}
+		if link, ok := ts.readBotLinkToken(bad, at); ok {
+			t.Errorf("%s token accepted: %+v", name, link)
+		}
+	}
+}
+
+func TestParseBotRun(t *testing.T) {
+	l := newLocalizer("en")
+	cases := []struct {
+		args       string
+		area, diff string
+		drops      []RuneDrop
+		err        string // substring of the error, empty when it parses
+	}{
+		{"chaos hell ber 2x tal", "Chaos Sanctuary", "Hell", []RuneDrop{{Rune: "Tal", Qty: 2}, {Rune: "Ber", Qty: 1}}, ""},
+		{"meph ber 2", "Mephisto", "Hell", []RuneDrop{{Rune: "Ber", Qty: 2}}, ""},
+		{"nm pindle ist x3, 2 tal", "Pindleskin", "Nightmare", []RuneDrop{{Rune: "Tal", Qty: 2}, {Rune: "Ist", Qty: 3}}, ""},
+		{"lower kurast normal", "Lower Kurast (LK)", "Normal", nil, ""},
+		{"mefisto piekło ber", "Mephisto", "Hell", []RuneDrop{{Rune: "Ber", Qty: 1}}, ""},
+		{"cows", "", "", nil, "Unknown area “cows”"},
+		{"ber 2", "", "", nil, "Unknown area “ber”"},
+		{"hell", "", "", nil, "Name an area"},
+		{"a ber", "", "", nil, "matches several areas"},
+		{"chaos 2", "", "", nil, "Could not read “2”"},
+		{"chaos ber x2 x2", "", "", nil, "Could not read “x2”"},
+		{"chaos bear", "", "", nil, "Could not read “bear”"},
+		{"chaos ber 100", "", "", nil, "Could not read “Ber”"},
+	}
+	for _, tc := range cases {
+		area, diff, drops, err := parseBotRun(l, tc.args)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: err %v, want %q", tc.args, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || area != tc.area || diff != tc.diff || !reflect.DeepEqual(drops, tc.drops) {
+			t.Errorf("%q: %q %q %v %v, want %q %q %v", tc.args, area, diff, drops, err, tc.area, tc.diff, tc.drops)
+		}
+	}
+}
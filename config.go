@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the tracker reads at startup. It comes from a YAML or
+// TOML file (D2R_CONFIG, else d2r.yaml / d2r.toml in the working directory
+// when present), then environment overrides, then validate. OIDC providers
+// keep their own OIDC_* variables, see initOIDC.
+type Config struct {
+	Listen   string         `yaml:"listen" toml:"listen"`
+	BaseURL  string         `yaml:"base_url" toml:"base_url"`
+	TLS      TLSConfig      `yaml:"tls" toml:"tls"`
+	HTTP     HTTPConfig     `yaml:"http" toml:"http"`
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Session  SessionConfig  `yaml:"session" toml:"session"`
+	Features FeatureConfig  `yaml:"features" toml:"features"`
+	Webhooks WebhookConfig  `yaml:"webhooks" toml:"webhooks"`
+	Bot      BotConfig      `yaml:"bot" toml:"bot"`
+	Log      LogConfig      `yaml:"log" toml:"log"`
+	// DefaultLocale is the UI language when neither the visitor nor their
+	// browser picks one we have (see locales/).
+	DefaultLocale string `yaml:"default_locale" toml:"default_locale"`
+}
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+}
+
+func (t TLSConfig) Enabled() bool { return t.CertFile != "" }
+
+// HTTPConfig bounds how long a single request may take. ShutdownTimeout is
+// how long in-flight requests get to finish after SIGTERM; keep it under the
+// platform's kill grace period (30s on Render).
+type HTTPConfig struct {
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout      Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	ShutdownTimeout   Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+type DatabaseConfig struct {
+	Driver          string   `yaml:"driver" toml:"driver"` // sqlite or postgres
+	DSN             string   `yaml:"dsn" toml:"dsn"`
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+}
+
+type SessionConfig struct {
+	// Keys sign the session cookie. The first signs new cookies; the rest are
+	// still accepted so a key can be rotated without logging everyone out.
+	Keys   []string `yaml:"keys" toml:"keys"`
+	MaxAge Duration `yaml:"max_age" toml:"max_age"`
+	Secure bool     `yaml:"secure" toml:"secure"`
+	// DevKey signs with a random key made at startup when Keys is empty, so
+	// sessions and chat link URLs die with the process. Local development only.
+	DevKey bool `yaml:"dev_key" toml:"dev_key"`
+}
+
+type FeatureConfig struct {
+	Registration bool `yaml:"registration" toml:"registration"`
+	OIDC         bool `yaml:"oidc" toml:"oidc"`
+	AntiCheat    bool `yaml:"anticheat" toml:"anticheat"`
+	Metrics      bool `yaml:"metrics" toml:"metrics"` // Prometheus /metrics
+	Webhooks     bool `yaml:"webhooks" toml:"webhooks"`
+}
+
+// WebhookConfig tunes outgoing webhook deliveries. Webhook URLs are entered
+// by players, so by default they may not reach loopback or private addresses;
+// AllowPrivate lifts that for a receiver on the same machine or network.
+type WebhookConfig struct {
+	Timeout      Duration `yaml:"timeout" toml:"timeout"` // per delivery attempt
+	AllowPrivate bool     `yaml:"allow_private" toml:"allow_private"`
+}
+
+// BotConfig holds the chat apps' request-signing credentials. Each platform's
+// slash-command endpoint is only served once its credential is set.
+type BotConfig struct {
+	DiscordPublicKey   string `yaml:"discord_public_key" toml:"discord_public_key"` // hex Ed25519 key from the Discord app page
+	SlackSigningSecret string `yaml:"slack_signing_secret" toml:"slack_signing_secret"`
+}
+
+func (b BotConfig) Enabled() bool { return b.DiscordPublicKey != "" || b.SlackSigningSecret != "" }
+
+type LogConfig struct {
+	Level  string `yaml:"level" toml:"level"`   // debug, info, warn, error
+	Format string `yaml:"format" toml:"format"` // text or json
+	// SlowQuery logs any database statement slower than this at WARN; 0 turns it off.
+	SlowQuery Duration `yaml:"slow_query" toml:"slow_query"`
+}
+
+// Duration reads "90s"-style strings from either file format.
+type Duration struct{ time.Duration }
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	d.Duration = v
+	return err
+}
+
+func (d Duration) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+func defaultConfig() Config {
+	return Config{
+		Listen:        ":8080",
+		DefaultLocale: "pl",
+		HTTP: HTTPConfig{
+			ReadHeaderTimeout: Duration{5 * time.Second},
+			ReadTimeout:       Duration{15 * time.Second},
+			WriteTimeout:      Duration{30 * time.Second},
+			IdleTimeout:       Duration{2 * time.Minute},
+			ShutdownTimeout:   Duration{25 * time.Second},
+		},
+		Database: DatabaseConfig{
+			Driver:          "sqlite",
+			DSN:             "d2r_tracker.db",
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: Duration{30 * time.Minute},
+		},
+		Session:  SessionConfig{MaxAge: Duration{30 * 24 * time.Hour}},
+		Features: FeatureConfig{Registration: true, OIDC: true, AntiCheat: true, Metrics: true, Webhooks: true},
+		Webhooks: WebhookConfig{Timeout: Duration{10 * time.Second}},
+		Log:      LogConfig{Level: "info", Format: "text", SlowQuery: Duration{200 * time.Millisecond}},
+	}
+}
+
+// loadConfig builds the Config and reports every problem at once.
+func loadConfig() (*Config, error) {
+	cfg := defaultConfig()
+
+	path := os.Getenv("D2R_CONFIG")
+	if path == "" {
+		for _, p := range []string{"d2r.yaml", "d2r.yml", "d2r.toml"} {
+			if _, err := os.Stat(p); err == nil {
+				path = p
+				break
+			}
+		}
+	}
+	if path != "" {
+		if err := readConfigFile(path, &cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var problems []string
+	for _, o := range envOverrides {
+		if v, ok := os.LookupEnv(o.name); ok {
+			if err := o.apply(&cfg, v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", o.name, err))
+			}
+		}
+	}
+	if cfg.Session.DevKey && len(cfg.Session.Keys) == 0 {
+		key := make([]byte, 32)
+		rand.Read(key)
+		cfg.Session.Keys = []string{hex.EncodeToString(key)}
+	} else if cfg.Session.DevKey {
+		problems = append(problems, "session.dev_key: remove it once session.keys are set")
+	}
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+
+	if cfg.BaseURL == "" {
+		_, port, _ := net.SplitHostPort(cfg.Listen)
+		scheme := "http"
+		if cfg.TLS.Enabled() {
+			scheme = "https"
+		}
+		cfg.BaseURL = scheme + "://localhost:" + port
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	return &cfg, nil
+}
+
+func readConfigFile(path string, cfg *Config) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(body))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	case ".toml":
+		return toml.NewDecoder(bytes.NewReader(body)).DisallowUnknownFields().Decode(cfg)
+	default:
+		return fmt.Errorf("unknown file format (supported: .yaml, .yml, .toml)")
+	}
+}
+
+// envOverrides map environment variables onto the config. DATABASE_URL, PORT
+// and BASE_URL keep the names the Render deployment already sets.
+var envOverrides = []struct {
+	name  string
+	apply func(*Config, string) error
+}{
+	{"DATABASE_URL", func(c *Config, v string) error {
+		c.Database.Driver, c.Database.DSN = "postgres", v
+		return nil
+	}},
+	{"D2R_DB_DRIVER", func(c *Config, v string) error { c.Database.Driver = v; return nil }},
+	{"D2R_DB_DSN", func(c *Config, v string) error { c.Database.DSN = v; return nil }},
+	{"D2R_DB_MAX_OPEN_CONNS", envInt(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"D2R_DB_MAX_IDLE_CONNS", envInt(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"D2R_DB_CONN_MAX_LIFETIME", envDuration(func(c *Config) *Duration { return &c.Database.ConnMaxLifetime })},
+	{"PORT", func(c *Config, v string) error { c.Listen = ":" + v; return nil }},
+	{"D2R_LISTEN", func(c *Config, v string) error { c.Listen = v; return nil }},
+	{"BASE_URL", func(c *Config, v string) error { c.BaseURL = v; return nil }},
+	{"D2R_HTTP_READ_HEADER_TIMEOUT", envDuration(func(c *Config) *Duration { return &c.HTTP.ReadHeaderTimeout })},
+	{"D2R_HTTP_READ_TIMEOUT", envDuration(func(c *Config) *Duration { return &c.HTTP.ReadTimeout })},
+	{"D2R_HTTP_WRITE_TIMEOUT", envDuration(func(c *Config) *Duration { return &c.HTTP.WriteTimeout })},
+	{"D2R_HTTP_IDLE_TIMEOUT", envDuration(func(c *Config) *Duration { return &c.HTTP.IdleTimeout })},
+	{"D2R_HTTP_SHUTDOWN_TIMEOUT", envDuration(func(c *Config) *Duration { return &c.HTTP.ShutdownTimeout })},
+	{"D2R_TLS_CERT_FILE", func(c *Config, v string) error { c.TLS.CertFile = v; return nil }},
+	{"D2R_TLS_KEY_FILE", func(c *Config, v string) error { c.TLS.KeyFile = v; return nil }},
+	{"D2R_SESSION_KEYS", func(c *Config, v string) error {
+		c.Session.Keys = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+		return nil
+	}},
+	{"D2R_SESSION_MAX_AGE", envDuration(func(c *Config) *Duration { return &c.Session.MaxAge })},
+	{"D2R_SESSION_SECURE", envBool(func(c *Config) *bool { return &c.Session.Secure })},
+	{"D2R_SESSION_DEV_KEY", envBool(func(c *Config) *bool { return &c.Session.DevKey })},
+	{"D2R_FEATURE_REGISTRATION", envBool(func(c *Config) *bool { return &c.Features.Registration })},
+	{"D2R_FEATURE_OIDC", envBool(func(c *Config) *bool { return &c.Features.OIDC })},
+	{"D2R_FEATURE_ANTICHEAT", envBool(func(c *Config) *bool { return &c.Features.AntiCheat })},
+	{"D2R_FEATURE_METRICS", envBool(func(c *Config) *bool { return &c.Features.Metrics })},
+	{"D2R_FEATURE_WEBHOOKS", envBool(func(c *Config) *bool { return &c.Features.Webhooks })},
+	{"D2R_WEBHOOK_TIMEOUT", envDuration(func(c *Config) *Duration { return &c.Webhooks.Timeout })},
+	{"D2R_WEBHOOK_ALLOW_PRIVATE", envBool(func(c *Config) *bool { return &c.Webhooks.AllowPrivate })},
+	{"D2R_BOT_DISCORD_PUBLIC_KEY", func(c *Config, v string) error { c.Bot.DiscordPublicKey = v; return nil }},
+	{"D2R_BOT_SLACK_SIGNING_SECRET", func(c *Config, v string) error { c.Bot.SlackSigningSecret = v; return nil }},
+	{"D2R_LOG_LEVEL", func(c *Config, v string) error { c.Log.Level = v; return nil }},
+	{"D2R_LOG_FORMAT", func(c *Config, v string) error { c.Log.Format = v; return nil }},
+	{"D2R_DEFAULT_LOCALE", func(c *Config, v string) error { c.DefaultLocale = v; return nil }},
+	{"D2R_LOG_SLOW_QUERY", envDuration(func(c *Config) *Duration { return &c.Log.SlowQuery })},
+}
+
+func envInt(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) (err error) {
+		*field(c), err = strconv.Atoi(v)
+		return err
+	}
+}
+
+func envBool(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) (err error) {
+		*field(c), err = strconv.ParseBool(v)
+		return err
+	}
+}
+
+func envDuration(field func(*Config) *Duration) func(*Config, string) error {
+	return func(c *Config, v string) error { return field(c).UnmarshalText([]byte(v)) }
+}
+
+func (c *Config) validate() []string {
+	var problems []string
+	bad := func(format string, args ...any) { problems = append(problems, fmt.Sprintf(format, args...)) }
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		bad("listen: %q is not a host:port address", c.Listen)
+	}
+	if c.BaseURL != "" {
+		if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("base_url: %q must be a full http(s):// URL", c.BaseURL)
+		}
+	}
+
+	for _, t := range []struct {
+		name string
+		d    Duration
+	}{
+		{"read_header_timeout", c.HTTP.ReadHeaderTimeout}, {"read_timeout", c.HTTP.ReadTimeout},
+		{"write_timeout", c.HTTP.WriteTimeout}, {"idle_timeout", c.HTTP.IdleTimeout}, {"shutdown_timeout", c.HTTP.ShutdownTimeout},
+	} {
+		if t.d.Duration <= 0 {
+			bad("http.%s: must be positive", t.name)
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		bad("tls: cert_file and key_file must be set together")
+	}
+	for _, f := range []string{c.TLS.CertFile, c.TLS.KeyFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			bad("tls: %v", err)
+		}
+	}
+
+	switch c.Database.Driver {
+	case "sqlite", "postgres":
+	default:
+		bad("database.driver: %q (allowed: sqlite, postgres)", c.Database.Driver)
+	}
+	if c.Database.DSN == "" {
+		bad("database.dsn: empty")
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 || c.Database.ConnMaxLifetime.Duration < 0 {
+		bad("database: pool settings cannot be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		bad("database.max_idle_conns (%d) is larger than max_open_conns (%d)", c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	}
+
+	if len(c.Session.Keys) == 0 {
+		bad("session.keys: none set; configure D2R_SESSION_KEYS, or session.dev_key for a throwaway key in local development")
+	}
+	for i, k := range c.Session.Keys {
+		if len(k) < 32 {
+			bad("session.keys[%d]: key has %d characters, at least 32 are required", i, len(k))
+		}
+	}
+	if c.Session.MaxAge.Duration <= 0 {
+		bad("session.max_age: must be positive")
+	}
+
+	if c.Webhooks.Timeout.Duration <= 0 {
+		bad("webhooks.timeout: must be positive")
+	}
+	if k := c.Bot.DiscordPublicKey; k != "" {
+		if b, err := hex.DecodeString(k); err != nil || len(b) != ed25519.PublicKeySize {
+			bad("bot.discord_public_key: expected %d bytes in hex", ed25519.PublicKeySize)
+		}
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		bad("log.level: %q (allowed: debug, info, warn, error)", c.Log.Level)
+	}
+	switch c.Log.Format {
+	case "text", "json":
+	default:
+		bad("log.format: %q (allowed: text, json)", c.Log.Format)
+	}
+	if c.Log.SlowQuery.Duration < 0 {
+		bad("log.slow_query: cannot be negative")
+	}
+	if !slices.Contains(availableLocales(), c.DefaultLocale) {
+		bad("default_locale: %q (allowed: %s)", c.DefaultLocale, strings.Join(availableLocales(), ", "))
+	}
+	return problems
+}
+
+// sessionKeyPairs turns the configured keys into the hash/block pairs the
+// cookie store expects; cookies are signed, not encrypted.
+func (c *Config) sessionKeyPairs() [][]byte {
+	pairs := make([][]byte, 0, 2*len(c.Session.Keys))
+	for _, k := range c.Session.Keys {
+		pairs = append(pairs, []byte(k), nil)
+	}
+	return pairs
+}
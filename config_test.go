@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSessionKeysRequired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "d2r.yaml")
+	if err := os.WriteFile(path, []byte("listen: \":8080\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("D2R_CONFIG", path)
+	key := strings.Repeat("k", 32)
+
+	cases := []struct {
+		name, keys, devKey string
+		problem            string // substring of the error, empty when valid
+	}{
+		{"none", "", "false", "session.keys: none set"},
+		{"configured", key, "false", ""},
+		{"dev key", "", "true", ""},
+		{"dev key and keys", key, "true", "session.dev_key"},
+		{"short key", "short", "false", "at least 32"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("D2R_SESSION_KEYS", tc.keys)
+			t.Setenv("D2R_SESSION_DEV_KEY", tc.devKey)
+			cfg, err := loadConfig()
+			if tc.problem != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.problem) {
+					t.Fatalf("err = %v, want %q", err, tc.problem)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.Session.Keys) != 1 || len(cfg.Session.Keys[0]) < 32 {
+				t.Errorf("keys = %q", cfg.Session.Keys)
+			}
+		})
+	}
+}
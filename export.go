@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== ACCOUNT EXPORT ====================
+
+// Exports walk the history a page of runs at a time and write as they go, so
+// a player with years of runs costs no more memory than one with a week.
+// /account/export is nested JSON; /account/export/{runs,drops,sessions}.csv
+// are flat files for spreadsheets. Runs have no character field to export.
+
+const exportPage = 500
+
+// sessionGap splits a history into farming sessions: a longer break between
+// two runs starts a new one.
+const sessionGap = 30 * time.Minute
+
+type exportRun struct {
+	ID         uint         `json:"id"`
+	Area       string       `json:"area"`
+	Difficulty string       `json:"difficulty"`
+	Uniques    int          `json:"uniques"`
+	Sets       int          `json:"sets"`
+	HRCount    int          `json:"hr_count"`
+	SessionSec int          `json:"session_sec"`
+	Timestamp  time.Time    `json:"timestamp"`
+	Hidden     bool         `json:"hidden"`
+	Flagged    bool         `json:"flagged"`
+	Drops      []exportDrop `json:"drops"`
+}
+
+type exportDrop struct {
+	Rune string `json:"rune"`
+	Qty  int    `json:"qty"`
+}
+
+type exportSession struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Runs    int       `json:"runs"`
+	HR      int       `json:"hr"`
+	Uniques int       `json:"uniques"`
+	Sets    int       `json:"sets"`
+}
+
+// addSession counts r into the last session, or opens a new one after a
+// break longer than sessionGap. Runs must come oldest first.
+func addSession(list []exportSession, r Run) []exportSession {
+	if n := len(list); n == 0 || r.Timestamp.Sub(list[n-1].End) > sessionGap {
+		list = append(list, exportSession{Start: r.Timestamp})
+	}
+	last := &list[len(list)-1]
+	last.End = r.Timestamp
+	last.Runs++
+	last.HR += r.HRCount
+	last.Uniques += r.Uniques
+	last.Sets += r.Sets
+	return list
+}
+
+// exportStart loads the user and reads the ?from=&to=&area= filter. Dates are
+// whole UTC days like the daily rollup, and both ends are inclusive.
+func (s *server) exportStart(c *gin.Context) (User, RunFilter, bool) {
+	l := loc(c)
+	user, err := s.reqStore(c).UserByID(sessions.Default(c).Get("user_id").(uint))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/logout")
+		return user, RunFilter{}, false
+	}
+	f := RunFilter{UserID: user.ID, Area: c.Query("area"), Limit: exportPage}
+	if f.Area != "" && !slices.Contains(areas, f.Area) {
+		c.String(http.StatusBadRequest, l.T("export.err.area"))
+		return user, f, false
+	}
+	for _, day := range []struct {
+		key   string
+		into  *time.Time
+		extra int
+	}{{"from", &f.Since, 0}, {"to", &f.Until, 1}} {
+		if v := c.Query(day.key); v != "" {
+			t, err := time.Parse(statDayLayout, v)
+			if err != nil {
+				c.String(http.StatusBadRequest, l.T("export.err.date", v))
+				return user, f, false
+			}
+			*day.into = t.AddDate(0, 0, day.extra)
+		}
+	}
+	return user, f, true
+}
+
+// walkRuns calls fn for every run matching f, oldest first, with its drops.
+func (s *server) walkRuns(c *gin.Context, f RunFilter, fn func(Run, []RuneDrop) error) error {
+	rc := http.NewResponseController(c.Writer)
+	var cursor Run
+	for {
+		runs, err := s.reqStore(c).RunsPage(f, cursor)
+		if err != nil || len(runs) == 0 {
+			return err
+		}
+		ids := make([]uint, len(runs))
+		for i, r := range runs {
+			ids[i] = r.ID
+		}
+		drops, err := s.reqStore(c).DropsByRuns(ids)
+		if err != nil {
+			return err
+		}
+		byRun := map[uint][]RuneDrop{}
+		for _, d := range drops {
+			byRun[d.RunID] = append(byRun[d.RunID], d)
+		}
+
+		// A long history outlasts http.write_timeout; each page gets a fresh one.
+		if t := s.cfg.HTTP.WriteTimeout.Duration; t > 0 {
+			rc.SetWriteDeadline(time.Now().Add(t))
+		}
+		for _, r := range runs {
+			if err := fn(r, byRun[r.ID]); err != nil {
+				return err
+			}
+		}
+		if len(runs) < f.Limit {
+			return nil
+		}
+		cursor = runs[len(runs)-1]
+	}
+}
+
+// exportFailed reports err as a 500 if nothing was sent yet; otherwise the
+// download is already under way and simply ends short.
+func exportFailed(c *gin.Context, err error) {
+	reqLog(c).Error("export failed", "err", err)
+	if !c.Writer.Written() {
+		c.String(http.StatusInternalServerError, "export: %v", err)
+	}
+}
+
+func exportHeaders(c *gin.Context, user User, name, contentType string) {
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="d2r-user%d-%s-%s"`, user.ID, time.Now().Format("20060102"), name))
+}
+
+func (s *server) exportAccountHandler(c *gin.Context) {
+	user, f, ok := s.exportStart(c)
+	if !ok {
+		return
+	}
+	head, err := json.Marshal(gin.H{
+		"username":    user.Username,
+		"exported_at": time.Now(),
+		"filter":      gin.H{"from": c.Query("from"), "to": c.Query("to"), "area": f.Area},
+	})
+	if err != nil {
+		exportFailed(c, err)
+		return
+	}
+
+	// The object is written by hand around the runs array so each run can be
+	// encoded and sent as soon as it is read. The opening waits for the first
+	// page, so a database that is down still gets a plain 500.
+	exportHeaders(c, user, "export.json", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	open := func() {
+		if !c.Writer.Written() {
+			c.Writer.Write(head[:len(head)-1])
+			c.Writer.WriteString(`,"runs":[`)
+		}
+	}
+	farmed := []exportSession{}
+	err = s.walkRuns(c, f, func(r Run, drops []RuneDrop) error {
+		open()
+		if len(farmed) > 0 {
+			c.Writer.WriteString(",")
+		}
+		farmed = addSession(farmed, r)
+		out := exportRun{ID: r.ID, Area: r.Area, Difficulty: r.Difficulty, Uniques: r.Uniques, Sets: r.Sets, HRCount: r.HRCount,
+			SessionSec: r.SessionSec, Timestamp: r.Timestamp, Hidden: r.Hidden, Flagged: r.Flagged, Drops: []exportDrop{}}
+		for _, d := range drops {
+			out.Drops = append(out.Drops, exportDrop{Rune: d.Rune, Qty: d.Qty})
+		}
+		return enc.Encode(out)
+	})
+	if err != nil {
+		exportFailed(c, err)
+		return
+	}
+	open()
+	c.Writer.WriteString(`],"sessions":`)
+	enc.Encode(farmed)
+	c.Writer.WriteString("}")
+}
+
+// exportCSVColumns are the header rows of the flat files, by file name.
+var exportCSVColumns = map[string][]string{
+	"runs.csv":     {"run_id", "timestamp", "area", "difficulty", "uniques", "sets", "hr_count", "session_sec", "hidden", "flagged"},
+	"drops.csv":    {"run_id", "timestamp", "area", "difficulty", "rune", "qty", "high_rune"},
+	"sessions.csv": {"start", "end", "runs", "hr", "uniques", "sets"},
+}
+
+func (s *server) exportCSVHandler(c *gin.Context) {
+	file := c.Param("file")
+	columns, known := exportCSVColumns[file]
+	if !known {
+		c.String(http.StatusNotFound, "export: unknown file %q", file)
+		return
+	}
+	user, f, ok := s.exportStart(c)
+	if !ok {
+		return
+	}
+
+	exportHeaders(c, user, file, "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Write(columns)
+	itoa, stamp := strconv.Itoa, func(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+	sessionRow := func(fs exportSession) []string {
+		return []string{stamp(fs.Start), stamp(fs.End), itoa(fs.Runs), itoa(fs.HR), itoa(fs.Uniques), itoa(fs.Sets)}
+	}
+
+	var farmed []exportSession
+	err := s.walkRuns(c, f, func(r Run, drops []RuneDrop) error {
+		switch file {
+		case "runs.csv":
+			w.Write([]string{itoa(int(r.ID)), stamp(r.Timestamp), r.Area, r.Difficulty, itoa(r.Uniques), itoa(r.Sets),
+				itoa(r.HRCount), itoa(r.SessionSec), strconv.FormatBool(r.Hidden), strconv.FormatBool(r.Flagged)})
+		case "drops.csv":
+			for _, d := range drops {
+				w.Write([]string{itoa(int(r.ID)), stamp(r.Timestamp), r.Area, r.Difficulty, d.Rune, itoa(d.Qty), strconv.FormatBool(highRunes[d.Rune])})
+			}
+		case "sessions.csv":
+			// Only the newest session can still grow; earlier ones are done.
+			if farmed = addSession(farmed, r); len(farmed) > 1 {
+				w.Write(sessionRow(farmed[0]))
+				farmed = farmed[1:]
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+	if err != nil {
+		exportFailed(c, err)
+		return
+	}
+	for _, fs := range farmed {
+		w.Write(sessionRow(fs))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		reqLog(c).Warn("export write failed", "err", err)
+	}
+}
+
+// exportAreas is the area filter of the export form on the account page.
+func exportAreas(l *localizer) []option {
+	return filterOptions(l.T("leaderboard.all_areas"), areas, "", l.Area)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// ==================== I18N ====================
+
+// Every locales/<code>.json is a flat key → text catalogue; adding a file
+// adds a language. Keys missing from a catalogue fall back to the default
+// locale, then to the key itself so a gap is visible but harmless.
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+const (
+	localeKey        = "localizer" // gin context key of the request's *localizer
+	localeSessionKey = "lang"
+)
+
+var (
+	catalogues    = loadCatalogues()
+	defaultLocale = "pl"
+	localeMatcher language.Matcher
+	localeCodes   []string // catalogue codes in matcher order, default first
+)
+
+func loadCatalogues() map[string]map[string]string {
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic("locales: " + err.Error())
+	}
+	cats := map[string]map[string]string{}
+	for _, f := range files {
+		b, err := localeFiles.ReadFile("locales/" + f.Name())
+		if err != nil {
+			panic("locales: " + err.Error())
+		}
+		msgs := map[string]string{}
+		if err := json.Unmarshal(b, &msgs); err != nil {
+			panic("locales/" + f.Name() + ": " + err.Error())
+		}
+		cats[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = msgs
+	}
+	return cats
+}
+
+// availableLocales lists the catalogue codes, sorted.
+func availableLocales() []string {
+	codes := make([]string, 0, len(catalogues))
+	for code := range catalogues {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// setDefaultLocale picks the fallback language and builds the Accept-Language
+// matcher around it. It must be one of availableLocales (config validates).
+func setDefaultLocale(code string) {
+	defaultLocale = code
+	localeCodes = []string{code}
+	for _, c := range availableLocales() {
+		if c != code {
+			localeCodes = append(localeCodes, c)
+		}
+	}
+	tags := make([]language.Tag, len(localeCodes))
+	for i, c := range localeCodes {
+		tags[i] = language.Make(c)
+	}
+	localeMatcher = language.NewMatcher(tags)
+}
+
+func init() { setDefaultLocale(defaultLocale) }
+
+// localizer translates and formats for one language. It is embedded in every
+// view model, so templates call {{.T "key"}}, {{.N "key" n}}, {{.Num x}}…
+type localizer struct {
+	Lang    string
+	msgs    map[string]string
+	printer *message.Printer
+}
+
+func newLocalizer(code string) *localizer {
+	if _, ok := catalogues[code]; !ok {
+		code = defaultLocale
+	}
+	return &localizer{Lang: code, msgs: catalogues[code], printer: message.NewPrinter(language.Make(code))}
+}
+
+// T returns the text for key, formatted with args when there are any.
+func (l *localizer) T(key string, args ...any) string {
+	s, ok := l.msgs[key]
+	if !ok {
+		if s, ok = catalogues[defaultLocale][key]; !ok {
+			s = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(s, args...)
+	}
+	return s
+}
+
+// N picks the plural form of key for the integer n (key.one, key.few,
+// key.many or key.other, by the language's CLDR rules) and fills in the
+// formatted n.
+func (l *localizer) N(key string, n any) string {
+	var i int
+	switch v := n.(type) {
+	case int:
+		i = v
+	case int64:
+		i = int(v)
+	case uint:
+		i = int(v)
+	}
+	form := "other"
+	switch plural.Cardinal.MatchPlural(language.Make(l.Lang), i, 0, 0, 0, 0) {
+	case plural.One:
+		form = "one"
+	case plural.Few:
+		form = "few"
+	case plural.Many:
+		form = "many"
+	}
+	if _, ok := l.msgs[key+"."+form]; !ok {
+		form = "other"
+	}
+	return l.T(key+"."+form, l.Num(n))
+}
+
+// prefixed returns every message under prefix, keyed without it, for
+// handing to scripts.
+func (l *localizer) prefixed(prefix string) map[string]string {
+	out := map[string]string{}
+	for _, msgs := range []map[string]string{catalogues[defaultLocale], l.msgs} {
+		for k, v := range msgs {
+			if rest, ok := strings.CutPrefix(k, prefix); ok {
+				out[rest] = v
+			}
+		}
+	}
+	return out
+}
+
+// Num formats an integer of any type with the language's digit grouping.
+func (l *localizer) Num(n any) string { return l.printer.Sprintf("%d", n) }
+
+// Dec formats a float with the given number of decimals.
+func (l *localizer) Dec(f float64, decimals int) string {
+	return l.printer.Sprintf("%.*f", decimals, f)
+}
+
+// Date, DateTime and Day format with the layouts in format.* of the catalogue.
+func (l *localizer) Date(t time.Time) string     { return t.Format(l.T("format.date")) }
+func (l *localizer) DateTime(t time.Time) string { return t.Format(l.T("format.datetime")) }
+func (l *localizer) Day(t time.Time) string      { return t.Format(l.T("format.day")) }
+
+// Area is the display name of an area; runs keep storing the canonical name.
+func (l *localizer) Area(name string) string {
+	if s, ok := l.msgs["area."+name]; ok {
+		return s
+	}
+	return name
+}
+
+// Difficulty is the display name of a difficulty.
+func (l *localizer) Difficulty(name string) string {
+	if s, ok := l.msgs["difficulty."+name]; ok {
+		return s
+	}
+	return name
+}
+
+// ==================== I18N: REQUEST LOCALE ====================
+
+// localeMiddleware chooses the request's language: an explicit ?lang= (which
+// is remembered in the session), then the session, then Accept-Language, then
+// the default. authMiddleware may still switch to the user's saved preference
+// when the choice was not explicit.
+func localeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session := sessions.Default(c)
+		if q := c.Query("lang"); q != "" {
+			if _, ok := catalogues[q]; ok {
+				session.Set(localeSessionKey, q)
+				session.Save()
+			}
+		}
+		code, _ := session.Get(localeSessionKey).(string)
+		if _, ok := catalogues[code]; !ok {
+			code = acceptLocale(c.GetHeader("Accept-Language"))
+		} else {
+			c.Set("locale_explicit", true)
+		}
+		c.Set(localeKey, newLocalizer(code))
+		c.Next()
+	}
+}
+
+// localeName is a language's own name for itself, for language pickers.
+func localeName(code string) string { return catalogues[code]["lang.name"] }
+
+// useUserLocale applies a signed-in user's preference unless the visitor
+// picked a language explicitly in this session.
+func useUserLocale(c *gin.Context, pref string) {
+	if pref == "" || c.GetBool("locale_explicit") {
+		return
+	}
+	if _, ok := catalogues[pref]; ok {
+		c.Set(localeKey, newLocalizer(pref))
+	}
+}
+
+func acceptLocale(header string) string {
+	tags, _, _ := language.ParseAcceptLanguage(header)
+	if len(tags) == 0 {
+		return defaultLocale
+	}
+	_, i, conf := localeMatcher.Match(tags...)
+	if conf == language.No {
+		return defaultLocale
+	}
+	return localeCodes[i]
+}
+
+// loc returns the request's localizer.
+func loc(c *gin.Context) *localizer {
+	if l, ok := c.Get(localeKey); ok {
+		return l.(*localizer)
+	}
+	return newLocalizer(defaultLocale)
+}
+
+// newPage starts a view model: localizer plus translated title.
+func newPage(c *gin.Context, titleKey string) page {
+	l := loc(c)
+	return page{Title: l.T(titleKey), localizer: l}
+}
@@ -0,0 +1,797 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== IMPORT ====================
+
+// An import reads a spreadsheet or another tracker's export (CSV or JSON),
+// maps its columns onto run fields and stores the rows that are new. Every
+// run gets an ExternalID, from a mapped ID column or derived from the row,
+// so importing the same file again adds nothing.
+
+const (
+	importMaxBytes    = 10 << 20
+	importMaxRows     = 50000
+	importMaxQty      = 99
+	importMaxID       = 100 // longest ExternalID accepted
+	importPreviewRows = 100 // valid rows a dry run echoes back; errors are all listed
+	importBatch       = 500 // runs per transaction
+	importTimeout     = 5 * time.Minute
+)
+
+// importFields are the run fields a column can be mapped to. A column can
+// also count one rune ("rune:Ber"), and a field can be a constant ("=Hell")
+// for files that only ever hold one area or difficulty.
+var importFields = []string{"external_id", "timestamp", "area", "difficulty", "uniques", "sets", "runes"}
+
+// importAliases guess the mapping from (folded) header names.
+var importAliases = map[string][]string{
+	"external_id": {"external id", "id", "run id", "lp"},
+	"timestamp":   {"timestamp", "date", "time", "datetime", "data", "data i godzina", "kiedy"},
+	"area":        {"area", "location", "zone", "lokacja", "miejsce"},
+	"difficulty":  {"difficulty", "diff", "poziom", "trudnosc"},
+	"uniques":     {"uniques", "unique", "unikaty", "unikatowe"},
+	"sets":        {"sets", "set", "zestawy", "setowe"},
+	"runes":       {"runes", "runy", "drops", "dropy", "drop"},
+}
+
+var errImportTooLarge = errors.New("import too large")
+
+// importTable is an upload split into a header and rows of cells.
+type importTable struct {
+	Columns []string
+	Rows    [][]string
+	Lines   []int // where each row starts in the file, for messages
+}
+
+// parseImport reads CSV or JSON; format "" or "auto" goes by the first byte.
+func parseImport(data []byte, format string) (importTable, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // Excel's BOM
+	if format == "" || format == "auto" {
+		format = "csv"
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+			format = "json"
+		}
+	}
+	switch format {
+	case "csv":
+		return parseImportCSV(data)
+	case "json":
+		return parseImportJSON(data)
+	}
+	return importTable{}, fmt.Errorf("unknown format %q", format)
+}
+
+func parseImportCSV(data []byte) (importTable, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = csvDelimiter(data)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return importTable{}, err
+	}
+	t := importTable{Columns: importColumns(header)}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return t, err
+		}
+		if strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue
+		}
+		if len(t.Rows) == importMaxRows {
+			return t, errImportTooLarge
+		}
+		line, _ := r.FieldPos(0)
+		t.Rows = append(t.Rows, rec)
+		t.Lines = append(t.Lines, line)
+	}
+}
+
+// csvDelimiter picks whichever of , ; and tab the header line uses most;
+// spreadsheets in Polish locales save with semicolons.
+func csvDelimiter(data []byte) rune {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	best, count := ',', bytes.Count(line, []byte(","))
+	for _, d := range []rune{';', '\t'} {
+		if n := bytes.Count(line, []byte(string(d))); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
+
+// importColumns names blank headers by position and numbers repeats, so
+// every column can be picked in the mapping.
+func importColumns(header []string) []string {
+	cols := make([]string, len(header))
+	seen := map[string]int{}
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			h = fmt.Sprintf("#%d", i+1)
+		}
+		if seen[h]++; seen[h] > 1 {
+			h = fmt.Sprintf("%s (%d)", h, seen[h])
+		}
+		cols[i] = h
+	}
+	return cols
+}
+
+// parseImportJSON reads an array of flat objects, or an object with such an
+// array under "runs" as /account/export writes it.
+func parseImportJSON(data []byte) (importTable, error) {
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		var wrapped struct {
+			Runs []map[string]json.RawMessage `json:"runs"`
+		}
+		if json.Unmarshal(data, &wrapped) != nil || wrapped.Runs == nil {
+			return importTable{}, err
+		}
+		items = wrapped.Runs
+	}
+	if len(items) > importMaxRows {
+		return importTable{}, errImportTooLarge
+	}
+
+	var t importTable
+	index := map[string]int{}
+	for _, item := range items {
+		for k := range item {
+			if _, ok := index[k]; !ok {
+				index[k] = 0
+				t.Columns = append(t.Columns, k)
+			}
+		}
+	}
+	sort.Strings(t.Columns)
+	for i, k := range t.Columns {
+		index[k] = i
+	}
+	for i, item := range items {
+		row := make([]string, len(t.Columns))
+		for k, v := range item {
+			row[index[k]] = jsonCell(v)
+		}
+		t.Rows = append(t.Rows, row)
+		t.Lines = append(t.Lines, i+1)
+	}
+	return t, nil
+}
+
+// jsonCell flattens a JSON value into the text a spreadsheet cell would
+// hold; a list of {"rune", "qty"} drops becomes "Ber x1, Tal x2".
+func jsonCell(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var drops []exportDrop
+	if json.Unmarshal(raw, &drops) == nil {
+		parts := make([]string, 0, len(drops))
+		for _, d := range drops {
+			parts = append(parts, fmt.Sprintf("%s x%d", d.Rune, d.Qty))
+		}
+		return strings.Join(parts, ", ")
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return strings.Join(list, ", ")
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
+// ==================== IMPORT: NAMES ====================
+
+var foldReplacer = strings.NewReplacer("ą", "a", "ć", "c", "ę", "e", "ł", "l", "ń", "n", "ó", "o", "ś", "s", "ź", "z", "ż", "z", "_", " ")
+
+// fold lowercases s, drops Polish diacritics and squeezes spaces, so names
+// typed by hand compare equal to ours.
+func fold(s string) string {
+	return strings.Join(strings.Fields(foldReplacer.Replace(strings.ToLower(s))), " ")
+}
+
+// importAreaNames and importDifficultyNames map folded names, as stored or
+// as shown in any language, to the stored name.
+var (
+	importAreaNames       = importNames(areas, "area.", nil)
+	importDifficultyNames = importNames(difficulties, "difficulty.", map[string]string{"n": "Normal", "norm": "Normal", "nm": "Nightmare", "h": "Hell"})
+)
+
+func importNames(values []string, prefix string, extra map[string]string) map[string]string {
+	names := map[string]string{}
+	add := func(name, value string) {
+		if f := fold(name); f != "" && names[f] == "" {
+			names[f] = value
+		}
+	}
+	for _, v := range values {
+		add(v, v)
+		for _, msgs := range catalogues {
+			add(msgs[prefix+v], v)
+		}
+		// "Lower Kurast (LK)" also answers to "Lower Kurast" and "LK".
+		if base, short, ok := strings.Cut(v, " ("); ok {
+			add(base, v)
+			add(strings.TrimSuffix(short, ")"), v)
+		}
+	}
+	for name, v := range extra {
+		add(name, v)
+	}
+	return names
+}
+
+// normalizeRune finds the rune behind a hand-typed name: any case, with
+// "rune"/"runa" around it or not, or by its number ("r30", "#30"). Rune
+// names are the same in the Polish and English game.
+func normalizeRune(name string) (string, bool) {
+	words := slices.DeleteFunc(strings.Fields(fold(name)), func(w string) bool {
+		return w == "rune" || w == "runa" || w == "runy"
+	})
+	if len(words) != 1 {
+		return "", false
+	}
+	w := words[0]
+	if digits := strings.TrimLeft(w, "#r"); digits != w {
+		if n, err := strconv.Atoi(digits); err == nil {
+			if n < 1 || n > len(runeOrder) {
+				return "", false
+			}
+			return runeOrder[n-1], true
+		}
+	}
+	i := slices.IndexFunc(runeOrder, func(r string) bool { return strings.ToLower(r) == w })
+	if i < 0 {
+		return "", false
+	}
+	return runeOrder[i], true
+}
+
+// runeQtyForms are the ways a count is written next to a rune: "2x Tal",
+// "Tal x2", "Tal (2)", "Tal 2". The first is count-first.
+var runeQtyForms = []*regexp.Regexp{
+	regexp.MustCompile(`^(\d+)\s*[x×*]?\s*(.+)$`),
+	regexp.MustCompile(`^(.+?)\s*[x×*]\s*(\d+)$`),
+	regexp.MustCompile(`^(.+?)\s*\(?(\d+)\)?$`),
+}
+
+// parseRuneList reads a free-text drop list like "Ber, Tal x2; 3x Ral" into
+// drops, adding up repeats. It reports the first item it cannot read.
+func parseRuneList(text string, into map[string]int) (bad string) {
+	items := strings.FieldsFunc(text, func(r rune) bool { return strings.ContainsRune(",;+|/\n", r) })
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" || item == "-" {
+			continue
+		}
+		name, qty := item, 1
+		r, ok := normalizeRune(name)
+		for i, form := range runeQtyForms {
+			if ok {
+				break
+			}
+			m := form.FindStringSubmatch(item)
+			if m == nil {
+				continue
+			}
+			count := m[2]
+			if name = m[1]; i == 0 {
+				name, count = m[2], m[1]
+			}
+			if r, ok = normalizeRune(name); ok {
+				qty, _ = strconv.Atoi(count)
+			}
+		}
+		if !ok || qty < 1 || qty > importMaxQty {
+			return item
+		}
+		into[r] += qty
+	}
+	return ""
+}
+
+// ==================== IMPORT: MAPPING ====================
+
+// guessImportMapping maps each field to the first column whose header is
+// one of its aliases, and every column named after a rune to that rune.
+func guessImportMapping(columns []string) map[string]string {
+	mapping := map[string]string{}
+	for _, col := range columns {
+		f := strings.Trim(fold(col), ".:")
+		for _, field := range importFields {
+			if _, taken := mapping[field]; !taken && slices.Contains(importAliases[field], f) {
+				mapping[field] = col
+			}
+		}
+		// "#3" is importColumns' name for a blank header, not rune 3.
+		if r, ok := normalizeRune(col); ok && f != "" && !strings.HasPrefix(col, "#") {
+			if _, taken := mapping["rune:"+r]; !taken {
+				mapping["rune:"+r] = col
+			}
+		}
+	}
+	return mapping
+}
+
+// parseImportMapping reads a field → column JSON object, guessing when raw
+// is empty, and checks it against the table.
+func parseImportMapping(l *localizer, raw string, t importTable) (map[string]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return guessImportMapping(t.Columns), nil
+	}
+	mapping := map[string]string{}
+	if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+		return nil, errors.New(l.T("import.err.mapping", err))
+	}
+	for field, src := range mapping {
+		if src == "" {
+			delete(mapping, field)
+			continue
+		}
+		r, isRune := strings.CutPrefix(field, "rune:")
+		if !slices.Contains(importFields, field) && !(isRune && slices.Contains(runeOrder, r)) {
+			return nil, errors.New(l.T("import.err.field", field))
+		}
+		if !strings.HasPrefix(src, "=") && !slices.Contains(t.Columns, src) {
+			return nil, errors.New(l.T("import.err.column", src))
+		}
+	}
+	return mapping, nil
+}
+
+// ==================== IMPORT: ROWS ====================
+
+// importTimeLayouts are tried in order; dates without a zone are read in
+// the zone the importer chose.
+var importTimeLayouts = []string{
+	time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02",
+	"2.1.2006 15:04:05", "2.1.2006 15:04", "2.1.2006", "2006/01/02 15:04:05", "2006/01/02 15:04", "2006/01/02",
+}
+
+func parseImportTime(s string, zone *time.Location) (time.Time, bool) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n > 1e9 {
+		if n > 1e12 {
+			return time.UnixMilli(n), true
+		}
+		return time.Unix(n, 0), true
+	}
+	for _, layout := range importTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, zone); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// importRun is a row as it will be stored; a dry run shows it back.
+type importRun struct {
+	ExternalID string       `json:"external_id"`
+	Timestamp  time.Time    `json:"timestamp"`
+	Area       string       `json:"area"`
+	Difficulty string       `json:"difficulty"`
+	Uniques    int          `json:"uniques"`
+	Sets       int          `json:"sets"`
+	HRCount    int          `json:"hr_count"`
+	Runes      []exportDrop `json:"runes"`
+}
+
+type importRow struct {
+	Line   int        `json:"line"`
+	Status string     `json:"status"` // new, duplicate or error
+	Error  string     `json:"error,omitempty"`
+	Run    *importRun `json:"run,omitempty"`
+}
+
+type importOptions struct {
+	Mapping map[string]string
+	Zone    *time.Location
+	DryRun  bool
+}
+
+// importRowReader turns table rows into runs under one mapping.
+type importRowReader struct {
+	l       *localizer
+	opts    importOptions
+	columns map[string]int
+	now     time.Time
+	seen    map[string]int // row fingerprints so far, for derived IDs
+}
+
+func (rr *importRowReader) cell(row []string, field string) string {
+	src, ok := rr.opts.Mapping[field]
+	if !ok {
+		return ""
+	}
+	if v, ok := strings.CutPrefix(src, "="); ok {
+		return v
+	}
+	if i := rr.columns[src]; i < len(row) {
+		return strings.TrimSpace(row[i])
+	}
+	return ""
+}
+
+func (rr *importRowReader) count(row []string, field string) (int, error) {
+	v := rr.cell(row, field)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, errors.New(rr.l.T("import.err.count", rr.l.T("import.field."+field), v))
+	}
+	return n, nil
+}
+
+func (rr *importRowReader) read(row []string) (importRun, error) {
+	l := rr.l
+	var r importRun
+
+	ts := rr.cell(row, "timestamp")
+	t, ok := parseImportTime(ts, rr.opts.Zone)
+	switch {
+	case ts == "":
+		return r, errors.New(l.T("import.err.missing", l.T("import.field.timestamp")))
+	case !ok:
+		return r, errors.New(l.T("import.err.timestamp", ts))
+	case t.After(rr.now.Add(time.Hour)):
+		return r, errors.New(l.T("import.err.future", ts))
+	}
+	// Kept in the server's zone like time.Now(), so SQLite compares it as
+	// text in line with runs logged here.
+	r.Timestamp = t.Local()
+
+	for _, f := range []struct {
+		field string
+		names map[string]string
+		into  *string
+	}{{"area", importAreaNames, &r.Area}, {"difficulty", importDifficultyNames, &r.Difficulty}} {
+		v := rr.cell(row, f.field)
+		if v == "" {
+			return r, errors.New(l.T("import.err.missing", l.T("import.field."+f.field)))
+		}
+		if *f.into = f.names[fold(v)]; *f.into == "" {
+			return r, errors.New(l.T("import.err."+f.field, v))
+		}
+	}
+
+	var err error
+	if r.Uniques, err = rr.count(row, "uniques"); err != nil {
+		return r, err
+	}
+	if r.Sets, err = rr.count(row, "sets"); err != nil {
+		return r, err
+	}
+
+	qty := map[string]int{}
+	if bad := parseRuneList(rr.cell(row, "runes"), qty); bad != "" {
+		return r, errors.New(l.T("import.err.rune", bad))
+	}
+	for _, name := range runeOrder {
+		if _, mapped := rr.opts.Mapping["rune:"+name]; mapped {
+			n, err := rr.count(row, "rune:"+name)
+			if err != nil || n > importMaxQty {
+				return r, errors.New(l.T("import.err.rune", rr.cell(row, "rune:"+name)+" "+name))
+			}
+			qty[name] += n
+		}
+	}
+	for _, name := range runeOrder {
+		if qty[name] > 0 {
+			r.Runes = append(r.Runes, exportDrop{Rune: name, Qty: qty[name]})
+			if highRunes[name] {
+				r.HRCount += qty[name]
+			}
+		}
+	}
+
+	r.ExternalID = rr.cell(row, "external_id")
+	if len(r.ExternalID) > importMaxID {
+		return r, errors.New(l.T("import.err.external_id", importMaxID))
+	}
+	if r.ExternalID == "" {
+		r.ExternalID = rr.fingerprint(r)
+	}
+	return r, nil
+}
+
+// fingerprint stands in for a missing ID: a hash of the row, numbered by how
+// often the same row came earlier in the file, so identical runs on a sheet
+// without times stay distinct and keep their IDs when it is imported again.
+func (rr *importRowReader) fingerprint(r importRun) string {
+	b, _ := json.Marshal(importRun{Timestamp: r.Timestamp.UTC(), Area: r.Area, Difficulty: r.Difficulty, Uniques: r.Uniques, Sets: r.Sets, Runes: r.Runes})
+	sum := sha256.Sum256(b)
+	key := hex.EncodeToString(sum[:10])
+	rr.seen[key]++
+	return fmt.Sprintf("row:%s:%d", key, rr.seen[key])
+}
+
+// importReport answers both a dry run and an import.
+type importReport struct {
+	Columns   []string          `json:"columns"`
+	Mapping   map[string]string `json:"mapping"`
+	Rows      []importRow       `json:"rows"` // every error and the first importPreviewRows others
+	Total     int               `json:"total"`
+	New       int               `json:"new"`
+	Duplicate int               `json:"duplicate"`
+	Errors    int               `json:"errors"`
+	Imported  int               `json:"imported"`
+	DryRun    bool              `json:"dry_run"`
+}
+
+// importRuns checks every row of t and, unless it is a dry run, stores the
+// new ones for userID. Rows with errors are reported and left out; imported
+// runs go through the anti-cheat content checks like logged ones.
+func (s *server) importRuns(ctx context.Context, l *localizer, userID uint, t importTable, opts importOptions) (importReport, error) {
+	store := s.store.WithContext(ctx)
+	report := importReport{Columns: t.Columns, Mapping: opts.Mapping, Total: len(t.Rows), DryRun: opts.DryRun, Rows: []importRow{}}
+	rr := &importRowReader{l: l, opts: opts, columns: map[string]int{}, now: time.Now(), seen: map[string]int{}}
+	for i, col := range t.Columns {
+		rr.columns[col] = i
+	}
+
+	rows := make([]importRow, len(t.Rows))
+	var ids []string
+	for i, cells := range t.Rows {
+		rows[i].Line = t.Lines[i]
+		run, err := rr.read(cells)
+		if err != nil {
+			rows[i].Status, rows[i].Error = "error", err.Error()
+			continue
+		}
+		rows[i].Status, rows[i].Run = "new", &run
+		ids = append(ids, run.ExternalID)
+	}
+	have, err := store.ExternalRunIDs(userID, ids)
+	if err != nil {
+		return report, err
+	}
+
+	var runs []Run
+	var drops [][]RuneDrop
+	for i := range rows {
+		row := &rows[i]
+		switch {
+		case row.Status == "error":
+			report.Errors++
+		case have[row.Run.ExternalID]:
+			row.Status = "duplicate"
+			report.Duplicate++
+		default:
+			have[row.Run.ExternalID] = true
+			report.New++
+			r := row.Run
+			runs = append(runs, Run{UserID: userID, Area: r.Area, Difficulty: r.Difficulty, Uniques: r.Uniques, Sets: r.Sets,
+				HRCount: r.HRCount, Timestamp: r.Timestamp, ExternalID: r.ExternalID})
+			stored := make([]RuneDrop, len(r.Runes))
+			for j, d := range r.Runes {
+				stored[j] = RuneDrop{Rune: d.Rune, Qty: d.Qty}
+			}
+			drops = append(drops, stored)
+		}
+		if row.Status == "error" || len(report.Rows) < importPreviewRows {
+			report.Rows = append(report.Rows, *row)
+		}
+	}
+	if opts.DryRun || len(runs) == 0 {
+		return report, nil
+	}
+
+	for len(runs) > 0 {
+		n := min(len(runs), importBatch)
+		if err := store.CreateRuns(runs[:n], drops[:n]); err != nil {
+			return report, err
+		}
+		if s.cfg.Features.AntiCheat {
+			for i := range runs[:n] {
+				s.inspectRun(ctx, runs[i], drops[i])
+			}
+		}
+		s.metrics.runsImported.Add(float64(n))
+		report.Imported += n
+		runs, drops = runs[n:], drops[n:]
+	}
+	t2, _ := store.UserTotals(userID)
+	s.runsChanged(userID, t2)
+	return report, nil
+}
+
+// ==================== IMPORT: HTTP ====================
+
+// importView hands the mapping step its fields and the constants a field
+// can be set to; the page script does the rest.
+type importView struct {
+	page
+	Fields       []option
+	Areas        []option
+	Difficulties []option
+	MaxMB        int
+	JS           map[string]string
+}
+
+func (s *server) importPage(c *gin.Context) {
+	l := loc(c)
+	c.HTML(http.StatusOK, "import", importView{
+		page:         newPage(c, "title.import"),
+		Fields:       selectOptions(importFields, "", func(f string) string { return l.T("import.field." + f) }),
+		Areas:        selectOptions(areas, "", l.Area),
+		Difficulties: selectOptions(difficulties, "", l.Difficulty),
+		MaxMB:        importMaxBytes >> 20,
+		JS:           l.prefixed("import.js."),
+	})
+}
+
+// importHandler takes a "file" upload (or pasted "data"), with optional
+// "format", "mapping" (JSON), "tz" and "dry_run", and answers with an
+// importReport. The import page calls it once per preview and once to import.
+func (s *server) importHandler(c *gin.Context) {
+	l := loc(c)
+	userID := sessions.Default(c).Get("user_id").(uint)
+	fail := func(status int, msg string) { c.JSON(status, gin.H{"error": msg}) }
+
+	// Big files take longer to send and store than an ordinary request.
+	rc := http.NewResponseController(c.Writer)
+	rc.SetReadDeadline(time.Now().Add(importTimeout))
+	rc.SetWriteDeadline(time.Now().Add(importTimeout))
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, importMaxBytes+1<<20)
+
+	data, err := importUpload(c)
+	if err != nil {
+		fail(http.StatusBadRequest, l.T("import.err.upload"))
+		return
+	}
+	if len(data) > importMaxBytes {
+		fail(http.StatusRequestEntityTooLarge, l.T("import.err.too_large", importMaxBytes>>20, importMaxRows))
+		return
+	}
+	t, err := parseImport(data, c.PostForm("format"))
+	if errors.Is(err, errImportTooLarge) {
+		fail(http.StatusRequestEntityTooLarge, l.T("import.err.too_large", importMaxBytes>>20, importMaxRows))
+		return
+	} else if err != nil {
+		fail(http.StatusBadRequest, l.T("import.err.parse", err))
+		return
+	}
+	opts := importOptions{DryRun: c.PostForm("dry_run") != ""}
+	if opts.Mapping, err = parseImportMapping(l, c.PostForm("mapping"), t); err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Zone, err = time.LoadLocation(c.DefaultPostForm("tz", "UTC")); err != nil {
+		fail(http.StatusBadRequest, l.T("import.err.tz", c.PostForm("tz")))
+		return
+	}
+
+	report, err := s.importRuns(c.Request.Context(), l, userID, t, opts)
+	if err != nil {
+		reqLog(c).Error("import failed", "err", err, "imported", report.Imported)
+		fail(http.StatusInternalServerError, l.T("import.err.store", report.Imported))
+		return
+	}
+	if !opts.DryRun {
+		reqLog(c).Info("runs imported", "imported", report.Imported, "duplicate", report.Duplicate, "errors", report.Errors)
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func importUpload(c *gin.Context) ([]byte, error) {
+	fh, err := c.FormFile("file")
+	if errors.Is(err, http.ErrMissingFile) {
+		return []byte(c.PostForm("data")), nil
+	} else if err != nil {
+		return nil, err
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, importMaxBytes+1))
+}
+
+// ==================== IMPORT: CLI ====================
+
+// importCommand is "import [-dry-run] [-format f] [-tz zone] [-map field=column]... <username> <file|->".
+func (s *server) importCommand(args []string) {
+	l := newLocalizer(defaultLocale)
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, l.T("cli.import_flag_dry_run"))
+	format := fs.String("format", "auto", l.T("cli.import_flag_format"))
+	tz := fs.String("tz", "UTC", l.T("cli.import_flag_tz"))
+	mapping := map[string]string{}
+	fs.Func("map", l.T("cli.import_flag_map"), func(v string) error {
+		field, col, ok := strings.Cut(v, "=")
+		if !ok {
+			return errors.New("field=column")
+		}
+		mapping[field] = col
+		return nil
+	})
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fatal(l.T("cli.usage_import"))
+	}
+
+	user, err := s.store.UserByUsername(fs.Arg(0))
+	if err != nil {
+		fatal("import failed", "user", fs.Arg(0), "err", err)
+	}
+	var data []byte
+	if fs.Arg(1) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(fs.Arg(1))
+	}
+	if err != nil {
+		fatal("import failed", "err", err)
+	}
+	t, err := parseImport(data, *format)
+	if err != nil {
+		fatal("import failed", "err", err)
+	}
+	opts := importOptions{DryRun: *dryRun}
+	raw := ""
+	if len(mapping) > 0 {
+		b, _ := json.Marshal(mapping)
+		raw = string(b)
+	}
+	if opts.Mapping, err = parseImportMapping(l, raw, t); err != nil {
+		fatal("import failed", "err", err)
+	}
+	if opts.Zone, err = time.LoadLocation(*tz); err != nil {
+		fatal(l.T("import.err.tz", *tz))
+	}
+
+	fields := make([]string, 0, len(opts.Mapping))
+	for field := range opts.Mapping {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		fmt.Printf("%-12s ← %s\n", field, opts.Mapping[field])
+	}
+	report, err := s.importRuns(context.Background(), l, user.ID, t, opts)
+	for _, row := range report.Rows {
+		if row.Status == "error" {
+			fmt.Fprintln(os.Stderr, l.T("cli.import_row_error", row.Line, row.Error))
+		}
+	}
+	log.Print(l.T("cli.import_summary", report.Total, report.New, report.Duplicate, report.Errors, report.Imported))
+	if err != nil {
+		fatal("import failed", "err", err)
+	}
+}
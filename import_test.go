@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestCSVDelimiter(t *testing.T) {
+	for data, want := range map[string]rune{
+		"date,area,runes\n1;2;3":              ',',
+		"Data;Lokacja;Runy\n1,2,3":            ';',
+		"date\tarea\truns":                    '\t',
+		"date;area,with,commas;runes;sets":    ';',
+		"only one column\nnext line; a; b; c": ',',
+	} {
+		if got := csvDelimiter([]byte(data)); got != want {
+			t.Errorf("%q: %q, want %q", data, got, want)
+		}
+	}
+}
+
+func TestNormalizeRune(t *testing.T) {
+	for name, want := range map[string]string{
+		"Ber":       "Ber",
+		"ber":       "Ber",
+		" TAL ":     "Tal",
+		"Runa Ber":  "Ber",
+		"rune Ist":  "Ist",
+		"runy Jah":  "Jah",
+		"r30":       "Ber",
+		"#1":        "El",
+		"R33":       "Zod",
+		"r0":        "",
+		"r34":       "",
+		"Bear":      "",
+		"Ber Tal":   "",
+		"":          "",
+		"runa":      "",
+		"#":         "",
+		"rrr":       "",
+		"Sur rune!": "",
+	} {
+		got, ok := normalizeRune(name)
+		if ok != (want != "") || got != want {
+			t.Errorf("%q: %q %v, want %q", name, got, ok, want)
+		}
+	}
+}
+
+func TestParseRuneList(t *testing.T) {
+	cases := []struct {
+		text string
+		want map[string]int
+		bad  string
+	}{
+		{"", map[string]int{}, ""},
+		{"-", map[string]int{}, ""},
+		{"Ber", map[string]int{"Ber": 1}, ""},
+		{"Ber, Tal x2; 3x Ral", map[string]int{"Ber": 1, "Tal": 2, "Ral": 3}, ""},
+		{"Tal (2) | Tal 2 / 2×Tal", map[string]int{"Tal": 6}, ""},
+		{"Ber+Ber+runa Ber", map[string]int{"Ber": 3}, ""},
+		{"2 Ist\nr30 x2", map[string]int{"Ist": 2, "Ber": 2}, ""},
+		{"Ber, Bear", nil, "Bear"},
+		{"Ber x100", nil, "Ber x100"},
+		{"Ber x0", nil, "Ber x0"},
+		{"2x", nil, "2x"},
+	}
+	for _, tc := range cases {
+		got := map[string]int{}
+		bad := parseRuneList(tc.text, got)
+		if bad != tc.bad {
+			t.Errorf("%q: bad item %q, want %q", tc.text, bad, tc.bad)
+		} else if tc.bad == "" && !maps.Equal(got, tc.want) {
+			t.Errorf("%q: %v, want %v", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestParseImportTime(t *testing.T) {
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Skip(err)
+	}
+	evening := time.Date(2024, 3, 5, 18, 30, 0, 0, warsaw)
+	cases := []struct {
+		in   string
+		want time.Time // zero when it must not parse
+	}{
+		{"1709659800", time.Unix(1709659800, 0)},
+		{"1709659800123", time.UnixMilli(1709659800123)},
+		{"2024-03-05T17:30:00Z", evening},
+		{"2024-03-05T18:30:00+01:00", evening},
+		{"2024-03-05 18:30", evening},
+		{"2024-03-05T18:30:00", evening},
+		{"5.3.2024 18:30", evening},
+		{"05.03.2024 18:30:00", evening},
+		{"2024/03/05 18:30", evening},
+		{"2024-03-05", time.Date(2024, 3, 5, 0, 0, 0, 0, warsaw)},
+		// Too small for an epoch: a count, an ID or a year.
+		{"12345", time.Time{}},
+		{"2024", time.Time{}},
+		{"wczoraj", time.Time{}},
+		{"03/05/2024", time.Time{}},
+	}
+	for _, tc := range cases {
+		got, ok := parseImportTime(tc.in, warsaw)
+		if ok != !tc.want.IsZero() || !got.Equal(tc.want) {
+			t.Errorf("%q: %v %v, want %v", tc.in, got, ok, tc.want)
+		}
+	}
+}
+
+func TestGuessImportMapping(t *testing.T) {
+	cases := []struct {
+		columns []string
+		want    map[string]string
+	}{
+		{
+			[]string{"Lp.", "Data i godzina", "Lokacja", "Trudność", "Unikaty", "Zestawy", "Runy", "Ber", "Runa Jah"},
+			map[string]string{"external_id": "Lp.", "timestamp": "Data i godzina", "area": "Lokacja", "difficulty": "Trudność",
+				"uniques": "Unikaty", "sets": "Zestawy", "runes": "Runy", "rune:Ber": "Ber", "rune:Jah": "Runa Jah"},
+		},
+		{
+			[]string{"Run ID", "Date", "Zone", "Diff", "Unique", "Set", "Drops", "notes"},
+			map[string]string{"external_id": "Run ID", "timestamp": "Date", "area": "Zone", "difficulty": "Diff",
+				"uniques": "Unique", "sets": "Set", "runes": "Drops"},
+		},
+		// The first matching column wins.
+		{[]string{"time", "timestamp", "runes", "drops"}, map[string]string{"timestamp": "time", "runes": "runes"}},
+		// Blank headers are named "#1"…, which is not rune 1.
+		{[]string{"#1", "foo"}, map[string]string{}},
+	}
+	for _, tc := range cases {
+		if got := guessImportMapping(tc.columns); !maps.Equal(got, tc.want) {
+			t.Errorf("%q:\n got %v\nwant %v", tc.columns, got, tc.want)
+		}
+	}
+}
+
+// importFiles are whole uploads and what they hold: new runs, and rows
+// with errors.
+var importFiles = []struct {
+	name       string
+	data       string
+	runs, errs int
+	hr         int // HR over the imported runs
+}{
+	{"polish semicolons", "\xef\xbb\xbfLp.;Data;Lokacja;Poziom;Unikaty;Runy\n" +
+		"1;05.03.2024 18:30;Hrabina;Piekło;2;Ist, 2x Tal\n" +
+		"2;05.03.2024 18:35;Sanktuarium Chaosu;piekło;0;runa Ber\n" +
+		"3;05.03.2024 18:40;Mefisto;Koszmar;1;\n" +
+		"4;05.03.2024 18:45;Atlantyda;Piekło;0;\n" +
+		"5;jutro;Mefisto;Piekło;0;\n" +
+		"6;05.03.2024 18:50;Mefisto;Piekło;-1;\n", 3, 3, 2},
+	{"english commas, rune columns", "date,area,difficulty,Ber,Jah,drops\n" +
+		"2024-03-05 18:30,Chaos Sanctuary,Hell,1,0,\n" +
+		"2024-03-05 18:35,LK,NM,0,1,Tal x3\n" +
+		"2024-03-05 18:40,Lower Kurast,h,0,0,Bear\n", 2, 1, 2},
+	// No ID column: the fingerprints keep identical rows apart.
+	{"identical rows without ids", "area;difficulty;timestamp\n" +
+		"Pindleskin;Hell;1709659800\n" +
+		"Pindleskin;Hell;1709659800\n", 2, 0, 0},
+	{"json export", `{"username":"x","runs":[` +
+		`{"id":7,"area":"Baal Waves","difficulty":"Hell","timestamp":"2024-03-05T18:30:00Z","drops":[{"rune":"Vex","qty":2}]},` +
+		`{"id":8,"area":"Baal Waves","difficulty":"Hell","timestamp":"2024-03-05T18:40:00Z","drops":[]}]}`, 2, 0, 2},
+}
+
+func TestImportRuns(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		l := newLocalizer("en")
+		ctx := context.Background()
+		for _, f := range importFiles {
+			user := User{Username: f.name}
+			if err := store.CreateUser(&user); err != nil {
+				t.Fatal(err)
+			}
+			table, err := parseImport([]byte(f.data), "auto")
+			if err != nil {
+				t.Fatalf("%s: %v", f.name, err)
+			}
+			opts := importOptions{Mapping: guessImportMapping(table.Columns), Zone: time.UTC, DryRun: true}
+
+			dry, err := ts.importRuns(ctx, l, user.ID, table, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dry.New != f.runs || dry.Errors != f.errs || dry.Imported != 0 {
+				t.Errorf("%s dry run: %d new, %d errors, %d imported: %+v", f.name, dry.New, dry.Errors, dry.Imported, dry.Rows)
+			}
+			if runs, _ := store.RunsByUser(user.ID); len(runs) != 0 {
+				t.Errorf("%s: the dry run stored %d runs", f.name, len(runs))
+			}
+
+			opts.DryRun = false
+			report, err := ts.importRuns(ctx, l, user.ID, table, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.New != f.runs || report.Imported != f.runs || report.Errors != f.errs {
+				t.Errorf("%s: %d new, %d imported, %d errors", f.name, report.New, report.Imported, report.Errors)
+			}
+			totals, _ := store.UserTotals(user.ID)
+			if totals.Runs != int64(f.runs) || totals.HR != int64(f.hr) {
+				t.Errorf("%s: totals %+v, want %d runs and %d HR", f.name, totals, f.runs, f.hr)
+			}
+
+			// The same file again, even re-read, adds nothing.
+			table, _ = parseImport([]byte(f.data), "auto")
+			again, err := ts.importRuns(ctx, l, user.ID, table, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.Imported != 0 || again.Duplicate != f.runs {
+				t.Errorf("%s again: %d imported, %d duplicates", f.name, again.Imported, again.Duplicate)
+			}
+			if runs, _ := store.RunsByUser(user.ID); len(runs) != f.runs {
+				t.Errorf("%s: %d runs after importing twice", f.name, len(runs))
+			}
+		}
+	})
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const leaderboardSize = 15
+
+// leaderboardCache wraps a Store and keeps every leaderboard view it has
+// served in memory, patching it on each run write so page loads never run the
+// GROUP BY. Views live per process: with several instances each one follows
+// its own writes, and Rebuild brings a view back in line with the database.
+type leaderboardCache struct {
+	Store
+	*leaderboardState
+}
+
+// leaderboardState is shared by the cache and its context-bound copies. mu
+// guards the views only; run writes reach the store without it and take it
+// afterwards to patch the views.
+type leaderboardState struct {
+	mu      sync.Mutex
+	views   map[LeaderboardFilter]*leaderboardView
+	writing int // run writes begun but not yet applied; see View
+}
+
+type leaderboardView struct {
+	byUser    map[uint]*LeaderboardRow
+	ranked    []LeaderboardRow // nil once byUser changed since the last sort
+	updatedAt time.Time
+}
+
+func newLeaderboardCache(store Store) *leaderboardCache {
+	return &leaderboardCache{Store: store, leaderboardState: &leaderboardState{views: map[LeaderboardFilter]*leaderboardView{}}}
+}
+
+func (c *leaderboardCache) WithContext(ctx context.Context) Store { return c.withContext(ctx) }
+
+// withContext binds the wrapped store to ctx; the views stay shared.
+func (c *leaderboardCache) withContext(ctx context.Context) *leaderboardCache {
+	return &leaderboardCache{Store: c.Store.WithContext(ctx), leaderboardState: c.leaderboardState}
+}
+
+func (c *leaderboardCache) Leaderboard(f LeaderboardFilter, limit int) ([]LeaderboardRow, error) {
+	rows, _, err := c.View(f, limit)
+	return rows, err
+}
+
+// View returns the top limit rows for f and when the view last changed,
+// loading it from the store on first use.
+func (c *leaderboardCache) View(f LeaderboardFilter, limit int) ([]LeaderboardRow, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := c.views[f]
+	if v == nil {
+		rows, err := c.Store.Leaderboard(f, 0)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		v = &leaderboardView{byUser: make(map[uint]*LeaderboardRow, len(rows)), updatedAt: time.Now()}
+		for i := range rows {
+			v.byUser[rows[i].UserID] = &rows[i]
+		}
+		// A write under way may or may not be in what was just read, and it
+		// is applied when it ends, so such a view is served but not kept.
+		if c.writing == 0 {
+			c.views[f] = v
+		}
+	}
+	if v.ranked == nil {
+		v.ranked = make([]LeaderboardRow, 0, len(v.byUser))
+		for _, row := range v.byUser {
+			v.ranked = append(v.ranked, *row)
+		}
+		sort.Slice(v.ranked, func(i, j int) bool {
+			a, b := v.ranked[i], v.ranked[j]
+			if a.TotalHR != b.TotalHR {
+				return a.TotalHR > b.TotalHR
+			}
+			return a.Username < b.Username
+		})
+	}
+	rows := v.ranked
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows, v.updatedAt, nil
+}
+
+// Rebuild drops every view and reloads the unfiltered one.
+func (c *leaderboardCache) Rebuild() error {
+	c.invalidate()
+	_, _, err := c.View(LeaderboardFilter{}, 0)
+	return err
+}
+
+func (c *leaderboardCache) invalidate() {
+	c.mu.Lock()
+	c.views = map[LeaderboardFilter]*leaderboardView{}
+	c.mu.Unlock()
+}
+
+// runChange adds (sign 1) or removes (sign -1) a run's contribution.
+type runChange struct {
+	run  Run
+	sign int
+}
+
+// begin announces a run write that is about to reach the store; end must
+// follow once it is done, whether it succeeded or not.
+func (c *leaderboardCache) begin() {
+	c.mu.Lock()
+	c.writing++
+	c.mu.Unlock()
+}
+
+// end applies the changes of a committed write to every view they belong
+// to. Players new to a view are looked up before taking the lock; with no
+// views there is nothing to look up for, and none are kept until end.
+func (c *leaderboardCache) end(changes ...runChange) {
+	c.mu.Lock()
+	cold := len(c.views) == 0
+	c.mu.Unlock()
+	users := map[uint]User{}
+	for _, ch := range changes {
+		if _, seen := users[ch.run.UserID]; cold || seen || ch.sign < 0 || ch.run.Hidden || ch.run.Flagged {
+			continue
+		}
+		if u, err := c.Store.UserByID(ch.run.UserID); err == nil {
+			users[u.ID] = u
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writing--
+	now := time.Now()
+	for _, ch := range changes {
+		c.apply(ch.run, ch.sign, users, now)
+	}
+}
+
+// apply moves one run in or out of the views. Callers hold c.mu.
+func (c *leaderboardCache) apply(run Run, sign int, users map[uint]User, now time.Time) {
+	if run.Hidden || run.Flagged {
+		return
+	}
+	for f, v := range c.views {
+		if (f.Area != "" && f.Area != run.Area) || (f.Difficulty != "" && f.Difficulty != run.Difficulty) {
+			continue
+		}
+		row := v.byUser[run.UserID]
+		if row == nil {
+			// Views are reset whenever a user is banned or renamed, so only a
+			// player missing from the view needs looking up.
+			user, ok := users[run.UserID]
+			if !ok || user.Banned || sign < 0 {
+				continue
+			}
+			row = &LeaderboardRow{UserID: user.ID, Username: user.Username}
+			v.byUser[run.UserID] = row
+		}
+		row.Runs += sign
+		row.TotalHR += sign * run.HRCount
+		if row.Runs <= 0 {
+			delete(v.byUser, run.UserID)
+		} else {
+			row.AvgHR = float64(row.TotalHR) / float64(row.Runs)
+		}
+		v.ranked = nil
+		v.updatedAt = now
+	}
+}
+
+// rewrite performs write against run id and moves the run's contribution
+// from its state before the write to its state after it.
+func (c *leaderboardCache) rewrite(id uint, write func() error) error {
+	c.begin()
+	before, err := c.Store.RunByID(id)
+	if err != nil {
+		err = write()
+		c.end()
+		return err
+	}
+	if err := write(); err != nil {
+		c.end()
+		return err
+	}
+	changes := []runChange{{before, -1}}
+	if after, err := c.Store.RunByID(id); err == nil {
+		changes = append(changes, runChange{after, 1})
+	}
+	c.end(changes...)
+	return nil
+}
+
+func (c *leaderboardCache) CreateRun(run *Run, drops []RuneDrop) error {
+	c.begin()
+	if err := c.Store.CreateRun(run, drops); err != nil {
+		c.end()
+		return err
+	}
+	c.end(runChange{*run, 1})
+	return nil
+}
+
+func (c *leaderboardCache) CreateRuns(runs []Run, drops [][]RuneDrop) error {
+	c.begin()
+	if err := c.Store.CreateRuns(runs, drops); err != nil {
+		c.end()
+		return err
+	}
+	changes := make([]runChange, len(runs))
+	for i, run := range runs {
+		changes[i] = runChange{run, 1}
+	}
+	c.end(changes...)
+	return nil
+}
+
+func (c *leaderboardCache) SetRunHidden(id uint, hidden bool) error {
+	return c.rewrite(id, func() error { return c.Store.SetRunHidden(id, hidden) })
+}
+
+func (c *leaderboardCache) DeleteRun(id uint) error {
+	return c.rewrite(id, func() error { return c.Store.DeleteRun(id) })
+}
+
+func (c *leaderboardCache) FlagRun(runID uint, flags []RunFlag) error {
+	return c.rewrite(runID, func() error { return c.Store.FlagRun(runID, flags) })
+}
+
+func (c *leaderboardCache) ResolveFlags(runID uint, resolution string, reviewerID uint) error {
+	return c.rewrite(runID, func() error { return c.Store.ResolveFlags(runID, resolution, reviewerID) })
+}
+
+func (c *leaderboardCache) UpdateUser(id uint, fields map[string]any) error {
+	if err := c.Store.UpdateUser(id, fields); err != nil {
+		return err
+	}
+	_, renamed := fields["username"]
+	_, banned := fields["banned"]
+	if renamed || banned {
+		c.invalidate()
+	}
+	return nil
+}
+
+func (c *leaderboardCache) DeleteUser(id uint) error {
+	if err := c.Store.DeleteUser(id); err != nil {
+		return err
+	}
+	c.invalidate()
+	return nil
+}
+
+// ==================== LEADERBOARD ====================
+// leaderboardPage is the view model of /leaderboard (leaderboardView is the
+// cache entry it is built from).
+type leaderboardPage struct {
+	page
+	Areas        []option
+	Difficulties []option
+	Updated      time.Time
+	CanRebuild   bool
+	Rows         []LeaderboardRow
+	Teams        []TeamLeaderboardRow
+}
+
+func (s *server) leaderboardHandler(c *gin.Context) {
+	f := LeaderboardFilter{Area: c.Query("area"), Difficulty: c.Query("difficulty")}
+	// Only known values may become cache keys.
+	if !slices.Contains(areas, f.Area) {
+		f.Area = ""
+	}
+	if !slices.Contains(difficulties, f.Difficulty) {
+		f.Difficulty = ""
+	}
+
+	leaders, updated, err := s.board.withContext(c.Request.Context()).View(f, leaderboardSize)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "leaderboard: %v", err)
+		return
+	}
+
+	teams, err := s.teamLeaderboard(c.Request.Context(), f)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "leaderboard: %v", err)
+		return
+	}
+	if len(teams) > leaderboardSize {
+		teams = teams[:leaderboardSize]
+	}
+
+	l := loc(c)
+	c.HTML(http.StatusOK, "leaderboard", leaderboardPage{
+		page:         newPage(c, "title.leaderboard"),
+		Areas:        filterOptions(l.T("leaderboard.all_areas"), areas, f.Area, l.Area),
+		Difficulties: filterOptions(l.T("leaderboard.all_difficulties"), difficulties, f.Difficulty, l.Difficulty),
+		Updated:      updated,
+		CanRebuild:   roleRank[c.GetString("role")] >= roleRank[RoleAdmin],
+		Rows:         leaders,
+		Teams:        teams,
+	})
+}
+
+// filterOptions is selectOptions with a leading "any" entry.
+func filterOptions(all string, values []string, selected string, label func(string) string) []option {
+	return append([]option{{Label: all}}, selectOptions(values, selected, label)...)
+}
+
+func (s *server) rebuildLeaderboardHandler(c *gin.Context) {
+	if err := s.board.withContext(c.Request.Context()).Rebuild(); err != nil {
+		reqLog(c).Error("leaderboard rebuild failed", "err", err)
+	}
+	s.leaderboardChanged()
+	c.Redirect(http.StatusFound, "/leaderboard")
+}
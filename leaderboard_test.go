@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// go test -run '^$' -bench Leaderboard -args -bench.runs=1000000
+var benchRuns = flag.Int("bench.runs", 100000, "runs seeded for the leaderboard benchmarks")
+
+var benchFilters = []struct {
+	name   string
+	filter LeaderboardFilter
+}{
+	{"all", LeaderboardFilter{}},
+	{"area", LeaderboardFilter{Area: "Chaos Sanctuary", Difficulty: "Hell"}},
+}
+
+// benchStore is a SQLite store seeded with -bench.runs runs by benchUsers
+// players. Seeding is not timed.
+func benchStore(b *testing.B) *gormStore {
+	b.Helper()
+	store := testGormStore(b)
+	if err := seedRuns(store.db, benchUsers, *benchRuns); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	return store
+}
+
+// BenchmarkLeaderboardUncached is the GROUP BY over every run.
+func BenchmarkLeaderboardUncached(b *testing.B) {
+	store := benchStore(b)
+	for _, bf := range benchFilters {
+		b.Run(bf.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := store.Leaderboard(bf.filter, leaderboardSize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLeaderboardCached reads a view that is already built.
+func BenchmarkLeaderboardCached(b *testing.B) {
+	board := newLeaderboardCache(benchStore(b))
+	for _, bf := range benchFilters {
+		if _, _, err := board.View(bf.filter, leaderboardSize); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(bf.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				board.View(bf.filter, leaderboardSize)
+			}
+		})
+	}
+}
+
+// BenchmarkLeaderboardCachedWrite logs a run and reads the overall view
+// again, which is the cost the cache adds to every logged run.
+func BenchmarkLeaderboardCachedWrite(b *testing.B) {
+	board := newLeaderboardCache(benchStore(b))
+	board.View(LeaderboardFilter{}, leaderboardSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		run := Run{UserID: uint(1 + rand.Intn(benchUsers)), Area: "Chaos Sanctuary", Difficulty: "Hell", HRCount: 1, Timestamp: time.Now()}
+		if err := board.CreateRun(&run, nil); err != nil {
+			b.Fatal(err)
+		}
+		board.View(LeaderboardFilter{}, leaderboardSize)
+	}
+}
+
+// The uncached and cached views must agree, or the benchmarks compare
+// different work.
+func TestLeaderboardCacheMatchesStore(t *testing.T) {
+	store := testGormStore(t)
+	if err := seedRuns(store.db, 50, 2000); err != nil {
+		t.Fatal(err)
+	}
+	board := newLeaderboardCache(store)
+	for _, bf := range benchFilters {
+		want, err := store.Leaderboard(bf.filter, leaderboardSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := board.View(bf.filter, leaderboardSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d cached rows, %d uncached", bf.name, len(got), len(want))
+		}
+		for i := range want {
+			if got[i].UserID != want[i].UserID || got[i].TotalHR != want[i].TotalHR || got[i].Runs != want[i].Runs {
+				t.Errorf("%s row %d: cached %+v, uncached %+v", bf.name, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+// blockingStore holds CreateRuns until release is closed.
+type blockingStore struct {
+	Store
+	entered, release chan struct{}
+}
+
+func (s blockingStore) CreateRuns(runs []Run, drops [][]RuneDrop) error {
+	close(s.entered)
+	<-s.release
+	return s.Store.CreateRuns(runs, drops)
+}
+
+// A slow import must not hold up the leaderboard, and what it wrote must be
+// in the views once it is done.
+func TestLeaderboardCacheReadsDuringWrite(t *testing.T) {
+	store := blockingStore{Store: newMemStore(), entered: make(chan struct{}), release: make(chan struct{})}
+	alice := User{Username: "alice"}
+	if err := store.CreateUser(&alice); err != nil {
+		t.Fatal(err)
+	}
+	board := newLeaderboardCache(store)
+	if _, _, err := board.View(LeaderboardFilter{}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- board.CreateRuns([]Run{{UserID: alice.ID, Area: "Mephisto", Difficulty: "Hell", HRCount: 2, Timestamp: time.Now()}}, [][]RuneDrop{nil})
+	}()
+	<-store.entered
+	read := make(chan struct{})
+	go func() {
+		board.View(LeaderboardFilter{}, 0)
+		board.View(LeaderboardFilter{Area: "Mephisto"}, 0) // loaded mid-write
+		close(read)
+	}()
+	select {
+	case <-read:
+	case <-time.After(5 * time.Second):
+		t.Fatal("View waited for the write")
+	}
+	close(store.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []LeaderboardFilter{{}, {Area: "Mephisto"}} {
+		rows, _, _ := board.View(f, 0)
+		if len(rows) != 1 || rows[0].TotalHR != 2 || rows[0].Runs != 1 {
+			t.Errorf("%+v after the write: %+v", f, rows)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== LIVE UPDATES ====================
+
+// Pages subscribe to /events (Server-Sent Events) and repaint when runs are
+// logged. The hub is in-process: with several instances, a browser only hears
+// about runs logged through the instance it is connected to.
+
+const (
+	liveHeartbeat = 25 * time.Second // keeps proxies from closing an idle stream
+	liveRetry     = 5 * time.Second  // browser reconnect delay
+	liveBuffer    = 16               // events queued per subscriber before dropping
+)
+
+// liveEvent is one SSE message. UserID 0 goes to every subscriber.
+type liveEvent struct {
+	Name   string
+	UserID uint
+	data   []byte
+}
+
+type hub struct {
+	mu     sync.Mutex
+	subs   map[chan liveEvent]uint // subscriber → user
+	closed bool
+}
+
+func newHub() *hub { return &hub{subs: map[chan liveEvent]uint{}} }
+
+// subscribe registers a stream for userID; it fails once the hub is closed.
+func (h *hub) subscribe(userID uint) (chan liveEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, false
+	}
+	ch := make(chan liveEvent, liveBuffer)
+	h.subs[ch] = userID
+	return ch, true
+}
+
+func (h *hub) unsubscribe(ch chan liveEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// newLiveEvent encodes data as an event for one stream.
+func newLiveEvent(name string, data any) (liveEvent, error) {
+	b, err := json.Marshal(data)
+	return liveEvent{Name: name, data: b}, err
+}
+
+// publish never blocks: a subscriber that is not keeping up misses the
+// event. Every event carries complete state, so the next one catches it up.
+func (h *hub) publish(name string, userID uint, data any) {
+	ev, err := newLiveEvent(name, data)
+	if err != nil {
+		slog.Error("live event encode failed", "event", name, "err", err)
+		return
+	}
+	ev.UserID = userID
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch, uid := range h.subs {
+		if userID != 0 && uid != userID {
+			continue
+		}
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// close ends every stream so graceful shutdown does not wait for them.
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *hub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// totalsJSON is the dashboard counters as sent by /log-run and "stats" events.
+func totalsJSON(t UserTotals) gin.H {
+	avgHR, efficiency := t.rates()
+	return gin.H{"runs": t.Runs, "hr": t.HR, "uniques": t.Uniques, "sets": t.Sets, "avg_hr": avgHR, "efficiency": efficiency}
+}
+
+// runsChanged tells userID's pages their new totals and every leaderboard
+// page that it may have moved.
+func (s *server) runsChanged(userID uint, t UserTotals) {
+	s.hub.publish("stats", userID, totalsJSON(t))
+	s.leaderboardChanged()
+}
+
+func (s *server) leaderboardChanged() {
+	s.hub.publish("leaderboard", 0, gin.H{"at": time.Now()})
+}
+
+func (s *server) eventsHandler(c *gin.Context) {
+	s.stream(c, sessions.Default(c).Get("user_id").(uint), nil)
+}
+
+// relay turns a hub event into what one stream sends: ok=false skips the
+// event and an error ends the stream.
+type relay func(ev liveEvent) (out liveEvent, ok bool, err error)
+
+// stream serves userID's hub events as SSE until the client goes away or the
+// hub closes, passing each through relay when there is one.
+func (s *server) stream(c *gin.Context, userID uint, relay relay) {
+	ch, ok := s.hub.subscribe(userID)
+	if !ok {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	defer s.hub.unsubscribe(ch)
+
+	// The server's read and write timeouts are sized for ordinary requests;
+	// a stream clears the read deadline and pushes the write deadline ahead
+	// of every message instead.
+	rc := http.NewResponseController(c.Writer)
+	rc.SetReadDeadline(time.Time{})
+	send := func(msg string) bool {
+		rc.SetWriteDeadline(time.Now().Add(liveHeartbeat + 10*time.Second))
+		if _, err := c.Writer.WriteString(msg); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // nginx: do not buffer the stream
+	c.Status(http.StatusOK)
+	if !send(fmt.Sprintf("retry: %d\n\n", liveRetry.Milliseconds())) {
+		return
+	}
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var msg string
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case ev, open := <-ch:
+			if !open {
+				return
+			}
+			if relay != nil {
+				var err error
+				if ev, ok, err = relay(ev); err != nil {
+					return
+				} else if !ok {
+					continue
+				}
+			}
+			msg = "event: " + ev.Name + "\ndata: " + string(ev.data) + "\n\n"
+		case <-heartbeat.C:
+			msg = ": ping\n\n"
+		}
+		if !send(msg) {
+			return
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	Name, Data string
+}
+
+// events opens the SSE stream at path and returns its events. It returns once
+// the server is subscribed, so anything published afterwards arrives.
+func (c *testClient) events(path string) <-chan sseEvent {
+	c.t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	c.t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		c.t.Fatalf("%s: %d", path, res.StatusCode)
+	}
+	lines := bufio.NewScanner(res.Body)
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "retry:") {
+		c.t.Fatalf("%s: stream opened with %q", path, lines.Text())
+	}
+	out := make(chan sseEvent, liveBuffer)
+	go func() {
+		defer res.Body.Close()
+		defer close(out)
+		var ev sseEvent
+		for lines.Scan() {
+			switch line := lines.Text(); {
+			case strings.HasPrefix(line, "event: "):
+				ev.Name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.Data = strings.TrimPrefix(line, "data: ")
+			case line == "" && ev.Name != "":
+				out <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return out
+}
+
+// nextEvent waits for the next event called name and returns it with the
+// events that came before it.
+func nextEvent(t *testing.T, stream <-chan sseEvent, name string) (sseEvent, []sseEvent) {
+	t.Helper()
+	var before []sseEvent
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-stream:
+			if !ok {
+				t.Fatalf("stream ended waiting for %q after %v", name, before)
+			}
+			if ev.Name == name {
+				return ev, before
+			}
+			before = append(before, ev)
+		case <-timeout:
+			t.Fatalf("no %q event, got %v", name, before)
+		}
+	}
+}
+
+// A logged run repaints the player's own pages with their totals and tells
+// every page the leaderboard moved; nobody else hears the player's totals.
+func TestLogRunPublishesEvents(t *testing.T) {
+	ts := newTestServer(t, newMemStore())
+	alice, bob := ts.user(t, "alice"), ts.user(t, "bob")
+	aliceEvents, bobEvents := alice.events("/events"), bob.events("/events")
+
+	res := alice.post("/log-run", url.Values{"area": {"Mephisto"}, "difficulty": {"Hell"}, "uniques": {"3"}, "runes": {`[{"rune":"Ber","qty":1}]`}})
+	if res.Status != http.StatusOK {
+		t.Fatalf("log-run: %d %s", res.Status, res.Body)
+	}
+
+	stats, _ := nextEvent(t, aliceEvents, "stats")
+	var totals struct{ Runs, HR, Uniques int }
+	if err := json.Unmarshal([]byte(stats.Data), &totals); err != nil || totals.Runs != 1 || totals.HR != 1 || totals.Uniques != 3 {
+		t.Errorf("alice's stats event: %s (%v)", stats.Data, err)
+	}
+	nextEvent(t, aliceEvents, "leaderboard")
+
+	// Stats go out before the leaderboard event, so they would show up here.
+	if _, before := nextEvent(t, bobEvents, "leaderboard"); len(before) != 0 {
+		t.Errorf("bob's stream got %v", before)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// loggerKey holds the request-scoped *slog.Logger in the request's context,
+// so code below the handlers (the store, GORM) logs with the same attributes.
+type loggerKey struct{}
+
+// A client-supplied request ID is kept only if it is short and printable, so
+// it can be trusted in log lines and response headers.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// newLogger builds the process logger from log.level and log.format. It also
+// becomes slog's default, so anything still using the log package goes
+// through it at INFO.
+func newLogger(cfg LogConfig) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: logLevel(cfg.Level)}
+	var h slog.Handler
+	if cfg.Format == "json" {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	l := slog.New(h)
+	slog.SetDefault(l)
+	return l
+}
+
+func logLevel(name string) slog.Level {
+	switch name {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// fatal logs at ERROR and exits, the slog counterpart of log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// reqLog returns the logger for this request: request ID always, user ID once
+// authMiddleware has identified the caller.
+func reqLog(c *gin.Context) *slog.Logger { return ctxLog(c.Request.Context()) }
+
+// ctxLog is reqLog for code that only has the request's context.
+func ctxLog(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// withLogAttrs adds attributes to every later log line of this request,
+// including the access log and its SQL statements.
+func withLogAttrs(c *gin.Context, args ...any) {
+	setReqLog(c, reqLog(c).With(args...))
+}
+
+func setReqLog(c *gin.Context, l *slog.Logger) {
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), loggerKey{}, l))
+}
+
+// requestLogger tags the request with an ID, echoes it in X-Request-ID and
+// writes one access log line when the request is done. Probe traffic is
+// logged at DEBUG so it does not drown the rest.
+func requestLogger(base *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Header(requestIDHeader, id)
+		setReqLog(c, base.With("request_id", id))
+
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case c.FullPath() == "/healthz" || c.FullPath() == "/readyz" || c.FullPath() == "/metrics":
+			level = slog.LevelDebug
+		}
+		// A :token in the route is a credential (overlay URLs); keep it out of logs.
+		path := c.Request.URL.Path
+		if token := c.Param("token"); token != "" {
+			path = strings.Replace(path, token, "…", 1)
+		}
+		reqLog(c).Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", path,
+			"route", c.FullPath(),
+			"status", status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", c.Writer.Size(),
+			"ip", c.ClientIP(),
+		)
+	}
+}
+
+// recoverPanics replaces gin.Recovery so a panic is one structured ERROR line
+// carrying the request ID instead of a bare stack dump on stderr.
+func recoverPanics() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		reqLog(c).Error("panic", "err", fmt.Sprint(err), "stack", string(debug.Stack()))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// ==================== GORM ====================
+
+// gormLogger sends GORM's output through slog: failed statements at ERROR,
+// statements slower than slow at WARN, everything else at DEBUG. Statements
+// run with a request's context carry its request and user IDs.
+type gormLogger struct {
+	log   *slog.Logger
+	slow  time.Duration
+	level logger.LogLevel
+}
+
+func newGormLogger(l *slog.Logger, cfg LogConfig) logger.Interface {
+	level := logger.Warn
+	if cfg.Level == "debug" {
+		level = logger.Info
+	}
+	return &gormLogger{log: l.With("component", "gorm"), slow: cfg.SlowQuery.Duration, level: level}
+}
+
+func (g *gormLogger) logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l.With("component", "gorm")
+	}
+	return g.log
+}
+
+func (g *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	c := *g
+	c.level = level
+	return &c
+}
+
+// ParamsFilter keeps bound values out of logged SQL: statements touch
+// password hashes, TOTP secrets and recovery codes.
+func (g *gormLogger) ParamsFilter(_ context.Context, sql string, _ ...any) (string, []any) {
+	return sql, nil
+}
+
+func (g *gormLogger) Info(ctx context.Context, msg string, data ...any) {
+	if g.level >= logger.Info {
+		g.logger(ctx).InfoContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (g *gormLogger) Warn(ctx context.Context, msg string, data ...any) {
+	if g.level >= logger.Warn {
+		g.logger(ctx).WarnContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (g *gormLogger) Error(ctx context.Context, msg string, data ...any) {
+	if g.level >= logger.Error {
+		g.logger(ctx).ErrorContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (g *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if g.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	attrs := func() []any {
+		sql, rows := fc()
+		return []any{"sql", sql, "rows", rows, "duration_ms", float64(elapsed.Microseconds()) / 1000}
+	}
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && g.level >= logger.Error:
+		g.logger(ctx).ErrorContext(ctx, "query failed", append(attrs(), "err", err)...)
+	case g.slow > 0 && elapsed > g.slow && g.level >= logger.Warn:
+		g.logger(ctx).WarnContext(ctx, "slow query", append(attrs(), "threshold_ms", g.slow.Milliseconds())...)
+	case g.level >= logger.Info:
+		g.logger(ctx).DebugContext(ctx, "query", attrs()...)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestQueryLogsCarryRequest(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	prev := slog.Default()
+	slog.SetDefault(log)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/test.db"), &gorm.Config{Logger: newGormLogger(log, LogConfig{Level: "debug"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestServer(t, newGormStore(db))
+	c := ts.user(t, "alice")
+	user, _ := ts.store.UserByUsername("alice")
+
+	buf.Reset()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/dashboard", nil)
+	req.Header.Set(requestIDHeader, "req-42")
+	if res := c.do(req); res.Status != http.StatusOK {
+		t.Fatalf("dashboard: %d", res.Status)
+	}
+
+	queries := 0
+	for sc := bufio.NewScanner(&buf); sc.Scan(); {
+		var line struct {
+			Msg       string  `json:"msg"`
+			Component string  `json:"component"`
+			RequestID string  `json:"request_id"`
+			UserID    float64 `json:"user_id"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil || line.Component != "gorm" {
+			continue
+		}
+		queries++
+		// The session lookup runs before the user is known; every later
+		// statement must name them.
+		if line.RequestID != "req-42" || (queries > 1 && uint(line.UserID) != user.ID) {
+			t.Errorf("query log line without the request: %s", sc.Text())
+		}
+	}
+	if queries < 2 {
+		t.Fatalf("only %d query log lines:\n%s", queries, buf.String())
+	}
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"html/template"
+	"errors"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-contrib/sessions"
@@ -18,37 +21,50 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 type User struct {
 	ID       uint   `gorm:"primaryKey"`
 	Username string `gorm:"uniqueIndex"`
 	Password string
+
+	Role   string `gorm:"default:user"`
+	Banned bool
+
+	TOTPSecret    string
+	TOTPEnabled   bool
+	TOTPLastStep  int64 // newest accepted TOTP time step; older codes are refused
+	TwoFAFailures int   // wrong second-factor codes in the window below
+	TwoFAFailedAt int64 // unix time of the first of them
+	SessionEpoch  int
+	Locale        string // preferred UI language; empty follows the browser
+	OverlayToken  string // secret in the public OBS overlay URL; empty = off
 }
 
 type Run struct {
-	ID         uint      `gorm:"primaryKey"`
-	UserID     uint
-	Area       string
-	Difficulty string
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index:idx_runs_user_timestamp,priority:1"`
+	Area       string `gorm:"index:idx_runs_area_difficulty,priority:1"`
+	Difficulty string `gorm:"index:idx_runs_area_difficulty,priority:2"`
 	Uniques    int
 	Sets       int
 	HRCount    int
 	SessionSec int
-	Timestamp  time.Time
+	Timestamp  time.Time `gorm:"index:idx_runs_user_timestamp,priority:2"`
+	Hidden     bool
+	Flagged    bool
+	ExternalID string // the run's ID in an imported file; empty when logged here
 }
 
 type RuneDrop struct {
-	ID    uint `gorm:"primaryKey"`
-	RunID uint
-	Rune  string
+	ID    uint   `gorm:"primaryKey"`
+	RunID uint   `gorm:"index"`
+	Rune  string `gorm:"index"`
 	Qty   int
 }
 
 var (
-	db    *gorm.DB
-	store = cookie.NewStore([]byte("d2r-secret-render-2026"))
-
 	areas = []string{
 		"Countess (Hrabina)", "Radament", "Travincal Council", "Lower Kurast (LK)", "Mephisto",
 		"Chaos Sanctuary", "Baal Waves", "Cow Level", "Pindleskin", "Nihlathak", "The Pit",
@@ -70,130 +86,367 @@ var (
 		"Jah", "Cham", "Zod",
 	}
 
-	runeIcons = map[string]string{
-		"El":   "https://static.wikia.nocookie.net/diablo/images/8/8f/El_Rune.png/revision/latest/scale-to-width-down/64",
-		"Eld":  "https://static.wikia.nocookie.net/diablo/images/1/1d/Eld_Rune.png/revision/latest/scale-to-width-down/64",
-		"Tir":  "https://static.wikia.nocookie.net/diablo/images/3/3f/Tir_Rune.png/revision/latest/scale-to-width-down/64",
-		"Nef":  "https://static.wikia.nocookie.net/diablo/images/9/9e/Nef_Rune.png/revision/latest/scale-to-width-down/64",
-		"Eth":  "https://static.wikia.nocookie.net/diablo/images/5/5f/Eth_Rune.png/revision/latest/scale-to-width-down/64",
-		"Ith":  "https://static.wikia.nocookie.net/diablo/images/6/6e/Ith_Rune.png/revision/latest/scale-to-width-down/64",
-		"Tal":  "https://static.wikia.nocookie.net/diablo/images/4/4f/Tal_Rune.png/revision/latest/scale-to-width-down/64",
-		"Ral":  "https://static.wikia.nocookie.net/diablo/images/7/7f/Ral_Rune.png/revision/latest/scale-to-width-down/64",
-		"Ort":  "https://static.wikia.nocookie.net/diablo/images/2/2f/Ort_Rune.png/revision/latest/scale-to-width-down/64",
-		"Thul": "https://static.wikia.nocookie.net/diablo/images/0/0f/Thul_Rune.png/revision/latest/scale-to-width-down/64",
-		"Amn":  "https://static.wikia.nocookie.net/diablo/images/9/9f/Amn_Rune.png/revision/latest/scale-to-width-down/64",
-		"Sol":  "https://static.wikia.nocookie.net/diablo/images/5/5f/Sol_Rune.png/revision/latest/scale-to-width-down/64",
-		"Shael": "https://static.wikia.nocookie.net/diablo/images/3/3f/Shael_Rune.png/revision/latest/scale-to-width-down/64",
-		"Dol":  "https://static.wikia.nocookie.net/diablo/images/1/1f/Dol_Rune.png/revision/latest/scale-to-width-down/64",
-		"Hel":  "https://static.wikia.nocookie.net/diablo/images/8/8f/Hel_Rune.png/revision/latest/scale-to-width-down/64",
-		"Io":   "https://static.wikia.nocookie.net/diablo/images/2/2f/Io_Rune.png/revision/latest/scale-to-width-down/64",
-		"Lum":  "https://static.wikia.nocookie.net/diablo/images/9/9f/Lum_Rune.png/revision/latest/scale-to-width-down/64",
-		"Ko":   "https://static.wikia.nocookie.net/diablo/images/4/4f/Ko_Rune.png/revision/latest/scale-to-width-down/64",
-		"Fal":  "https://static.wikia.nocookie.net/diablo/images/7/7f/Fal_Rune.png/revision/latest/scale-to-width-down/64",
-		"Lem":  "https://static.wikia.nocookie.net/diablo/images/0/0f/Lem_Rune.png/revision/latest/scale-to-width-down/64",
-		"Pul":  "https://static.wikia.nocookie.net/diablo/images/5/5f/Pul_Rune.png/revision/latest/scale-to-width-down/64",
-		"Um":   "https://static.wikia.nocookie.net/diablo/images/3/3f/Um_Rune.png/revision/latest/scale-to-width-down/64",
-		"Mal":  "https://static.wikia.nocookie.net/diablo/images/1/1f/Mal_Rune.png/revision/latest/scale-to-width-down/64",
-		"Ist":  "https://static.wikia.nocookie.net/diablo/images/8/8f/Ist_Rune.png/revision/latest/scale-to-width-down/64",
-		"Gul":  "https://static.wikia.nocookie.net/diablo/images/2/2f/Gul_Rune.png/revision/latest/scale-to-width-down/64",
-		"Vex":  "https://static.wikia.nocookie.net/diablo/images/9/9f/Vex_Rune.png/revision/latest/scale-to-width-down/64",
-		"Ohm":  "https://static.wikia.nocookie.net/diablo/images/4/4f/Ohm_Rune.png/revision/latest/scale-to-width-down/64",
-		"Lo":   "https://static.wikia.nocookie.net/diablo/images/7/7f/Lo_Rune.png/revision/latest/scale-to-width-down/64",
-		"Sur":  "https://static.wikia.nocookie.net/diablo/images/0/0f/Sur_Rune.png/revision/latest/scale-to-width-down/64",
-		"Ber":  "https://static.wikia.nocookie.net/diablo/images/5/5f/Ber_Rune.png/revision/latest/scale-to-width-down/64",
-		"Jah":  "https://static.wikia.nocookie.net/diablo/images/3/3f/Jah_Rune.png/revision/latest/scale-to-width-down/64",
-		"Cham": "https://static.wikia.nocookie.net/diablo/images/1/1f/Cham_Rune.png/revision/latest/scale-to-width-down/64",
-		"Zod":  "https://static.wikia.nocookie.net/diablo/images/8/8f/Zod_Rune.png/revision/latest/scale-to-width-down/64",
-	}
+	runeIcons = runeIconURLs()
 )
 
-func initDB() {
-	var err error
-	if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
-		db, err = gorm.Open(postgres.Open(dbURL), &gorm.Config{})
-		log.Println("✅ Połączono z PostgreSQL (Render.com)")
+// server carries the dependencies shared by every handler.
+type server struct {
+	cfg      *Config
+	store    Store
+	board    *leaderboardCache
+	metrics  *metrics
+	hub      *hub
+	webhooks *webhookSender
+	twoFA    *twoFALogins
+
+	draining atomic.Bool // set once shutdown starts; fails /readyz
+}
+
+// reqStore is the store bound to the request, so its queries stop with it and
+// their log lines carry its request and user IDs.
+func (s *server) reqStore(c *gin.Context) Store {
+	return s.store.WithContext(c.Request.Context())
+}
+
+// newServer puts the leaderboard cache in front of store so every run write
+// made through s.store keeps the cached views current.
+func newServer(cfg *Config, store Store) *server {
+	board := newLeaderboardCache(store)
+	h := newHub()
+	m := newMetrics(board, h)
+	return &server{cfg: cfg, store: board, board: board, metrics: m, hub: h, webhooks: newWebhookSender(cfg.Webhooks, board, m), twoFA: newTwoFALogins()}
+}
+
+func initDB(cfg DatabaseConfig, queryLog logger.Interface) *gorm.DB {
+	var dialector gorm.Dialector
+	if cfg.Driver == "postgres" {
+		dialector = postgres.Open(cfg.DSN)
 	} else {
-		db, err = gorm.Open(sqlite.Open("d2r_tracker.db"), &gorm.Config{})
-		log.Println("✅ Używam lokalnego SQLite")
+		dialector = sqlite.Open(cfg.DSN)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: queryLog})
+	if err != nil {
+		fatal("database open failed", "driver", cfg.Driver, "err", err)
 	}
+	pool, err := db.DB()
 	if err != nil {
-		log.Fatal(err)
+		fatal("database pool unavailable", "err", err)
 	}
-	db.AutoMigrate(&User{}, &Run{}, &RuneDrop{})
+	pool.SetMaxOpenConns(cfg.MaxOpenConns)
+	pool.SetMaxIdleConns(cfg.MaxIdleConns)
+	pool.SetConnMaxLifetime(cfg.ConnMaxLifetime.Duration)
+	slog.Info("database ready", "driver", cfg.Driver, "max_open_conns", cfg.MaxOpenConns)
+	return db
 }
 
 func main() {
-	initDB()
-	r := gin.Default()
-	r.Use(sessions.Sessions("d2rsession", store))
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("❌ %v", err) // slog is not set up yet; keep the problem list readable
+	}
+	appLog := newLogger(cfg.Log)
+	setDefaultLocale(cfg.DefaultLocale)
+	if cfg.Session.DevKey {
+		appLog.Warn("session.dev_key: cookies are signed with a throwaway key; set session.keys outside local development")
+	}
+	db := initDB(cfg.Database, newGormLogger(appLog, cfg.Log))
+	if cfg.Features.OIDC {
+		initOIDC(cfg.BaseURL)
+	}
+	s := newServer(cfg, newGormStore(db))
+	if err := s.metrics.instrumentDB(db); err != nil {
+		fatal("database metrics setup failed", "err", err)
+	}
+	// "migrate" manages the schema by hand; everything else needs it current.
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		if _, err := migrateUp(db); err != nil {
+			fatal("database schema check failed", "err", err)
+		}
+	}
+	if len(os.Args) > 1 {
+		s.runCommand(db, os.Args[1:])
+		return
+	}
 
-	tmpl := template.Must(template.New("").Parse(d2rTemplate))
-	r.SetHTMLTemplate(tmpl)
+	appLog.Info("D2R Farm Tracker v2.1 started", "base_url", cfg.BaseURL, "listen", cfg.Listen, "tls", cfg.TLS.Enabled())
+	if err := s.serve(db); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal("server failed", "err", err)
+	}
+	appLog.Info("server stopped")
+}
+
+func (s *server) router() *gin.Engine {
+	if s.cfg.Log.Level != "debug" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	r := gin.New()
+	r.Use(requestLogger(slog.Default()), recoverPanics())
+	r.GET("/healthz", healthzHandler)
+	r.GET("/static/*path", staticHandler)
+	r.HEAD("/static/*path", staticHandler)
+	r.GET("/readyz", s.readyzHandler)
+	if s.cfg.Features.Metrics {
+		r.GET("/metrics", s.metrics.handler())
+		r.Use(s.metrics.middleware())
+	}
+
+	cookies := cookie.NewStore(s.cfg.sessionKeyPairs()...)
+	cookies.Options(sessions.Options{
+		Path:     "/",
+		MaxAge:   int(s.cfg.Session.MaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   s.cfg.Session.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	r.Use(sessions.Sessions("d2rsession", cookies))
+	r.Use(localeMiddleware())
+
+	pages, err := loadTemplates()
+	if err != nil {
+		fatal("templates", "err", err)
+	}
+	r.HTMLRender = pages
 
 	r.GET("/", func(c *gin.Context) { c.Redirect(http.StatusFound, "/login") })
 
 	r.GET("/login", loginPage)
-	r.POST("/login", loginHandler)
-	r.GET("/register", registerPage)
-	r.POST("/register", registerHandler)
+	r.POST("/login", s.loginHandler)
+	if s.cfg.Features.Registration {
+		r.GET("/register", registerPage)
+		r.POST("/register", s.registerHandler)
+	} else {
+		r.Any("/register", registrationClosed)
+	}
 	r.GET("/logout", logoutHandler)
+	r.GET("/login/2fa", s.twoFAPage)
+	r.POST("/login/2fa", s.twoFAHandler)
+	r.GET("/auth/oidc/:provider", oidcLoginHandler)
+	r.GET("/auth/oidc/:provider/callback", s.oidcCallbackHandler)
+	r.GET("/overlay/:token", s.overlayPage)
+	r.GET("/overlay/:token/events", s.overlayEventsHandler)
+	if s.cfg.Bot.DiscordPublicKey != "" {
+		r.POST("/bot/discord", s.discordBotHandler)
+	}
+	if s.cfg.Bot.SlackSigningSecret != "" {
+		r.POST("/bot/slack", s.slackBotHandler)
+	}
 
 	protected := r.Group("/")
-	protected.Use(authMiddleware())
+	protected.Use(s.authMiddleware())
 	{
-		protected.GET("/dashboard", dashboardHandler)
-		protected.POST("/log-run", logRunHandler)
-		protected.GET("/leaderboard", leaderboardHandler)
-		protected.GET("/my-stats", myStatsHandler)
+		protected.GET("/dashboard", s.dashboardHandler)
+		protected.POST("/log-run", s.logRunHandler)
+		protected.GET("/events", s.eventsHandler)
+		protected.GET("/leaderboard", s.leaderboardHandler)
+		protected.GET("/my-stats", s.myStatsHandler)
+		protected.GET("/team", s.teamHomePage)
+		protected.POST("/teams", s.createTeamHandler)
+		protected.GET("/teams/:id", s.teamPage)
+		protected.POST("/teams/:id/join", s.joinTeamHandler)
+		protected.POST("/teams/:id/invite", s.inviteTeamHandler)
+		protected.POST("/teams/:id/requests/:rid/:action", s.teamRequestHandler)
+		protected.POST("/teams/:id/members/:uid/role", s.teamRoleHandler)
+		protected.POST("/teams/:id/members/:uid/remove", s.teamRemoveHandler)
+		protected.POST("/teams/:id/leave", s.leaveTeamHandler)
+		protected.POST("/teams/:id/delete", s.deleteTeamHandler)
+		protected.GET("/account", s.accountPage)
+		protected.POST("/account/password", s.changePasswordHandler)
+		protected.POST("/account/username", s.changeUsernameHandler)
+		protected.POST("/account/locale", s.changeLocaleHandler)
+		protected.POST("/account/delete", s.deleteAccountHandler)
+		protected.GET("/account/export", s.exportAccountHandler)
+		protected.GET("/account/export/:file", s.exportCSVHandler)
+		protected.GET("/import", s.importPage)
+		protected.POST("/import", s.importHandler)
+		protected.POST("/account/oidc/:provider/link", s.linkIdentityHandler)
+		protected.POST("/account/oidc/:provider/unlink", s.unlinkIdentityHandler)
+		protected.POST("/account/overlay", s.overlayTokenHandler)
+		protected.POST("/account/overlay/revoke", s.overlayRevokeHandler)
+		if s.cfg.Features.Webhooks {
+			protected.GET("/account/webhooks", s.webhooksPage)
+			protected.POST("/account/webhooks", s.createWebhookHandler)
+			protected.POST("/account/webhooks/:id/delete", s.deleteWebhookHandler)
+			protected.POST("/account/webhooks/:id/test", s.testWebhookHandler)
+		}
+		if s.cfg.Bot.Enabled() {
+			protected.GET("/account/chat/link", s.chatLinkPage)
+			protected.POST("/account/chat/link", s.chatLinkHandler)
+			protected.POST("/account/chat/:id/unlink", s.chatUnlinkHandler)
+		}
+		protected.GET("/account/2fa", s.twoFASettingsPage)
+		protected.POST("/account/2fa/setup", s.twoFASetupHandler)
+		protected.POST("/account/2fa/enable", s.twoFAEnableHandler)
+		protected.POST("/account/2fa/disable", s.twoFADisableHandler)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	admin := protected.Group("/admin")
+	admin.Use(requireRole(RoleModerator))
+	{
+		admin.GET("", s.adminUsersPage)
+		admin.POST("/users/:id/ban", s.adminBanHandler)
+		admin.POST("/users/:id/unban", s.adminBanHandler)
+		admin.POST("/users/:id/reset-password", requireRole(RoleAdmin), s.adminResetPasswordHandler)
+		admin.POST("/users/:id/reset-2fa", requireRole(RoleAdmin), s.adminResetTwoFAHandler)
+		admin.POST("/users/:id/role", requireRole(RoleAdmin), s.adminRoleHandler)
+		admin.GET("/runs", s.adminRunsPage)
+		admin.POST("/runs/:id/hide", s.adminHideRunHandler)
+		admin.POST("/runs/:id/unhide", s.adminHideRunHandler)
+		admin.POST("/runs/:id/delete", requireRole(RoleAdmin), s.adminDeleteRunHandler)
+		admin.GET("/flags", s.adminFlagsPage)
+		admin.POST("/runs/:id/clear", s.adminClearRunHandler)
+		admin.POST("/leaderboard/rebuild", requireRole(RoleAdmin), s.rebuildLeaderboardHandler)
+		if s.cfg.Features.Webhooks {
+			admin.GET("/webhooks", requireRole(RoleAdmin), s.webhooksPage)
+			admin.POST("/webhooks", requireRole(RoleAdmin), s.createWebhookHandler)
+			admin.POST("/webhooks/:id/delete", requireRole(RoleAdmin), s.deleteWebhookHandler)
+			admin.POST("/webhooks/:id/test", requireRole(RoleAdmin), s.testWebhookHandler)
+		}
 	}
-	log.Printf("🚀 D2R Farm Tracker v2.1 uruchomiony na http://localhost:%s", port)
-	r.Run(":" + port)
+	return r
 }
 
-func authMiddleware() gin.HandlerFunc {
+func (s *server) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		session := sessions.Default(c)
-		if session.Get("user_id") == nil {
+		userID, ok := session.Get("user_id").(uint)
+		if !ok {
+			c.Redirect(http.StatusFound, "/login")
+			c.Abort()
+			return
+		}
+		epoch, _ := session.Get("session_epoch").(int)
+		user, err := s.reqStore(c).UserByID(userID)
+		if err != nil || user.SessionEpoch != epoch || user.Banned {
+			session.Clear()
+			session.Save()
 			c.Redirect(http.StatusFound, "/login")
 			c.Abort()
 			return
 		}
+		c.Set("role", user.Role)
+		useUserLocale(c, user.Locale)
+		withLogAttrs(c, "user_id", user.ID)
 		c.Next()
 	}
 }
 
+// ==================== CLI ====================
+func (s *server) runCommand(db *gorm.DB, args []string) {
+	l := newLocalizer(defaultLocale)
+	switch args[0] {
+	case "migrate":
+		runMigrateCommand(db, args[1:])
+	case "reset-2fa":
+		if len(args) != 2 {
+			fatal(l.T("cli.usage_reset_2fa"))
+		}
+		user, err := s.store.UserByUsername(args[1])
+		if err != nil {
+			fatal("reset-2fa failed", "err", err)
+		}
+		if err := s.store.ResetTwoFA(user.ID); err != nil {
+			fatal("reset-2fa failed", "err", err)
+		}
+		log.Print(l.T("cli.reset_2fa_done", user.Username))
+	case "set-role":
+		if len(args) != 3 {
+			fatal(l.T("cli.usage_set_role"))
+		}
+		if _, ok := roleRank[args[2]]; !ok {
+			fatal(l.T("cli.unknown_role", args[2]))
+		}
+		user, err := s.store.UserByUsername(args[1])
+		if err == nil {
+			err = s.store.UpdateUser(user.ID, map[string]any{"role": args[2]})
+		}
+		if err != nil {
+			fatal("set-role failed", "user", args[1], "err", err)
+		}
+		log.Print(l.T("cli.role_set", args[1], args[2]))
+	case "import":
+		s.importCommand(args[1:])
+	case "webhook-receiver":
+		webhookReceiverCommand(args[1:])
+	case "bot-command":
+		s.botCommandCLI(args[1:])
+	case "bench-queries":
+		n := 1000000
+		if len(args) > 1 {
+			var err error
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				fatal(l.T("cli.bad_run_count", args[0], args[1]))
+			}
+		}
+		benchQueries(n)
+	case "explain":
+		bad, err := checkQueryPlans(db, os.Stdout)
+		if err != nil {
+			fatal("explain failed", "err", err)
+		}
+		if len(bad) > 0 {
+			fatal(l.T("cli.full_scans", strings.Join(bad, ", ")))
+		}
+	default:
+		fatal(l.T("cli.unknown_command", args[0]))
+	}
+}
+
 // ==================== AUTH ====================
-func loginPage(c *gin.Context) { c.HTML(http.StatusOK, "layout", gin.H{"Title": "Logowanie", "Content": loginHTML}) }
-func registerPage(c *gin.Context) { c.HTML(http.StatusOK, "layout", gin.H{"Title": "Rejestracja", "Content": registerHTML}) }
+type loginView struct {
+	page
+	Providers []*oidcProvider
+	Error     string
+}
+
+type registerView struct {
+	page
+	Error string
+}
+
+func renderLogin(c *gin.Context, status int, msg string) {
+	c.HTML(status, "login", loginView{page: newPage(c, "title.login"), Providers: sortedOIDCProviders(), Error: msg})
+}
+
+func loginPage(c *gin.Context) { renderLogin(c, http.StatusOK, "") }
 
-func loginHandler(c *gin.Context) {
+func registrationClosed(c *gin.Context) {
+	c.HTML(http.StatusForbidden, "message", messageView{page: newPage(c, "title.register"), Text: loc(c).T("register.closed")})
+}
+
+func registerPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "register", registerView{page: newPage(c, "title.register")})
+}
+
+func (s *server) loginHandler(c *gin.Context) {
 	username := c.PostForm("username")
 	password := c.PostForm("password")
-	var user User
-	if err := db.Where("username = ?", username).First(&user).Error; err != nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
-		c.HTML(http.StatusUnauthorized, "layout", gin.H{"Title": "Logowanie", "Content": loginHTML + `<p class="text-red-500 text-center mt-6">❌ Błędne dane</p>`})
+	user, err := s.reqStore(c).UserByUsername(username)
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		renderLogin(c, http.StatusUnauthorized, loc(c).T("login.bad_credentials"))
+		return
+	}
+	if user.Banned {
+		renderLogin(c, http.StatusForbidden, loc(c).T("login.banned"))
 		return
 	}
 	session := sessions.Default(c)
-	session.Set("user_id", user.ID)
-	session.Save()
+	if user.TOTPEnabled {
+		s.beginTwoFA(c, user)
+		return
+	}
+	signIn(session, user)
 	c.Redirect(http.StatusFound, "/dashboard")
 }
 
-func registerHandler(c *gin.Context) {
+func (s *server) registerHandler(c *gin.Context) {
 	username := c.PostForm("username")
 	password := c.PostForm("password")
 	if username == "" || password == "" {
-		c.HTML(http.StatusBadRequest, "layout", gin.H{"Title": "Rejestracja", "Content": registerHTML + `<p class="text-red-500">Wypełnij pola</p>`})
+		c.HTML(http.StatusBadRequest, "register", registerView{page: newPage(c, "title.register"), Error: loc(c).T("register.missing")})
 		return
 	}
 	hashed, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	db.Create(&User{Username: username, Password: string(hashed)})
+	s.reqStore(c).CreateUser(&User{Username: username, Password: string(hashed)})
 	c.Redirect(http.StatusFound, "/login")
 }
 
@@ -205,235 +458,175 @@ func logoutHandler(c *gin.Context) {
 }
 
 // ==================== DASHBOARD ====================
-func dashboardHandler(c *gin.Context) {
+type dashboardView struct {
+	page
+	Totals       UserTotals
+	AvgHR        float64
+	Efficiency   float64
+	Areas        []option
+	Difficulties []option
+	Runes        []runeButton
+	JS           map[string]string // js.* messages for app.js, without the prefix
+}
+
+type runeButton struct {
+	Name, Icon string
+}
+
+func (s *server) dashboardHandler(c *gin.Context) {
 	userID := sessions.Default(c).Get("user_id").(uint)
-	var totalRuns, totalHR, totalUniques, totalSets int64
-	db.Model(&Run{}).Where("user_id = ?", userID).Count(&totalRuns)
-	db.Model(&Run{}).Where("user_id = ?", userID).Select("COALESCE(SUM(hr_count),0)").Scan(&totalHR)
-	db.Model(&Run{}).Where("user_id = ?", userID).Select("COALESCE(SUM(uniques),0)").Scan(&totalUniques)
-	db.Model(&Run{}).Where("user_id = ?", userID).Select("COALESCE(SUM(sets),0)").Scan(&totalSets)
-
-	avgHR := 0.0
-	if totalRuns > 0 {
-		avgHR = float64(totalHR) / float64(totalRuns)
-	}
-
-	content := fmt.Sprintf(`
-		<div class="flex flex-col items-center">
-			<div class="d2-panel w-full max-w-6xl mx-auto">
-				<div class="grid grid-cols-2 md:grid-cols-4 gap-8 text-center">
-					<div><div class="text-7xl font-black text-amber-400">%d</div><div class="text-xl tracking-widest">RUNÓW</div></div>
-					<div><div class="text-7xl font-black text-emerald-400">%d</div><div class="text-xl tracking-widest">HIGH RUNES</div></div>
-					<div><div class="text-7xl font-black text-amber-400">%.2f</div><div class="text-xl tracking-widest">HR / RUN</div></div>
-					<div><button onclick="startSession()" class="d2-btn px-10 py-6 text-2xl font-black tracking-widest">ROZPOCZNIJ SESJĘ</button><div id="timer" class="mt-6 text-5xl font-mono text-amber-300">00:00:00</div></div>
-				</div>
-			</div>
-
-			<button onclick="showLogModal()" class="mt-12 d2-btn-big text-4xl px-24 py-10 font-black">📜 ZALOGUJ NOWĄ RUNDĘ</button>
-
-			<div class="mt-16 w-full max-w-6xl grid grid-cols-3 gap-8">
-				<div class="d2-panel text-center"><div class="text-6xl font-black">%d</div><div class="text-amber-300">UNIKATÓW</div></div>
-				<div class="d2-panel text-center"><div class="text-6xl font-black">%d</div><div class="text-amber-300">ZESTAWÓW</div></div>
-				<div class="d2-panel text-center"><div class="text-6xl font-black text-emerald-400">%.1f%%</div><div class="text-amber-300">EFFICIENCY</div></div>
-			</div>
-		</div>
-
-		<div id="logModal" class="hidden fixed inset-0 bg-black/95 flex items-center justify-center z-50">
-			<div class="d2-panel w-full max-w-4xl mx-4">
-				<div class="flex justify-between border-b border-amber-400 pb-4">
-					<h3 class="text-4xl font-black text-amber-400">LOG RUN</h3>
-					<button onclick="hideLogModal()" class="text-5xl text-red-400">✕</button>
-				</div>
-				<form id="runForm" onsubmit="submitRun(event)" class="mt-8 space-y-8">
-					<div class="grid grid-cols-2 gap-6">
-						<select name="area" class="d2-input">%s</select>
-						<select name="difficulty" class="d2-input">%s</select>
-					</div>
-					<div class="grid grid-cols-2 gap-6">
-						<div><label class="block text-amber-300">Unikatów</label><input type="number" name="uniques" value="0" class="d2-input w-full"></div>
-						<div><label class="block text-amber-300">Zestawów</label><input type="number" name="sets" value="0" class="d2-input w-full"></div>
-					</div>
-					<div>
-						<label class="block text-amber-300 mb-3">Runy (klikaj na siatce poniżej)</label>
-						<div id="selectedRunes" class="flex flex-wrap gap-3 min-h-[70px]"></div>
-					</div>
-					<button type="submit" class="d2-btn-big w-full py-8 text-3xl">✅ ZAPISZ RUNDĘ</button>
-				</form>
-			</div>
-		</div>
-
-		<div class="mt-16 w-full max-w-6xl">
-			<h2 class="text-4xl font-black text-center mb-8 text-amber-400">SIATKA RUN</h2>
-			<div class="rune-grid">%s</div>
-		</div>
-	`, generateAreaOptions(), generateDiffOptions(), generateRuneGridHTML())
-
-	c.HTML(http.StatusOK, "layout", gin.H{"Title": "Dashboard – D2R Farm Tracker", "Content": template.HTML(content)})
+	t, _ := s.reqStore(c).UserTotals(userID)
+	avgHR, efficiency := t.rates()
+
+	l := loc(c)
+	c.HTML(http.StatusOK, "dashboard", dashboardView{
+		page:         newPage(c, "title.dashboard"),
+		Totals:       t,
+		AvgHR:        avgHR,
+		Efficiency:   efficiency,
+		Areas:        selectOptions(areas, "", l.Area),
+		Difficulties: selectOptions(difficulties, "Hell", l.Difficulty),
+		Runes:        runeButtons(),
+		JS:           l.prefixed("js."),
+	})
 }
 
-func generateAreaOptions() string { var s strings.Builder; for _, a := range areas { s.WriteString(fmt.Sprintf(`<option value="%s">%s</option>`, a, a)) }; return s.String() }
-func generateDiffOptions() string { var s strings.Builder; for _, d := range difficulties { sel := ""; if d == "Hell" { sel = ` selected` }; s.WriteString(fmt.Sprintf(`<option value="%s"%s>%s</option>`, d, sel, d)) }; return s.String() }
-func generateRuneGridHTML() string {
-	var sb strings.Builder
-	for _, r := range runeOrder {
-		sb.WriteString(fmt.Sprintf(`<button onclick="addRuneToCurrent('%s')" class="rune-btn"><img src="%s" class="w-14 h-14"><div class="text-xs mt-1">%s</div></button>`, r, runeIcons[r], r))
+// rates are the derived dashboard figures: high runes per run and the
+// percentage of runs that dropped at least one.
+func (t UserTotals) rates() (avgHR, efficiency float64) {
+	if t.Runs == 0 {
+		return 0, 0
 	}
-	return sb.String()
+	return float64(t.HR) / float64(t.Runs), 100 * float64(t.HRRuns) / float64(t.Runs)
+}
+
+// selectOptions lists values as options with selected preselected; label
+// gives each value its display text.
+func selectOptions(values []string, selected string, label func(string) string) []option {
+	opts := make([]option, len(values))
+	for i, v := range values {
+		opts[i] = option{Value: v, Label: label(v), Selected: v == selected}
+	}
+	return opts
+}
+
+func runeButtons() []runeButton {
+	buttons := make([]runeButton, len(runeOrder))
+	for i, r := range runeOrder {
+		buttons[i] = runeButton{Name: r, Icon: runeIcons[r]}
+	}
+	return buttons
 }
 
 // ==================== LOG RUN ====================
-func logRunHandler(c *gin.Context) {
+func (s *server) logRunHandler(c *gin.Context) {
 	userID := sessions.Default(c).Get("user_id").(uint)
 	area := c.PostForm("area")
 	diff := c.PostForm("difficulty")
 	uniques, _ := strconv.Atoi(c.PostForm("uniques"))
 	sets, _ := strconv.Atoi(c.PostForm("sets"))
 
-	var drops []struct{ Rune string `json:"rune"`; Qty int `json:"qty"` }
+	var drops []struct {
+		Rune string `json:"rune"`
+		Qty  int    `json:"qty"`
+	}
 	if j := c.PostForm("runes"); j != "" {
-		json.Unmarshal([]byte(j), &drops)
+		if err := json.Unmarshal([]byte(j), &drops); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "invalid"})
+			return
+		}
+	}
+	// Area and difficulty become metric labels and leaderboard filters, so
+	// only the listed ones are taken.
+	if uniques < 0 || sets < 0 || !slices.Contains(areas, area) || !slices.Contains(difficulties, diff) {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "invalid"})
+		return
 	}
 
-	hr := 0
+	// The same limits as an import or a chat command: known runes only, and
+	// no quantity the metrics or the leaderboard could be fooled with.
+	stored := make([]RuneDrop, 0, len(drops))
 	for _, d := range drops {
-		if highRunes[d.Rune] {
-			hr += d.Qty
+		if _, known := runeIndex[d.Rune]; !known || d.Qty < 1 || d.Qty > importMaxQty {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "invalid", "rune": d.Rune})
+			return
 		}
+		stored = append(stored, RuneDrop{Rune: d.Rune, Qty: d.Qty})
 	}
-
-	run := Run{UserID: userID, Area: area, Difficulty: diff, Uniques: uniques, Sets: sets, HRCount: hr, Timestamp: time.Now()}
-	db.Create(&run)
-	for _, d := range drops {
-		db.Create(&RuneDrop{RunID: run.ID, Rune: d.Rune, Qty: d.Qty})
+	logged, err := s.logRun(c.Request.Context(), Run{UserID: userID, Area: area, Difficulty: diff, Uniques: uniques, Sets: sets}, stored)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error"})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "hr": logged.Run.HRCount, "flagged": logged.Run.Flagged, "totals": totalsJSON(logged.Totals)})
+}
 
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "hr": hr})
+// loggedRun is what logRun stored and the player's totals after it.
+type loggedRun struct {
+	Run    Run
+	Totals UserTotals
 }
 
-// ==================== LEADERBOARD ====================
-func leaderboardHandler(c *gin.Context) {
-	type L struct {
-		Username string
-		TotalHR  int
-		Runs     int
-		AvgHR    float64
+// logRun stores a freshly played run the way every entry point does: it
+// counts the high runes, runs the anti-cheat checks, fires webhooks and
+// pushes the new totals to open pages. The chat bot shares it with the
+// dashboard form.
+func (s *server) logRun(ctx context.Context, run Run, drops []RuneDrop) (loggedRun, error) {
+	store := s.store.WithContext(ctx)
+	run.HRCount = 0
+	for _, d := range drops {
+		if highRunes[d.Rune] {
+			run.HRCount += d.Qty
+		}
 	}
-	var leaders []L
-	db.Raw(`SELECT u.username, SUM(r.hr_count) AS total_hr, COUNT(*) AS runs, AVG(r.hr_count) AS avg_hr 
-			FROM runs r JOIN users u ON r.user_id = u.id 
-			GROUP BY u.id ORDER BY total_hr DESC LIMIT 15`).Scan(&leaders)
+	run.Timestamp = time.Now()
 
-	var rows strings.Builder
-	for i, l := range leaders {
-		rows.WriteString(fmt.Sprintf(`<tr class="border-b border-amber-900"><td class="py-4 px-6 font-black">#%d</td><td>%s</td><td class="text-emerald-400">%d HR</td><td>%d runów</td><td>%.2f HR/run</td></tr>`, i+1, l.Username, l.TotalHR, l.Runs, l.AvgHR))
+	ranks := s.rankSnapshot(ctx)
+	if err := store.CreateRun(&run, drops); err != nil {
+		return loggedRun{}, err
 	}
-
-	content := fmt.Sprintf(`<div class="d2-panel"><h2 class="text-4xl font-black mb-8 text-center">🏆 LEADERBOARD HR</h2><table class="w-full">%s</table></div>`, rows.String())
-	c.HTML(http.StatusOK, "layout", gin.H{"Title": "Leaderboard", "Content": template.HTML(content)})
+	s.metrics.runLogged(run, drops)
+	var flags []RunFlag
+	if s.cfg.Features.AntiCheat {
+		flags = s.inspectRun(ctx, run, drops)
+	}
+	run.Flagged = len(flags) > 0
+	s.runWebhooks(ctx, run, drops, ranks)
+
+	// The dashboard logs runs without reloading and repaints its counters
+	// from these totals; other open pages get them over /events.
+	t, _ := store.UserTotals(run.UserID)
+	s.runsChanged(run.UserID, t)
+	return loggedRun{Run: run, Totals: t}, nil
 }
 
 // ==================== MY STATS ====================
-func myStatsHandler(c *gin.Context) {
-	content := `<div class="d2-panel"><canvas id="chart" class="w-full h-96"></canvas></div>
-	<script src="https://cdn.jsdelivr.net/npm/chart.js"></script>
-	<script>
-		new Chart(document.getElementById("chart"), {type:"line", data:{labels:["Pon","Wt","Śr","Czw","Pt","Sob","Niedz"], datasets:[{label:"HR", data:[4,11,7,18,25,32,14], borderColor:"#c9a14d", tension:0.4}]}, options:{scales:{y:{grid:{color:"#4a2c0f"}}}}});
-	</script>`
-	c.HTML(http.StatusOK, "layout", gin.H{"Title": "Moje statystyki", "Content": template.HTML(content)})
+const statsDays = 30
+
+// statsView feeds the chart script; html/template encodes both series as
+// JSON inside the <script> block.
+type statsView struct {
+	page
+	Labels []string
+	HR     []int
 }
 
-// ==================== TEMPLATES ====================
-var d2rTemplate = `
-<!DOCTYPE html>
-<html lang="pl">
-<head>
-	<meta charset="UTF-8">
-	<title>{{.Title}}</title>
-	<script src="https://cdn.tailwindcss.com"></script>
-	<style>
-		body { background: radial-gradient(#1c1208, #0a0503); color: #e8d5a3; font-family: system-ui; }
-		.d2-panel { background: linear-gradient(#2c1f14,#1a1109); border: 8px solid #d4af37; box-shadow: 0 0 40px #8b0000; padding: 2rem; border-radius: 4px; }
-		.d2-btn { background: linear-gradient(#8b5a2b,#5c3a1a); border: 4px solid #d4af37; color: #ffd700; padding: 12px 24px; font-weight: 900; }
-		.d2-btn-big { background: linear-gradient(#b71c1c,#7f0000); border: 6px solid #ffd700; color: #fff; padding: 20px 40px; font-size: 1.5rem; font-weight: 900; }
-		.rune-grid { display: grid; grid-template-columns: repeat(auto-fit, minmax(90px,1fr)); gap: 14px; }
-		.rune-btn { background:#1c1208; border:4px solid #4a2c0f; transition:all .2s; padding: 8px; text-align: center; }
-		.rune-btn:hover { border-color:#ffd700; transform:scale(1.12); }
-	</style>
-</head>
-<body class="min-h-screen">
-	<div class="max-w-screen-2xl mx-auto p-8">
-		<header class="flex justify-between items-center mb-12 border-b border-amber-400 pb-6">
-			<div class="flex items-center gap-6">
-				<div class="text-7xl font-black text-[#c9a14d] tracking-widest">DIABLO</div>
-				<div class="text-6xl text-red-600 font-black">II</div>
-			</div>
-			<div class="flex gap-10 text-2xl">
-				<a href="/dashboard" class="hover:text-amber-400">Dashboard</a>
-				<a href="/leaderboard" class="hover:text-amber-400">Leaderboard</a>
-				<a href="/my-stats" class="hover:text-amber-400">Moje staty</a>
-				<a href="/logout" class="text-red-500">Wyloguj</a>
-			</div>
-		</header>
-		{{.Content}}
-	</div>
-
-	<script>
-		let currentRunes = [];
-		function addRuneToCurrent(r) {
-			let qty = prompt("Ile sztuk "+r+"?", "1");
-			if(qty && parseInt(qty)>0) {
-				currentRunes.push({rune:r, qty:parseInt(qty)});
-				renderSelected();
-			}
-		}
-		function renderSelected() {
-			let html = currentRunes.map((item,i) => `<div onclick="removeRune(\( {i})" class="flex items-center gap-3 bg-zinc-900 border border-amber-400 px-5 py-3 rounded cursor-pointer hover:bg-red-900"> \){item.rune} × ${item.qty}</div>`).join('');
-			document.getElementById("selectedRunes").innerHTML = html || "Kliknij runy powyżej...";
-		}
-		function removeRune(i) { currentRunes.splice(i,1); renderSelected(); }
-		function showLogModal() { currentRunes = []; renderSelected(); document.getElementById("logModal").classList.remove("hidden"); }
-		function hideLogModal() { document.getElementById("logModal").classList.add("hidden"); }
-		function submitRun(e) {
-			e.preventDefault();
-			const form = new FormData(e.target);
-			form.append("runes", JSON.stringify(currentRunes));
-			fetch("/log-run", {method:"POST", body:form}).then(r=>r.json()).then(d=>{
-				alert("✅ Zapisano! HR: "+d.hr);
-				hideLogModal();
-				location.reload();
-			});
-		}
-		let seconds = 0, timer;
-		function startSession() {
-			clearInterval(timer);
-			seconds = 0;
-			timer = setInterval(()=>{ seconds++; let h=Math.floor(seconds/3600),m=Math.floor((seconds%3600)/60),s=seconds%60; document.getElementById("timer").textContent = h.toString().padStart(2,'0')+":"+m.toString().padStart(2,'0')+":"+s.toString().padStart(2,'0'); },1000);
-			alert("⏳ Sesja rozpoczęta!");
-		}
-	</script>
-</body>
-</html>
-`
-
-var loginHTML = `
-<div class="max-w-md mx-auto mt-32 d2-panel p-12">
-	<h1 class="text-5xl font-black text-center mb-12 text-amber-400">LOGOWANIE</h1>
-	<form method="POST" action="/login" class="space-y-8">
-		<input name="username" placeholder="Nazwa bohatera" required class="d2-input w-full p-5 text-xl">
-		<input name="password" type="password" placeholder="Hasło" required class="d2-input w-full p-5 text-xl">
-		<button type="submit" class="d2-btn-big w-full py-8 text-3xl">WEJDŹ DO SANKTUARIUM</button>
-	</form>
-	<p class="text-center mt-10"><a href="/register" class="text-amber-400 text-xl">Nowy bohater? Zarejestruj się</a></p>
-</div>
-`
-
-var registerHTML = `
-<div class="max-w-md mx-auto mt-32 d2-panel p-12">
-	<h1 class="text-5xl font-black text-center mb-12 text-amber-400">STWÓRZ BOHATERA</h1>
-	<form method="POST" action="/register" class="space-y-8">
-		<input name="username" placeholder="Nazwa bohatera" required class="d2-input w-full p-5 text-xl">
-		<input name="password" type="password" placeholder="Hasło" required class="d2-input w-full p-5 text-xl">
-		<button type="submit" class="d2-btn-big w-full py-8 text-3xl">STWÓRZ POSTAĆ</button>
-	</form>
-</div>
-`
+func (s *server) myStatsHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	since := time.Now().UTC().AddDate(0, 0, -(statsDays - 1))
+	days, _ := s.reqStore(c).DailyStats(userID, since)
+
+	// The rollup only has rows for days with runs; fill the gaps with zeros.
+	hrByDay := make(map[string]int, len(days))
+	for _, d := range days {
+		hrByDay[d.Day] = d.HR
+	}
+	l := loc(c)
+	labels := make([]string, statsDays)
+	hr := make([]int, statsDays)
+	for i := range labels {
+		t := since.AddDate(0, 0, i)
+		labels[i], hr[i] = l.Day(t), hrByDay[statDay(t)]
+	}
+	c.HTML(http.StatusOK, "my_stats", statsView{page: newPage(c, "title.stats"), Labels: labels, HR: hr})
+}
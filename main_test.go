@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRegisterLoginLogout(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		c := ts.user(t, "alice")
+		if res := c.get("/dashboard"); res.Status != http.StatusOK {
+			t.Fatalf("dashboard: %d", res.Status)
+		}
+
+		// A second registration of the name must not replace the first account.
+		other := ts.client(t)
+		other.post("/register", url.Values{"username": {"alice"}, "password": {"something else"}})
+		if res := other.post("/login", url.Values{"username": {"alice"}, "password": {"something else"}}); res.Status != http.StatusUnauthorized {
+			t.Errorf("login with the squatter's password: %d %s", res.Status, res.Location)
+		}
+		if res := other.post("/login", url.Values{"username": {"alice"}, "password": {"wrong"}}); res.Status != http.StatusUnauthorized {
+			t.Errorf("login with a wrong password: %d", res.Status)
+		}
+
+		c.get("/logout")
+		if res := c.get("/dashboard"); res.Location != "/login" {
+			t.Errorf("dashboard after logout: %d %s", res.Status, res.Location)
+		}
+	})
+}
+
+func TestLogRun(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		c := ts.user(t, "alice")
+		res := c.post("/log-run", url.Values{
+			"area":       {"Mephisto"},
+			"difficulty": {"Hell"},
+			"uniques":    {"2"},
+			"runes":      {`[{"rune":"Ber","qty":1},{"rune":"El","qty":2}]`},
+		})
+		if res.Status != http.StatusOK {
+			t.Fatalf("log-run: %d %s", res.Status, res.Body)
+		}
+		var body struct {
+			Status string
+			HR     int
+		}
+		if err := json.Unmarshal([]byte(res.Body), &body); err != nil || body.Status != "ok" || body.HR != 1 {
+			t.Fatalf("log-run body %s (%v)", res.Body, err)
+		}
+
+		user, _ := ts.store.UserByUsername("alice")
+		totals, err := ts.store.UserTotals(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if totals != (UserTotals{Runs: 1, HR: 1, HRRuns: 1, Uniques: 2}) {
+			t.Errorf("totals = %+v", totals)
+		}
+		rows, err := ts.store.Leaderboard(LeaderboardFilter{Area: "Mephisto", Difficulty: "Hell"}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 || rows[0].Username != "alice" || rows[0].TotalHR != 1 {
+			t.Errorf("leaderboard = %+v", rows)
+		}
+		if res := c.get("/leaderboard"); res.Status != http.StatusOK || !strings.Contains(res.Body, "alice") {
+			t.Errorf("leaderboard page: %d", res.Status)
+		}
+	})
+}
+
+// The reply carries what app.js paints after a save: the run's HR, whether
+// it went to review, and the new totals.
+func TestLogRunReply(t *testing.T) {
+	ts := newTestServer(t, newMemStore())
+	c := ts.user(t, "alice")
+	type reply struct {
+		Status  string              `json:"status"`
+		HR      *int                `json:"hr"`
+		Flagged *bool               `json:"flagged"`
+		Totals  map[string]*float64 `json:"totals"`
+	}
+	post := func(runes string) reply {
+		t.Helper()
+		res := c.post("/log-run", url.Values{"area": {"Mephisto"}, "difficulty": {"Hell"}, "uniques": {"2"}, "sets": {"1"}, "runes": {runes}})
+		var r reply
+		if err := json.Unmarshal([]byte(res.Body), &r); err != nil || res.Status != http.StatusOK || r.Status != "ok" || r.HR == nil || r.Flagged == nil {
+			t.Fatalf("log-run: %d %s (%v)", res.Status, res.Body, err)
+		}
+		for _, stat := range []string{"runs", "hr", "uniques", "sets", "avg_hr", "efficiency"} {
+			if r.Totals[stat] == nil {
+				t.Fatalf("totals.%s missing: %s", stat, res.Body)
+			}
+		}
+		return r
+	}
+
+	r := post(`[{"rune":"Ber","qty":1},{"rune":"El","qty":2}]`)
+	if *r.HR != 1 || *r.Flagged {
+		t.Errorf("first run: hr=%d flagged=%v", *r.HR, *r.Flagged)
+	}
+	if tot := r.Totals; *tot["runs"] != 1 || *tot["hr"] != 1 || *tot["uniques"] != 2 || *tot["sets"] != 1 || *tot["avg_hr"] != 1 {
+		t.Errorf("first run: totals %v", r.Totals)
+	}
+	// Over the HR-per-run limit: saved, but for review.
+	if r := post(`[{"rune":"Ber","qty":4}]`); *r.HR != 4 || !*r.Flagged {
+		t.Errorf("flagged run: hr=%d flagged=%v", *r.HR, *r.Flagged)
+	}
+}
+
+func TestLogRunRejectsBadDrops(t *testing.T) {
+	ts := newTestServer(t, newMemStore())
+	c := ts.user(t, "alice")
+	for _, runes := range []string{
+		`[{"rune":"Ber","qty":-1}]`,
+		`[{"rune":"Ber","qty":0}]`,
+		`[{"rune":"Ber","qty":100}]`,
+		`[{"rune":"Bear","qty":1}]`,
+		`[{"rune":"Ber"`,
+	} {
+		res := c.post("/log-run", url.Values{"area": {"Mephisto"}, "difficulty": {"Hell"}, "runes": {runes}})
+		if res.Status != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", runes, res.Status, res.Body)
+		}
+	}
+	if res := c.post("/log-run", url.Values{"area": {"Mephisto"}, "difficulty": {"Hell"}, "uniques": {"-3"}}); res.Status != http.StatusBadRequest {
+		t.Errorf("negative uniques: %d", res.Status)
+	}
+	for _, form := range []url.Values{
+		{"area": {"Mephisto\x00"}, "difficulty": {"Hell"}},
+		{"area": {"mephisto"}, "difficulty": {"Hell"}},
+		{"difficulty": {"Hell"}},
+		{"area": {"Mephisto"}, "difficulty": {"Inferno"}},
+	} {
+		if res := c.post("/log-run", form); res.Status != http.StatusBadRequest {
+			t.Errorf("%v: %d", form, res.Status)
+		}
+	}
+	user, _ := ts.store.UserByUsername("alice")
+	if runs, _ := ts.store.RunsByUser(user.ID); len(runs) != 0 {
+		t.Errorf("stored %d invalid runs", len(runs))
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// farmingWindow is how recently a player must have logged a run to count as
+// farming right now.
+const farmingWindow = 15 * time.Minute
+
+// metrics owns the Prometheus registry behind /metrics: HTTP and database
+// timings for service health, plus farming activity for the community board.
+type metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests      *prometheus.CounterVec
+	httpDuration      *prometheus.HistogramVec
+	dbDuration        *prometheus.HistogramVec
+	runsLogged        *prometheus.CounterVec
+	highRunes         *prometheus.CounterVec
+	runsImported      prometheus.Counter
+	webhookDeliveries *prometheus.CounterVec
+
+	mu       sync.Mutex
+	lastRuns map[uint]time.Time // user → last logged run, for the farming gauge
+}
+
+func newMetrics(store Store, live *hub) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "d2r_http_requests_total",
+			Help: "HTTP requests by route and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "d2r_http_request_duration_seconds",
+			Help:    "HTTP request latency by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "d2r_db_query_duration_seconds",
+			Help:    "Database statement latency by operation and table.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation", "table"}),
+		runsLogged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "d2r_runs_logged_total",
+			Help: "Runs logged by area and difficulty.",
+		}, []string{"area", "difficulty"}),
+		highRunes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "d2r_high_runes_dropped_total",
+			Help: "High runes reported in logged runs, by rune.",
+		}, []string{"rune"}),
+		runsImported: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "d2r_runs_imported_total",
+			Help: "Historical runs added by imports; not counted as logged.",
+		}),
+		webhookDeliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "d2r_webhook_deliveries_total",
+			Help: "Webhook delivery attempts by event and result (delivered, retry, failed).",
+		}, []string{"event", "result"}),
+		lastRuns: map[uint]time.Time{},
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration, m.dbDuration, m.runsLogged, m.highRunes, m.runsImported, m.webhookDeliveries,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "d2r_active_farming_sessions",
+			Help: "Players who logged a run in the last 15 minutes on this instance.",
+		}, m.activeFarmers),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "d2r_registered_users",
+			Help: "Registered user accounts.",
+		}, func() float64 {
+			n, _ := store.CountUsers()
+			return float64(n)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "d2r_live_subscribers",
+			Help: "Open /events streams on this instance.",
+		}, func() float64 { return float64(live.count()) }),
+	)
+	return m
+}
+
+func (m *metrics) handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// middleware records every request under its route pattern, never the raw
+// path, so /admin/users/:id stays one series.
+func (m *metrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// runLogged counts a stored run and its high rune drops.
+func (m *metrics) runLogged(run Run, drops []RuneDrop) {
+	m.runsLogged.WithLabelValues(run.Area, run.Difficulty).Inc()
+	for _, d := range drops {
+		if highRunes[d.Rune] {
+			m.highRunes.WithLabelValues(d.Rune).Add(float64(d.Qty))
+		}
+	}
+	m.mu.Lock()
+	m.lastRuns[run.UserID] = time.Now()
+	m.mu.Unlock()
+}
+
+func (m *metrics) activeFarmers() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cutoff := time.Now().Add(-farmingWindow)
+	for id, at := range m.lastRuns {
+		if at.Before(cutoff) {
+			delete(m.lastRuns, id)
+		}
+	}
+	return float64(len(m.lastRuns))
+}
+
+// instrumentDB times every GORM statement through callbacks around the
+// built-in create/query/update/delete/row/raw processors.
+func (m *metrics) instrumentDB(db *gorm.DB) error {
+	const startKey = "metrics:start"
+	before := func(tx *gorm.DB) { tx.InstanceSet(startKey, time.Now()) }
+	after := func(op string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			start, ok := tx.InstanceGet(startKey)
+			if !ok {
+				return
+			}
+			table := tx.Statement.Table
+			if table == "" {
+				table = "raw"
+			}
+			m.dbDuration.WithLabelValues(op, table).Observe(time.Since(start.(time.Time)).Seconds())
+		}
+	}
+
+	cb := db.Callback()
+	errs := []error{
+		cb.Create().Before("gorm:create").Register("metrics:before_create", before),
+		cb.Create().After("gorm:create").Register("metrics:after_create", after("create")),
+		cb.Query().Before("gorm:query").Register("metrics:before_query", before),
+		cb.Query().After("gorm:query").Register("metrics:after_query", after("query")),
+		cb.Update().Before("gorm:update").Register("metrics:before_update", before),
+		cb.Update().After("gorm:update").Register("metrics:after_update", after("update")),
+		cb.Delete().Before("gorm:delete").Register("metrics:before_delete", before),
+		cb.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete")),
+		cb.Row().Before("gorm:row").Register("metrics:before_row", before),
+		cb.Row().After("gorm:row").Register("metrics:after_row", after("row")),
+		cb.Raw().Before("gorm:raw").Register("metrics:before_raw", before),
+		cb.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")),
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations live in migrations/ as NNNN_name.{up,down}.{sqlite,postgres}.sql.
+// Every version needs all four files so both drivers stay in lockstep.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+)\.(up|down)\.(sqlite|postgres)\.sql$`)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+// loadMigrations returns the embedded migrations for dialect ("sqlite" or
+// "postgres"), ordered by version.
+func loadMigrations(dialect string) ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migrations/%s: unexpected file name", e.Name())
+		}
+		if m[4] != dialect {
+			continue
+		}
+		body, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		v, _ := strconv.Atoi(m[1])
+		mig := byVersion[v]
+		if mig == nil {
+			mig = &migration{Version: v, Name: m[2]}
+			byVersion[v] = mig
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	list := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s: missing up or down script for %s", m.Version, m.Name, dialect)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]schemaMigration, error) {
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`).Error; err != nil {
+		return nil, err
+	}
+	var rows []schemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]schemaMigration, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// checkSchema refuses to continue when the database carries a migration this
+// binary does not know about, which means a newer release already ran
+// against it (or someone rolled back the code without rolling back the DB).
+func checkSchema(known []migration, applied map[int]schemaMigration) error {
+	have := make(map[int]bool, len(known))
+	for _, m := range known {
+		have[m.Version] = true
+	}
+	for v, a := range applied {
+		if !have[v] {
+			return errors.New(newLocalizer(defaultLocale).T("cli.unknown_schema", v, a.Name))
+		}
+	}
+	return nil
+}
+
+// migrateUp applies every pending migration, each in its own transaction.
+func migrateUp(db *gorm.DB) (int, error) {
+	known, err := loadMigrations(db.Dialector.Name())
+	if err != nil {
+		return 0, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkSchema(known, applied); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, m := range known {
+		if _, done := applied[m.Version]; done {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if m.Version == 1 {
+				if err := adoptLegacySchema(tx); err != nil {
+					return err
+				}
+			}
+			if err := tx.Exec(m.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return n, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		slog.Info("migration applied", "version", m.Version, "name", m.Name)
+		n++
+	}
+	return n, nil
+}
+
+// legacyColumns are the columns 0001 expects that AutoMigrate only added as
+// the models grew. A database from an older release can lack any of them, and
+// rows older than a column hold NULL in it, which gorm cannot scan into a bool,
+// string or int.
+var legacyColumns = []struct {
+	Table, Column    string
+	SQLite, Postgres string // column definition per dialect
+	Fill             any    // value for rows that predate the column
+}{
+	{"users", "role", "TEXT DEFAULT 'user'", "TEXT DEFAULT 'user'", "user"},
+	{"users", "banned", "NUMERIC DEFAULT 0", "BOOLEAN DEFAULT false", false},
+	{"users", "totp_secret", "TEXT DEFAULT ''", "TEXT DEFAULT ''", ""},
+	{"users", "totp_enabled", "NUMERIC DEFAULT 0", "BOOLEAN DEFAULT false", false},
+	{"users", "session_epoch", "INTEGER DEFAULT 0", "BIGINT DEFAULT 0", 0},
+	{"runs", "hidden", "NUMERIC DEFAULT 0", "BOOLEAN DEFAULT false", false},
+	{"runs", "flagged", "NUMERIC DEFAULT 0", "BOOLEAN DEFAULT false", false},
+}
+
+// adoptLegacySchema brings tables created by AutoMigrate up to the shape of
+// 0001 before it is recorded as applied, since its CREATE TABLE IF NOT EXISTS
+// leaves existing tables alone. On a fresh database there is nothing to do.
+func adoptLegacySchema(tx *gorm.DB) error {
+	mig := tx.Migrator()
+	for _, c := range legacyColumns {
+		if !mig.HasTable(c.Table) {
+			continue
+		}
+		if !mig.HasColumn(c.Table, c.Column) {
+			def := c.SQLite
+			if tx.Dialector.Name() == "postgres" {
+				def = c.Postgres
+			}
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.Table, c.Column, def)).Error; err != nil {
+				return fmt.Errorf("adopting %s.%s: %w", c.Table, c.Column, err)
+			}
+			slog.Info("legacy schema: column added", "table", c.Table, "column", c.Column)
+		}
+		res := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s IS NULL", c.Table, c.Column, c.Column), c.Fill)
+		if res.Error != nil {
+			return fmt.Errorf("adopting %s.%s: %w", c.Table, c.Column, res.Error)
+		}
+		if res.RowsAffected > 0 {
+			slog.Info("legacy schema: NULLs backfilled", "table", c.Table, "column", c.Column, "rows", res.RowsAffected)
+		}
+	}
+	return nil
+}
+
+// migrateDown rolls back the last steps applied migrations.
+func migrateDown(db *gorm.DB, steps int) error {
+	known, err := loadMigrations(db.Dialector.Name())
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	if err := checkSchema(known, applied); err != nil {
+		return err
+	}
+
+	for i := len(known) - 1; i >= 0 && steps > 0; i-- {
+		m := known[i]
+		if _, done := applied[m.Version]; !done {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(m.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rollback %04d_%s: %w", m.Version, m.Name, err)
+		}
+		slog.Info("migration rolled back", "version", m.Version, "name", m.Name)
+		steps--
+	}
+	return nil
+}
+
+func migrationStatus(db *gorm.DB) error {
+	known, err := loadMigrations(db.Dialector.Name())
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	l := newLocalizer(defaultLocale)
+	for _, m := range known {
+		state := l.T("cli.migration_pending")
+		if a, ok := applied[m.Version]; ok {
+			state = l.T("cli.migration_applied", a.AppliedAt.Format(time.RFC3339))
+		}
+		fmt.Printf("%04d_%-30s %s\n", m.Version, m.Name, state)
+	}
+	return checkSchema(known, applied)
+}
+
+func runMigrateCommand(db *gorm.DB, args []string) {
+	l := newLocalizer(defaultLocale)
+	sub := "up"
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	switch sub {
+	case "up":
+		n, err := migrateUp(db)
+		if err != nil {
+			fatal("migrate failed", "err", err)
+		}
+		log.Print(l.T("cli.migrations_applied", n))
+	case "rollback", "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fatal(l.T("cli.bad_steps", args[1]))
+			}
+		}
+		if err := migrateDown(db, steps); err != nil {
+			fatal("migrate failed", "err", err)
+		}
+	case "status":
+		if err := migrationStatus(db); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		fatal(l.T("cli.usage_migrate"))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestMigrateAdoptsLegacySchema starts from the tables the first release
+// created with AutoMigrate, before roles, 2FA or moderation existed.
+func TestMigrateAdoptsLegacySchema(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/legacy.db"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, username TEXT, password TEXT)`,
+		`CREATE UNIQUE INDEX idx_users_username ON users (username)`,
+		`CREATE TABLE runs (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER, area TEXT, difficulty TEXT,
+			uniques INTEGER, sets INTEGER, hr_count INTEGER, session_sec INTEGER, timestamp DATETIME)`,
+		`CREATE TABLE rune_drops (id INTEGER PRIMARY KEY AUTOINCREMENT, run_id INTEGER, rune TEXT, qty INTEGER)`,
+		`INSERT INTO users (username, password) VALUES ('alice', 'hash')`,
+		`INSERT INTO runs (user_id, area, difficulty, hr_count, timestamp) VALUES (1, 'Mephisto', 'Hell', 1, CURRENT_TIMESTAMP)`,
+	} {
+		if err := db.Exec(q).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	store := newGormStore(db)
+	user, err := store.UserByUsername("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != "user" || user.Banned || user.TOTPEnabled || user.SessionEpoch != 0 {
+		t.Errorf("adopted user = %+v", user)
+	}
+	runs, err := store.RunsByUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Hidden || runs[0].Flagged {
+		t.Errorf("adopted runs = %+v", runs)
+	}
+
+	// A second start finds version 1 applied and leaves the data alone.
+	if n, err := migrateUp(db); err != nil || n != 0 {
+		t.Errorf("second migrateUp = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS run_flags;
+DROP TABLE IF EXISTS oidc_identities;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS rune_drops;
+DROP TABLE IF EXISTS runs;
+DROP TABLE IF EXISTS users;
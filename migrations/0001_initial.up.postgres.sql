@@ -0,0 +1,64 @@
+-- Schema as previously produced by AutoMigrate. IF NOT EXISTS lets databases
+-- created before versioned migrations adopt version 1 without changes.
+CREATE TABLE IF NOT EXISTS users (
+	id            BIGSERIAL PRIMARY KEY,
+	username      TEXT,
+	password      TEXT,
+	role          TEXT DEFAULT 'user',
+	banned        BOOLEAN,
+	totp_secret   TEXT,
+	totp_enabled  BOOLEAN,
+	session_epoch BIGINT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+
+CREATE TABLE IF NOT EXISTS runs (
+	id          BIGSERIAL PRIMARY KEY,
+	user_id     BIGINT,
+	area        TEXT,
+	difficulty  TEXT,
+	uniques     BIGINT,
+	sets        BIGINT,
+	hr_count    BIGINT,
+	session_sec BIGINT,
+	timestamp   TIMESTAMPTZ,
+	hidden      BOOLEAN,
+	flagged     BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS rune_drops (
+	id     BIGSERIAL PRIMARY KEY,
+	run_id BIGINT,
+	rune   TEXT,
+	qty    BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	id      BIGSERIAL PRIMARY KEY,
+	user_id BIGINT,
+	hash    TEXT,
+	used_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS oidc_identities (
+	id         BIGSERIAL PRIMARY KEY,
+	user_id    BIGINT,
+	provider   TEXT,
+	subject    TEXT,
+	email      TEXT,
+	created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_oidc_identities_user_id ON oidc_identities (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_oidc_provider_subject ON oidc_identities (provider, subject);
+
+CREATE TABLE IF NOT EXISTS run_flags (
+	id          BIGSERIAL PRIMARY KEY,
+	run_id      BIGINT,
+	rule        TEXT,
+	detail      TEXT,
+	created_at  TIMESTAMPTZ,
+	reviewed_by BIGINT,
+	resolution  TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_run_flags_run_id ON run_flags (run_id);
@@ -0,0 +1,64 @@
+-- Schema as previously produced by AutoMigrate. IF NOT EXISTS lets databases
+-- created before versioned migrations adopt version 1 without changes.
+CREATE TABLE IF NOT EXISTS users (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	username      TEXT,
+	password      TEXT,
+	role          TEXT DEFAULT 'user',
+	banned        NUMERIC,
+	totp_secret   TEXT,
+	totp_enabled  NUMERIC,
+	session_epoch INTEGER
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+
+CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id     INTEGER,
+	area        TEXT,
+	difficulty  TEXT,
+	uniques     INTEGER,
+	sets        INTEGER,
+	hr_count    INTEGER,
+	session_sec INTEGER,
+	timestamp   DATETIME,
+	hidden      NUMERIC,
+	flagged     NUMERIC
+);
+
+CREATE TABLE IF NOT EXISTS rune_drops (
+	id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id INTEGER,
+	rune   TEXT,
+	qty    INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	hash    TEXT,
+	used_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS oidc_identities (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id    INTEGER,
+	provider   TEXT,
+	subject    TEXT,
+	email      TEXT,
+	created_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_oidc_identities_user_id ON oidc_identities (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_oidc_provider_subject ON oidc_identities (provider, subject);
+
+CREATE TABLE IF NOT EXISTS run_flags (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id      INTEGER,
+	rule        TEXT,
+	detail      TEXT,
+	created_at  DATETIME,
+	reviewed_by INTEGER,
+	resolution  TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_run_flags_run_id ON run_flags (run_id);
@@ -0,0 +1,19 @@
+-- Per-user, per-day rollup of runs (UserDailyStat), backfilled from history.
+CREATE TABLE user_daily_stats (
+	user_id     INTEGER NOT NULL,
+	day         TEXT    NOT NULL,
+	runs        INTEGER NOT NULL DEFAULT 0,
+	hr          INTEGER NOT NULL DEFAULT 0,
+	hr_runs     INTEGER NOT NULL DEFAULT 0,
+	uniques     INTEGER NOT NULL DEFAULT 0,
+	sets        INTEGER NOT NULL DEFAULT 0,
+	session_sec INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, day)
+);
+
+INSERT INTO user_daily_stats (user_id, day, runs, hr, hr_runs, uniques, sets, session_sec)
+SELECT user_id, date(timestamp), COUNT(*),
+	COALESCE(SUM(hr_count),0), SUM(CASE WHEN hr_count > 0 THEN 1 ELSE 0 END),
+	COALESCE(SUM(uniques),0), COALESCE(SUM(sets),0), COALESCE(SUM(session_sec),0)
+FROM runs
+GROUP BY user_id, date(timestamp);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_rune_drops_rune;
+DROP INDEX IF EXISTS idx_rune_drops_run_id;
+DROP INDEX IF EXISTS idx_runs_area_difficulty;
+DROP INDEX IF EXISTS idx_runs_user_timestamp;
@@ -0,0 +1,5 @@
+-- Indexes behind the per-user history, anti-cheat and drop queries.
+CREATE INDEX IF NOT EXISTS idx_runs_user_timestamp ON runs (user_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_runs_area_difficulty ON runs (area, difficulty);
+CREATE INDEX IF NOT EXISTS idx_rune_drops_run_id ON rune_drops (run_id);
+CREATE INDEX IF NOT EXISTS idx_rune_drops_rune ON rune_drops (rune);
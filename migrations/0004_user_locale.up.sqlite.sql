@@ -0,0 +1,2 @@
+-- Preferred UI language; empty follows the browser.
+ALTER TABLE users ADD COLUMN locale TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_overlay_token;
+ALTER TABLE users DROP COLUMN overlay_token;
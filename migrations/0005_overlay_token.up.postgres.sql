@@ -0,0 +1,3 @@
+-- Secret for the public OBS overlay URL; empty means the overlay is off.
+ALTER TABLE users ADD COLUMN overlay_token TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_overlay_token ON users (overlay_token) WHERE overlay_token <> '';
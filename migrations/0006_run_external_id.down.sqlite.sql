@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_runs_user_external_id;
+ALTER TABLE runs DROP COLUMN external_id;
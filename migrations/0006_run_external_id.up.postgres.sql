@@ -0,0 +1,4 @@
+-- Identifies an imported run in its source, so importing the same file twice
+-- adds nothing; empty for runs logged in the tracker.
+ALTER TABLE runs ADD COLUMN external_id TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_runs_user_external_id ON runs (user_id, external_id) WHERE external_id <> '';
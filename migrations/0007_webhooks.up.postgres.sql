@@ -0,0 +1,25 @@
+-- Outgoing webhook subscriptions (user_id 0 = global) and their delivery log.
+CREATE TABLE webhooks (
+	id         BIGSERIAL PRIMARY KEY,
+	user_id    BIGINT NOT NULL DEFAULT 0,
+	url        TEXT   NOT NULL,
+	secret     TEXT   NOT NULL,
+	events     TEXT   NOT NULL,
+	created_at TIMESTAMPTZ
+);
+CREATE INDEX idx_webhooks_user_id ON webhooks (user_id);
+
+CREATE TABLE webhook_deliveries (
+	id              BIGSERIAL PRIMARY KEY,
+	webhook_id      BIGINT NOT NULL,
+	event           TEXT   NOT NULL,
+	payload         TEXT   NOT NULL,
+	status          TEXT   NOT NULL DEFAULT 'pending',
+	attempts        BIGINT NOT NULL DEFAULT 0,
+	response_code   BIGINT NOT NULL DEFAULT 0,
+	error           TEXT   NOT NULL DEFAULT '',
+	created_at      TIMESTAMPTZ,
+	next_attempt_at TIMESTAMPTZ
+);
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
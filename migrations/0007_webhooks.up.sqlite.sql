@@ -0,0 +1,25 @@
+-- Outgoing webhook subscriptions (user_id 0 = global) and their delivery log.
+CREATE TABLE webhooks (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id    INTEGER NOT NULL DEFAULT 0,
+	url        TEXT    NOT NULL,
+	secret     TEXT    NOT NULL,
+	events     TEXT    NOT NULL,
+	created_at DATETIME
+);
+CREATE INDEX idx_webhooks_user_id ON webhooks (user_id);
+
+CREATE TABLE webhook_deliveries (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id      INTEGER NOT NULL,
+	event           TEXT    NOT NULL,
+	payload         TEXT    NOT NULL,
+	status          TEXT    NOT NULL DEFAULT 'pending',
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	response_code   INTEGER NOT NULL DEFAULT 0,
+	error           TEXT    NOT NULL DEFAULT '',
+	created_at      DATETIME,
+	next_attempt_at DATETIME
+);
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
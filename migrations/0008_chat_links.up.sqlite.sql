@@ -0,0 +1,11 @@
+-- Chat accounts (Discord, Slack) linked to a tracker user for slash commands.
+CREATE TABLE chat_links (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id      INTEGER NOT NULL,
+	platform     TEXT    NOT NULL,
+	chat_user_id TEXT    NOT NULL,
+	chat_name    TEXT    NOT NULL DEFAULT '',
+	created_at   DATETIME
+);
+CREATE INDEX idx_chat_links_user_id ON chat_links (user_id);
+CREATE UNIQUE INDEX idx_chat_links_platform_user ON chat_links (platform, chat_user_id);
@@ -0,0 +1,28 @@
+-- Teams (clans), their members and pending invites / join requests.
+CREATE TABLE teams (
+	id         BIGSERIAL PRIMARY KEY,
+	name       TEXT NOT NULL,
+	tag        TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX idx_teams_name ON teams (name);
+
+CREATE TABLE team_members (
+	id         BIGSERIAL PRIMARY KEY,
+	team_id    BIGINT NOT NULL,
+	user_id    BIGINT NOT NULL,
+	role       TEXT   NOT NULL DEFAULT 'member',
+	created_at TIMESTAMPTZ
+);
+CREATE INDEX idx_team_members_team_id ON team_members (team_id);
+CREATE UNIQUE INDEX idx_team_members_user_id ON team_members (user_id);
+
+CREATE TABLE team_requests (
+	id         BIGSERIAL PRIMARY KEY,
+	team_id    BIGINT  NOT NULL,
+	user_id    BIGINT  NOT NULL,
+	invite     BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX idx_team_requests_team_user ON team_requests (team_id, user_id);
+CREATE INDEX idx_team_requests_user_id ON team_requests (user_id);
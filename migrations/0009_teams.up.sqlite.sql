@@ -0,0 +1,28 @@
+-- Teams (clans), their members and pending invites / join requests.
+CREATE TABLE teams (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	name       TEXT    NOT NULL,
+	tag        TEXT    NOT NULL DEFAULT '',
+	created_at DATETIME
+);
+CREATE UNIQUE INDEX idx_teams_name ON teams (name);
+
+CREATE TABLE team_members (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	team_id    INTEGER NOT NULL,
+	user_id    INTEGER NOT NULL,
+	role       TEXT    NOT NULL DEFAULT 'member',
+	created_at DATETIME
+);
+CREATE INDEX idx_team_members_team_id ON team_members (team_id);
+CREATE UNIQUE INDEX idx_team_members_user_id ON team_members (user_id);
+
+CREATE TABLE team_requests (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	team_id    INTEGER NOT NULL,
+	user_id    INTEGER NOT NULL,
+	invite     NUMERIC NOT NULL DEFAULT false,
+	created_at DATETIME
+);
+CREATE UNIQUE INDEX idx_team_requests_team_user ON team_requests (team_id, user_id);
+CREATE INDEX idx_team_requests_user_id ON team_requests (user_id);
@@ -0,0 +1,2 @@
+-- Newest accepted TOTP time step, so a code cannot be replayed.
+ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN two_fa_failed_at;
+ALTER TABLE users DROP COLUMN two_fa_failures;
@@ -0,0 +1,4 @@
+-- Wrong second-factor codes per user, so the lockout outlasts a new
+-- password step, a restart and the instance that counted them.
+ALTER TABLE users ADD COLUMN two_fa_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN two_fa_failed_at INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// OIDCIdentity links an external OpenID Connect subject to a local User. One
+// user may hold identities from several providers; a (provider, subject) pair
+// belongs to exactly one user.
+type OIDCIdentity struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Provider  string `gorm:"uniqueIndex:idx_oidc_provider_subject"`
+	Subject   string `gorm:"uniqueIndex:idx_oidc_provider_subject"`
+	Email     string
+	CreatedAt time.Time
+}
+
+// TableName matches the migrations; gorm would otherwise split "OIDC" into
+// o_id_c_identities.
+func (OIDCIdentity) TableName() string { return "oidc_identities" }
+
+type oidcProvider struct {
+	Name        string
+	DisplayName string
+	Issuer      string
+	ClientID    string
+	Secret      string
+	Scopes      []string
+	RedirectURL string
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+var oidcProviders = map[string]*oidcProvider{}
+
+// initOIDC reads providers from the environment:
+//
+//	OIDC_PROVIDERS=google,keycloak
+//	OIDC_GOOGLE_ISSUER, OIDC_GOOGLE_CLIENT_ID, OIDC_GOOGLE_CLIENT_SECRET
+//	OIDC_GOOGLE_NAME (button label), OIDC_GOOGLE_SCOPES (extra, space separated)
+//
+// Callbacks go to baseURL. Discovery is deferred until the first login so an
+// unreachable issuer does not prevent the tracker from booting.
+func initOIDC(baseURL string) {
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p := &oidcProvider{
+			Name:        name,
+			DisplayName: os.Getenv(prefix + "NAME"),
+			Issuer:      os.Getenv(prefix + "ISSUER"),
+			ClientID:    os.Getenv(prefix + "CLIENT_ID"),
+			Secret:      os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:      append([]string{oidc.ScopeOpenID, "profile", "email"}, strings.Fields(os.Getenv(prefix+"SCOPES"))...),
+			RedirectURL: baseURL + "/auth/oidc/" + name + "/callback",
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			slog.Warn("oidc provider skipped: issuer or client id missing", "provider", name, "env_prefix", prefix)
+			continue
+		}
+		if p.DisplayName == "" {
+			p.DisplayName = strings.ToUpper(name[:1]) + name[1:]
+		}
+		oidcProviders[name] = p
+	}
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider == nil {
+		prov, err := oidc.NewProvider(ctx, p.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		p.provider = prov
+	}
+	return p.provider, nil
+}
+
+func (p *oidcProvider) oauth2Config(prov *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.Secret,
+		Endpoint:     prov.Endpoint(),
+		RedirectURL:  p.RedirectURL,
+		Scopes:       p.Scopes,
+	}
+}
+
+// ==================== OIDC: LOGIN ====================
+func oidcLoginHandler(c *gin.Context) {
+	p, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		c.String(http.StatusNotFound, "unknown provider")
+		return
+	}
+	prov, err := p.discover(c.Request.Context())
+	if err != nil {
+		reqLog(c).Error("oidc discovery failed", "provider", p.Name, "err", err)
+		oidcFail(c, http.StatusBadGateway, "login.provider_down")
+		return
+	}
+
+	state, nonce, verifier := randomToken(), randomToken(), oauth2.GenerateVerifier()
+	session := sessions.Default(c)
+	session.Set("oidc_state", state)
+	session.Set("oidc_nonce", nonce)
+	session.Set("oidc_verifier", verifier)
+	session.Set("oidc_provider", p.Name)
+	session.Save()
+
+	url := p.oauth2Config(prov).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	c.Redirect(http.StatusFound, url)
+}
+
+func (s *server) oidcCallbackHandler(c *gin.Context) {
+	p, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		c.String(http.StatusNotFound, "unknown provider")
+		return
+	}
+	session := sessions.Default(c)
+	state, _ := session.Get("oidc_state").(string)
+	nonce, _ := session.Get("oidc_nonce").(string)
+	verifier, _ := session.Get("oidc_verifier").(string)
+	provider, _ := session.Get("oidc_provider").(string)
+	linking, _ := session.Get(oidcLinkKey).(string)
+	session.Delete("oidc_state")
+	session.Delete("oidc_nonce")
+	session.Delete("oidc_verifier")
+	session.Delete("oidc_provider")
+	session.Delete(oidcLinkKey)
+	session.Save()
+
+	if state == "" || provider != p.Name || c.Query("state") != state {
+		oidcFail(c, http.StatusBadRequest, "login.oidc_expired")
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		oidcFail(c, http.StatusUnauthorized, "login.oidc_denied", e)
+		return
+	}
+
+	claims, err := p.exchange(c.Request.Context(), c.Query("code"), verifier, nonce)
+	if err != nil {
+		reqLog(c).Warn("oidc callback rejected", "provider", p.Name, "err", err)
+		oidcFail(c, http.StatusUnauthorized, "login.oidc_unverified")
+		return
+	}
+
+	// A signed-in user reaching the callback is confirming it is them with a
+	// provider they already linked, or linking a new one they asked for on
+	// /account, rather than logging in.
+	if userID, ok := session.Get("user_id").(uint); ok {
+		ident, err := s.reqStore(c).IdentityBySubject(p.Name, claims.Subject)
+		if err == nil && ident.UserID == userID {
+			confirmIdentity(session, User{ID: userID})
+			session.Save()
+			c.Redirect(http.StatusFound, "/account")
+			return
+		}
+		if errors.Is(err, ErrNotFound) && linking != p.Name {
+			s.renderAccount(c, http.StatusUnauthorized, accountError(c, "account.err.link_reauth"))
+			return
+		}
+		if err := s.linkIdentity(c.Request.Context(), userID, p.Name, claims); err != nil {
+			key := "account.err.link_failed"
+			if errors.Is(err, errIdentityTaken) {
+				key = "account.err.identity_taken"
+			} else {
+				reqLog(c).Error("oidc link failed", "provider", p.Name, "err", err)
+			}
+			s.renderAccount(c, http.StatusConflict, accountError(c, key))
+			return
+		}
+		c.Redirect(http.StatusFound, "/account")
+		return
+	}
+
+	user, err := s.userForIdentity(c.Request.Context(), p.Name, claims)
+	if err != nil {
+		reqLog(c).Error("oidc account lookup failed", "provider", p.Name, "err", err)
+		oidcFail(c, http.StatusInternalServerError, "login.oidc_create_failed")
+		return
+	}
+	if user.Banned {
+		oidcFail(c, http.StatusForbidden, "login.banned")
+		return
+	}
+	// Accounts without a password confirm sensitive changes with a recent
+	// provider login; see reauthenticated.
+	confirmIdentity(session, user)
+	if user.TOTPEnabled {
+		s.beginTwoFA(c, user)
+		return
+	}
+	signIn(session, user)
+	c.Redirect(http.StatusFound, "/dashboard")
+}
+
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+func (p *oidcProvider) exchange(ctx context.Context, code, verifier, nonce string) (oidcClaims, error) {
+	var claims oidcClaims
+	prov, err := p.discover(ctx)
+	if err != nil {
+		return claims, err
+	}
+	tok, err := p.oauth2Config(prov).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return claims, fmt.Errorf("exchange: %w", err)
+	}
+	raw, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return claims, errors.New("no id_token in token response")
+	}
+	idToken, err := prov.Verifier(&oidc.Config{ClientID: p.ClientID}).Verify(ctx, raw)
+	if err != nil {
+		return claims, fmt.Errorf("verify: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return claims, errors.New("nonce mismatch")
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return claims, err
+	}
+	claims.Subject = idToken.Subject
+	return claims, nil
+}
+
+// userForIdentity returns the user linked to the identity, registering a new
+// account on first login. Accounts are never matched by e-mail: linking an
+// existing local account requires being signed in to it first.
+//
+// The unique indexes decide races: a taken username moves on to the next
+// candidate, and a concurrent first login of the same identity is found by
+// the lookup on the next pass.
+func (s *server) userForIdentity(ctx context.Context, provider string, claims oidcClaims) (User, error) {
+	store := s.store.WithContext(ctx)
+	base := usernameBase(claims)
+	for attempt := 1; ; attempt++ {
+		if ident, err := store.IdentityBySubject(provider, claims.Subject); err == nil {
+			return store.UserByID(ident.UserID)
+		} else if !errors.Is(err, ErrNotFound) {
+			return User{}, err
+		}
+
+		user := User{Username: base}
+		if attempt > 1 {
+			user.Username = fmt.Sprintf("%s%d", base, attempt)
+		}
+		err := store.CreateUserWithIdentity(&user, &OIDCIdentity{Provider: provider, Subject: claims.Subject, Email: claims.Email, CreatedAt: time.Now()})
+		if !errors.Is(err, ErrConflict) || attempt == oidcUsernameAttempts {
+			return user, err
+		}
+	}
+}
+
+// errIdentityTaken means the external account already belongs to another user.
+var errIdentityTaken = errors.New("identity linked to another user")
+
+func (s *server) linkIdentity(ctx context.Context, userID uint, provider string, claims oidcClaims) error {
+	store := s.store.WithContext(ctx)
+	if existing, err := store.IdentityBySubject(provider, claims.Subject); err == nil {
+		if existing.UserID == userID {
+			return nil
+		}
+		return errIdentityTaken
+	}
+	return store.CreateIdentity(&OIDCIdentity{UserID: userID, Provider: provider, Subject: claims.Subject, Email: claims.Email, CreatedAt: time.Now()})
+}
+
+var usernameCleaner = regexp.MustCompile(`[^\p{L}\p{N}_.-]+`)
+
+// oidcUsernameAttempts bounds the base, base2, base3… names tried for a new
+// account before the login fails.
+const oidcUsernameAttempts = 100
+
+func usernameBase(claims oidcClaims) string {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = claims.Name
+	}
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameCleaner.ReplaceAllString(base, "")
+	if base == "" {
+		base = "bohater"
+	}
+	return base
+}
+
+// ==================== OIDC: ACCOUNT LINKS ====================
+
+// oidcLinkKey names the provider the signed-in user asked to link. Only that
+// provider's callback may attach a new identity, so a stolen session cannot
+// add a login of the thief's own.
+const oidcLinkKey = "oidc_link"
+
+// linkIdentityHandler starts linking a provider once the user has confirmed
+// it is them, the same way as for the other sensitive account changes.
+func (s *server) linkIdentityHandler(c *gin.Context) {
+	p, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		c.String(http.StatusNotFound, "unknown provider")
+		return
+	}
+	session := sessions.Default(c)
+	user, err := s.reqStore(c).UserByID(session.Get("user_id").(uint))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/logout")
+		return
+	}
+	if !reauthenticated(c, user, c.PostForm("password")) {
+		s.renderAccount(c, http.StatusUnauthorized, reauthError(c, user, "account.err.password"))
+		return
+	}
+	session.Set(oidcLinkKey, p.Name)
+	session.Save()
+	c.Redirect(http.StatusFound, "/auth/oidc/"+p.Name)
+}
+
+func (s *server) unlinkIdentityHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	user, err := s.reqStore(c).UserByID(userID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/logout")
+		return
+	}
+	links, _ := s.reqStore(c).IdentitiesByUser(userID)
+	if user.Password == "" && len(links) <= 1 {
+		s.renderAccount(c, http.StatusBadRequest, accountError(c, "account.err.last_login"))
+		return
+	}
+	s.reqStore(c).DeleteIdentity(userID, c.Param("provider"))
+	c.Redirect(http.StatusFound, "/account")
+}
+
+// linkedProvider is one row of the "linked accounts" panel on /account.
+type linkedProvider struct {
+	Name, DisplayName, Email string
+	Linked                   bool
+}
+
+func (s *server) linkedProviders(ctx context.Context, userID uint) []linkedProvider {
+	if len(oidcProviders) == 0 {
+		return nil
+	}
+	idents, _ := s.store.WithContext(ctx).IdentitiesByUser(userID)
+	linked := map[string]OIDCIdentity{}
+	for _, i := range idents {
+		linked[i.Provider] = i
+	}
+
+	var rows []linkedProvider
+	for _, p := range sortedOIDCProviders() {
+		i, ok := linked[p.Name]
+		rows = append(rows, linkedProvider{Name: p.Name, DisplayName: p.DisplayName, Email: i.Email, Linked: ok})
+	}
+	return rows
+}
+
+func sortedOIDCProviders() []*oidcProvider {
+	list := make([]*oidcProvider, 0, len(oidcProviders))
+	for _, p := range oidcProviders {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func oidcFail(c *gin.Context, status int, key string, args ...any) {
+	renderLogin(c, status, loc(c).T(key, args...))
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIssuer is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that checks PKCE and returns an RS256 ID token.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]any
+	signer    *rsa.PrivateKey
+}
+
+const mockClientID = "tracker"
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	m := &mockIssuer{key: testRSAKey(t), grants: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub := m.key.PublicKey
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		m.mu.Lock()
+		g, ok := m.grants[r.PostForm.Get("code")]
+		delete(m.grants, r.PostForm.Get("code"))
+		m.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || b64(sum[:]) != g.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access", "token_type": "Bearer", "expires_in": 300,
+			"id_token": signJWT(t, g.signer, g.claims),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// login walks c through /auth/oidc/mock and the callback as subject. edit may
+// tamper with the ID token claims or the signing key before it is issued.
+func (m *mockIssuer) login(t *testing.T, c *testClient, subject, username string, edit ...func(claims map[string]any, g *mockGrant)) testResponse {
+	t.Helper()
+	res := c.get("/auth/oidc/mock")
+	auth, err := url.Parse(res.Location)
+	if err != nil || !strings.HasPrefix(res.Location, m.URL+"/authorize") {
+		t.Fatalf("login redirect: %d %q", res.Status, res.Location)
+	}
+	q := auth.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != mockClientID {
+		t.Fatalf("authorize request: %v", q)
+	}
+
+	now := time.Now()
+	g := mockGrant{challenge: q.Get("code_challenge"), signer: m.key, claims: map[string]any{
+		"iss": m.URL, "aud": mockClientID, "sub": subject, "nonce": q.Get("nonce"),
+		"iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(),
+		"preferred_username": username, "email": username + "@example.com", "email_verified": true,
+	}}
+	for _, f := range edit {
+		f(g.claims, &g)
+	}
+	code := randomToken()
+	m.mu.Lock()
+	m.grants[code] = g
+	m.mu.Unlock()
+	return c.get("/auth/oidc/mock/callback?" + url.Values{"state": {q.Get("state")}, "code": {code}}.Encode())
+}
+
+// withMockProvider registers the issuer as provider "mock" for ts.
+func withMockProvider(t *testing.T, ts testServer, m *mockIssuer) {
+	t.Helper()
+	saved := oidcProviders
+	oidcProviders = map[string]*oidcProvider{"mock": {
+		Name: "mock", DisplayName: "Mock", Issuer: m.URL, ClientID: mockClientID, Secret: "secret",
+		Scopes: []string{"openid", "profile", "email"}, RedirectURL: ts.URL + "/auth/oidc/mock/callback",
+	}}
+	t.Cleanup(func() { oidcProviders = saved })
+}
+
+func oidcStack(t *testing.T, store Store) (testServer, *mockIssuer) {
+	ts := newTestServer(t, store)
+	m := newMockIssuer(t)
+	withMockProvider(t, ts, m)
+	return ts, m
+}
+
+func TestOIDCFirstLoginCreatesAccount(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts, m := oidcStack(t, store)
+		if res := m.login(t, ts.client(t), "sub-1", "gandalf"); res.Location != "/dashboard" {
+			t.Fatalf("first login: %d %q", res.Status, res.Location)
+		}
+		user, err := store.UserByUsername("gandalf")
+		if err != nil || user.Password != "" {
+			t.Fatalf("account: %+v %v", user, err)
+		}
+		ident, err := store.IdentityBySubject("mock", "sub-1")
+		if err != nil || ident.UserID != user.ID || ident.Email != "gandalf@example.com" {
+			t.Fatalf("identity: %+v %v", ident, err)
+		}
+
+		// The second login finds the same account, whatever the name claim says.
+		if res := m.login(t, ts.client(t), "sub-1", "renamed"); res.Location != "/dashboard" {
+			t.Fatalf("second login: %d %q", res.Status, res.Location)
+		}
+		if _, err := store.UserByUsername("renamed"); !errors.Is(err, ErrNotFound) {
+			t.Fatal("second login registered another account")
+		}
+	})
+}
+
+func TestOIDCUsernameTaken(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts, m := oidcStack(t, store)
+		ts.user(t, "alice")
+		ts.user(t, "alice2")
+		if res := m.login(t, ts.client(t), "sub-alice", "alice"); res.Location != "/dashboard" {
+			t.Fatalf("login: %d %q", res.Status, res.Location)
+		}
+		user, err := store.UserByUsername("alice3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ident, _ := store.IdentityBySubject("mock", "sub-alice"); ident.UserID != user.ID {
+			t.Fatalf("identity points at %d, want %d", ident.UserID, user.ID)
+		}
+	})
+}
+
+func TestOIDCRejectsBadTokens(t *testing.T) {
+	other := testRSAKey(t)
+	cases := map[string]func(claims map[string]any, g *mockGrant){
+		"nonce":     func(c map[string]any, _ *mockGrant) { c["nonce"] = "replayed" },
+		"audience":  func(c map[string]any, _ *mockGrant) { c["aud"] = "someone-else" },
+		"issuer":    func(c map[string]any, _ *mockGrant) { c["iss"] = "https://evil.example" },
+		"expired":   func(c map[string]any, _ *mockGrant) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"signature": func(_ map[string]any, g *mockGrant) { g.signer = other },
+		"pkce":      func(_ map[string]any, g *mockGrant) { g.challenge = "wrong" },
+	}
+	for name, edit := range cases {
+		t.Run(name, func(t *testing.T) {
+			store := newMemStore()
+			ts, m := oidcStack(t, store)
+			if res := m.login(t, ts.client(t), "sub-bad", "mallory", edit); res.Status != http.StatusUnauthorized {
+				t.Fatalf("status %d %q", res.Status, res.Location)
+			}
+			if _, err := store.UserByUsername("mallory"); !errors.Is(err, ErrNotFound) {
+				t.Fatal("rejected token still registered an account")
+			}
+		})
+	}
+
+	t.Run("state", func(t *testing.T) {
+		ts, _ := oidcStack(t, newMemStore())
+		c := ts.client(t)
+		c.get("/auth/oidc/mock")
+		if res := c.get("/auth/oidc/mock/callback?state=forged&code=x"); res.Status != http.StatusBadRequest {
+			t.Fatalf("forged state: %d", res.Status)
+		}
+	})
+}
+
+func TestOIDCLinkToSignedInAccount(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts, m := oidcStack(t, store)
+		c := ts.user(t, "frodo")
+		user, _ := store.UserByUsername("frodo")
+
+		// A session alone does not link: the password comes first.
+		if res := m.login(t, c, "sub-frodo", "ignored"); res.Status != http.StatusUnauthorized {
+			t.Fatalf("link without asking: %d %q", res.Status, res.Location)
+		}
+		if res := c.post("/account/oidc/mock/link", url.Values{"password": {"wrong"}}); res.Status != http.StatusUnauthorized {
+			t.Fatalf("link with a wrong password: %d", res.Status)
+		}
+		if links, _ := store.IdentitiesByUser(user.ID); len(links) != 0 {
+			t.Fatalf("linked without the password: %+v", links)
+		}
+
+		if res := c.post("/account/oidc/mock/link", url.Values{"password": {testPassword}}); res.Location != "/auth/oidc/mock" {
+			t.Fatalf("start link: %d %q", res.Status, res.Location)
+		}
+		if res := m.login(t, c, "sub-frodo", "ignored"); res.Location != "/account" {
+			t.Fatalf("link: %d %q", res.Status, res.Location)
+		}
+		if ident, err := store.IdentityBySubject("mock", "sub-frodo"); err != nil || ident.UserID != user.ID {
+			t.Fatalf("identity: %+v %v", ident, err)
+		}
+		if _, err := store.UserByUsername("ignored"); !errors.Is(err, ErrNotFound) {
+			t.Fatal("linking registered an account")
+		}
+	})
+}
+
+func TestOIDCOnlyAccountNeedsFreshLogin(t *testing.T) {
+	ts, m := oidcStack(t, newMemStore())
+	c := ts.client(t)
+	m.login(t, c, "sub-sam", "sam")
+
+	if res := c.post("/account/username", url.Values{"username": {"samwise"}}); res.Status != http.StatusOK {
+		t.Fatalf("rename right after login: %d", res.Status)
+	}
+
+	saved := reauthWindow
+	reauthWindow = 0
+	t.Cleanup(func() { reauthWindow = saved })
+	for path, form := range map[string]url.Values{
+		"/account/username": {"username": {"mallory"}},
+		"/account/password": {"new_password": {"hijacked"}, "confirm_password": {"hijacked"}},
+		"/account/delete":   {"confirm": {"samwise"}},
+	} {
+		if res := c.post(path, form); res.Status != http.StatusUnauthorized {
+			t.Fatalf("%s with a stale login: %d", path, res.Status)
+		}
+	}
+	user, err := ts.store.UserByUsername("samwise")
+	if err != nil || user.Password != "" {
+		t.Fatalf("account changed without a fresh login: %+v %v", user, err)
+	}
+}
+
+// A session thief finishing the provider flow with an account of their own
+// must not pass for the owner confirming it is them.
+func TestOIDCForeignIdentityGrantsNothing(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts, m := oidcStack(t, store)
+		c := ts.user(t, "sam")
+		user, _ := store.UserByUsername("sam")
+		if err := store.CreateIdentity(&OIDCIdentity{UserID: user.ID, Provider: "mock", Subject: "sub-sam"}); err != nil {
+			t.Fatal(err)
+		}
+		// Now OIDC-only, with a session that never confirmed anything.
+		if err := store.UpdateUser(user.ID, map[string]any{"password": ""}); err != nil {
+			t.Fatal(err)
+		}
+
+		if res := m.login(t, c, "sub-mallory", "mallory"); res.Status != http.StatusUnauthorized {
+			t.Fatalf("foreign identity: %d %q", res.Status, res.Location)
+		}
+		if _, err := store.IdentityBySubject("mock", "sub-mallory"); !errors.Is(err, ErrNotFound) {
+			t.Fatal("foreign identity was linked")
+		}
+		if res := c.post("/account/username", url.Values{"username": {"mallory"}}); res.Status != http.StatusUnauthorized {
+			t.Fatalf("rename after a foreign login: %d", res.Status)
+		}
+		if res := c.post("/account/oidc/mock/link", nil); res.Status != http.StatusUnauthorized {
+			t.Fatalf("link after a foreign login: %d", res.Status)
+		}
+
+		// The owner's own identity does confirm.
+		if res := m.login(t, c, "sub-sam", "sam"); res.Location != "/account" {
+			t.Fatalf("own identity: %d %q", res.Status, res.Location)
+		}
+		if res := c.post("/account/username", url.Values{"username": {"samwise"}}); res.Status != http.StatusOK {
+			t.Fatalf("rename after confirming: %d", res.Status)
+		}
+	})
+}
+
+func TestCreateUserWithIdentityIsAtomic(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		first := User{Username: "one"}
+		if err := store.CreateUserWithIdentity(&first, &OIDCIdentity{Provider: "mock", Subject: "dup"}); err != nil {
+			t.Fatal(err)
+		}
+		second := User{Username: "two"}
+		err := store.CreateUserWithIdentity(&second, &OIDCIdentity{Provider: "mock", Subject: "dup"})
+		if !errors.Is(err, ErrConflict) {
+			t.Fatalf("duplicate identity: %v", err)
+		}
+		if _, err := store.UserByUsername("two"); !errors.Is(err, ErrNotFound) {
+			t.Fatal("user row survived the failed identity insert")
+		}
+	})
+}
+
+func TestUserForIdentityConcurrentFirstLogin(t *testing.T) {
+	ts := newTestServer(t, newMemStore())
+	ids := make([]uint, 8)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, err := ts.userForIdentity(context.Background(), "mock", oidcClaims{Subject: "racer", PreferredUsername: "racer"})
+			if err != nil {
+				t.Error(err)
+			}
+			ids[i] = user.ID
+		}()
+	}
+	wg.Wait()
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("concurrent first logins made several accounts: %v", ids)
+		}
+	}
+}
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64(header) + "." + b64(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%s.%s", signed, b64(sig))
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
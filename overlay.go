@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== OBS OVERLAY ====================
+
+// A streamer adds /overlay/<token> as an OBS browser source. The page is
+// public; the token is the only secret and can be regenerated or revoked
+// from the account page.
+
+const (
+	overlaySessionSpan = 16 * time.Hour // how far back a session is looked for
+	overlaySessionRuns = 2000
+)
+
+// overlayLayouts are the presets for ?layout=; the first is the default.
+var overlayLayouts = []string{"card", "bar", "minimal"}
+
+// overlayState is everything the widget shows; the page and every "overlay"
+// event carry it whole. Runs per hour and the timer are worked out in the
+// browser from Start.
+type overlayState struct {
+	Active   bool         `json:"active"`
+	Start    time.Time    `json:"start"`
+	LastRun  time.Time    `json:"last_run"`
+	Runs     int          `json:"runs"`
+	HR       int          `json:"hr"`
+	LastDrop *overlayDrop `json:"last_drop"`
+}
+
+type overlayDrop struct {
+	Rune string `json:"rune"`
+	Icon string `json:"icon"`
+	Qty  int    `json:"qty"`
+}
+
+// overlaySession gathers the user's current farming session: the latest
+// runs with no gap over sessionGap between them, provided the last
+// one is recent enough that the session is still going.
+func (s *server) overlaySession(ctx context.Context, userID uint) (overlayState, error) {
+	store := s.store.WithContext(ctx)
+	var st overlayState
+	now := time.Now()
+	runs, err := store.ListRuns(RunFilter{UserID: userID, Recent: true, Since: now.Add(-overlaySessionSpan), Limit: overlaySessionRuns})
+	if err != nil || len(runs) == 0 || now.Sub(runs[0].Timestamp) > sessionGap {
+		return st, err
+	}
+
+	ids := []uint{}
+	for i, r := range runs {
+		if i > 0 && runs[i-1].Timestamp.Sub(r.Timestamp) > sessionGap {
+			break
+		}
+		ids = append(ids, r.ID)
+		st.Runs++
+		st.HR += r.HRCount
+		st.Start = r.Timestamp
+	}
+	st.Active, st.LastRun = true, runs[0].Timestamp
+
+	drops, err := store.DropsByRuns(ids)
+	if err != nil {
+		return st, err
+	}
+	// The last drop is the best rune of the most recent run that had any.
+	for _, id := range ids {
+		for _, d := range drops {
+			if d.RunID == id && (st.LastDrop == nil || slices.Index(runeOrder, d.Rune) > slices.Index(runeOrder, st.LastDrop.Rune)) {
+				st.LastDrop = &overlayDrop{Rune: d.Rune, Icon: runeIcons[d.Rune], Qty: d.Qty}
+			}
+		}
+		if st.LastDrop != nil {
+			break
+		}
+	}
+	return st, nil
+}
+
+type overlayView struct {
+	page
+	Token  string
+	Layout string
+	Gap    int // sessionGap in seconds
+	State  overlayState
+}
+
+// overlayUser resolves :token, answering 404 for unknown or revoked tokens.
+func (s *server) overlayUser(c *gin.Context) (User, bool) {
+	user, err := s.reqStore(c).UserByOverlayToken(c.Param("token"))
+	if err != nil || user.Banned {
+		c.String(http.StatusNotFound, "overlay not found")
+		return user, false
+	}
+	return user, true
+}
+
+func (s *server) overlayPage(c *gin.Context) {
+	user, ok := s.overlayUser(c)
+	if !ok {
+		return
+	}
+	useUserLocale(c, user.Locale)
+	st, err := s.overlaySession(c.Request.Context(), user.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "overlay: %v", err)
+		return
+	}
+	layout := c.Query("layout")
+	if !slices.Contains(overlayLayouts, layout) {
+		layout = overlayLayouts[0]
+	}
+	// Keep the token out of logs and caches elsewhere.
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Cache-Control", "no-store")
+	c.HTML(http.StatusOK, "overlay", overlayView{
+		page:   newPage(c, "title.overlay"),
+		Token:  user.OverlayToken,
+		Layout: layout,
+		Gap:    int(sessionGap.Seconds()),
+		State:  st,
+	})
+}
+
+var errOverlayRevoked = errors.New("overlay token revoked")
+
+// overlayEventsHandler streams the overlay state each time the user logs a
+// run. A regenerated or revoked token ends the stream at the next event.
+func (s *server) overlayEventsHandler(c *gin.Context) {
+	user, ok := s.overlayUser(c)
+	if !ok {
+		return
+	}
+	s.stream(c, user.ID, func(ev liveEvent) (liveEvent, bool, error) {
+		if ev.Name != "stats" {
+			return ev, false, nil
+		}
+		if u, err := s.reqStore(c).UserByID(user.ID); err != nil || u.OverlayToken != user.OverlayToken || u.Banned {
+			return ev, false, errOverlayRevoked
+		}
+		st, err := s.overlaySession(c.Request.Context(), user.ID)
+		if err != nil {
+			reqLog(c).Error("overlay session failed", "err", err)
+			return ev, false, nil
+		}
+		out, err := newLiveEvent("overlay", st)
+		return out, err == nil, err
+	})
+}
+
+// ==================== OBS OVERLAY: ACCOUNT ====================
+
+// overlayLink is one preset URL shown on the account page.
+type overlayLink struct {
+	Label, URL string
+}
+
+func (s *server) overlayLinks(c *gin.Context, token string) []overlayLink {
+	if token == "" {
+		return nil
+	}
+	l := loc(c)
+	links := make([]overlayLink, len(overlayLayouts))
+	for i, layout := range overlayLayouts {
+		links[i] = overlayLink{Label: l.T("overlay.layout." + layout), URL: s.cfg.BaseURL + "/overlay/" + token + "?layout=" + layout}
+	}
+	return links
+}
+
+// overlayTokenHandler creates the overlay URL, or replaces it so the old one
+// stops working.
+func (s *server) overlayTokenHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	if err := s.reqStore(c).UpdateUser(userID, map[string]any{"overlay_token": randomToken()}); err != nil {
+		c.String(http.StatusInternalServerError, "overlay: %v", err)
+		return
+	}
+	s.renderAccount(c, http.StatusOK, accountNotice(c, "account.ok.overlay_created"))
+}
+
+func (s *server) overlayRevokeHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	if err := s.reqStore(c).UpdateUser(userID, map[string]any{"overlay_token": ""}); err != nil {
+		c.String(http.StatusInternalServerError, "overlay: %v", err)
+		return
+	}
+	s.renderAccount(c, http.StatusOK, accountNotice(c, "account.ok.overlay_revoked"))
+}
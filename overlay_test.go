@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The overlay is public, so a token that is unknown, replaced, revoked or
+// belongs to a banned player must look like no overlay at all.
+func TestOverlayToken(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		alice := ts.user(t, "alice")
+		anon := ts.client(t)
+		user, _ := store.UserByUsername("alice")
+		token := func() string {
+			u, _ := store.UserByID(user.ID)
+			return u.OverlayToken
+		}
+		expect := func(what, token string, status int) {
+			t.Helper()
+			if res := anon.get("/overlay/" + token); res.Status != status {
+				t.Errorf("%s page: %d, want %d", what, res.Status, status)
+			}
+			if status == http.StatusOK {
+				anon.events("/overlay/" + token + "/events")
+			} else if res := anon.get("/overlay/" + token + "/events"); res.Status != status {
+				t.Errorf("%s events: %d, want %d", what, res.Status, status)
+			}
+		}
+
+		expect("no overlay", "nope", http.StatusNotFound)
+		alice.post("/account/overlay", nil)
+		first := token()
+		expect("new token", first, http.StatusOK)
+
+		alice.post("/account/overlay", nil)
+		expect("replaced token", first, http.StatusNotFound)
+		second := token()
+		expect("new token", second, http.StatusOK)
+
+		alice.post("/account/overlay/revoke", nil)
+		if token() != "" {
+			t.Fatalf("token after revoking: %q", token())
+		}
+		expect("revoked token", second, http.StatusNotFound)
+
+		alice.post("/account/overlay", nil)
+		third := token()
+		if err := store.UpdateUser(user.ID, map[string]any{"banned": true}); err != nil {
+			t.Fatal(err)
+		}
+		expect("banned player", third, http.StatusNotFound)
+	})
+}
+
+// The overlay counts the runs since the last break longer than sessionGap,
+// and shows nothing once the player has been away that long.
+func TestOverlaySession(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		ctx := context.Background()
+		alice, bob := User{Username: "alice"}, User{Username: "bob"}
+		for _, u := range []*User{&alice, &bob} {
+			if err := store.CreateUser(u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		now := time.Now().Truncate(time.Second)
+		log := func(userID uint, at time.Time, drops ...RuneDrop) {
+			t.Helper()
+			hr := 0
+			for _, d := range drops {
+				if highRunes[d.Rune] {
+					hr += d.Qty
+				}
+			}
+			run := Run{UserID: userID, Area: "Mephisto", Difficulty: "Hell", HRCount: hr, Timestamp: at}
+			if err := store.CreateRun(&run, drops); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if st, err := ts.overlaySession(ctx, alice.ID); err != nil || st.Active {
+			t.Fatalf("no runs: %+v %v", st, err)
+		}
+
+		// A break of exactly sessionGap stays in the session; a second
+		// longer splits it, so the Ber before it is not counted.
+		start := now.Add(-time.Minute - sessionGap)
+		log(alice.ID, start.Add(-sessionGap-time.Second), RuneDrop{Rune: "Ber", Qty: 1})
+		log(alice.ID, start, RuneDrop{Rune: "Tal", Qty: 2}, RuneDrop{Rune: "Ist", Qty: 1})
+		log(alice.ID, now.Add(-time.Minute))
+
+		st, err := ts.overlaySession(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !st.Active || st.Runs != 2 || st.HR != 1 || !st.Start.Equal(start) || !st.LastRun.Equal(now.Add(-time.Minute)) {
+			t.Errorf("session = %+v", st)
+		}
+		if st.LastDrop == nil || st.LastDrop.Rune != "Ist" {
+			t.Errorf("last drop = %+v, want Ist", st.LastDrop)
+		}
+
+		// Away for longer than sessionGap: the session is over.
+		log(bob.ID, now.Add(-sessionGap-time.Minute), RuneDrop{Rune: "Ber", Qty: 1})
+		if st, err := ts.overlaySession(ctx, bob.ID); err != nil || st.Active {
+			t.Errorf("after a long break: %+v %v", st, err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const readyTimeout = 2 * time.Second
+
+// serve runs the HTTP server until SIGINT or SIGTERM, then stops accepting
+// connections, gives in-flight requests up to http.shutdown_timeout to finish
+// and closes the database pool.
+func (s *server) serve(db *gorm.DB) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	h := s.cfg.HTTP
+	srv := &http.Server{
+		Addr:              s.cfg.Listen,
+		Handler:           s.router(),
+		ReadHeaderTimeout: h.ReadHeaderTimeout.Duration,
+		ReadTimeout:       h.ReadTimeout.Duration,
+		WriteTimeout:      h.WriteTimeout.Duration,
+		IdleTimeout:       h.IdleTimeout.Duration,
+	}
+	srv.RegisterOnShutdown(s.hub.close)
+	stopWebhooks := func() {}
+	if s.cfg.Features.Webhooks {
+		stopWebhooks = s.webhooks.start()
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if s.cfg.TLS.Enabled() {
+			errc <- srv.ListenAndServeTLS(s.cfg.TLS.CertFile, s.cfg.TLS.KeyFile)
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		stopWebhooks()
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+	slog.Info("shutting down, draining requests", "timeout", h.ShutdownTimeout.String())
+	s.draining.Store(true)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), h.ShutdownTimeout.Duration)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("shutdown timed out with requests still in flight")
+	}
+	stopWebhooks()
+	if pool, perr := db.DB(); perr == nil {
+		if cerr := pool.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// healthzHandler only says the process is up; it never touches the database
+// so a slow DB does not get the instance restarted.
+func healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyzHandler says whether this instance should get traffic: not while it
+// is shutting down, and not while the database is unreachable.
+func (s *server) readyzHandler(c *gin.Context) {
+	if s.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+	if err := s.store.Ping(ctx); err != nil {
+		// The error can name hosts and users; it goes to the log only.
+		reqLog(c).Error("readiness: database ping failed", "err", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "database": "ok"})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// unreachableStore fails every ping the way a driver would, details included.
+type unreachableStore struct{ *memStore }
+
+func (unreachableStore) Ping(context.Context) error {
+	return errors.New(`dial tcp 10.0.0.5:5432: password authentication failed for user "d2r"`)
+}
+
+func TestReadyz(t *testing.T) {
+	ts := newTestServer(t, newMemStore())
+	if res := ts.client(t).get("/readyz"); res.Status != http.StatusOK {
+		t.Errorf("healthy: %d %s", res.Status, res.Body)
+	}
+
+	ts = newTestServer(t, unreachableStore{newMemStore()})
+	res := ts.client(t).get("/readyz")
+	if res.Status != http.StatusServiceUnavailable {
+		t.Errorf("unreachable: %d", res.Status)
+	}
+	if strings.Contains(res.Body, "10.0.0.5") || strings.Contains(res.Body, "d2r") {
+		t.Errorf("readyz leaks the database error: %s", res.Body)
+	}
+
+	ts.draining.Store(true)
+	if res := ts.client(t).get("/readyz"); res.Status != http.StatusServiceUnavailable {
+		t.Errorf("draining: %d", res.Status)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+func testConfig() Config {
+	cfg := defaultConfig()
+	cfg.Session.Keys = []string{"test-session-key-0123456789abcdef"}
+	return cfg
+}
+
+// testGormStore is a migrated SQLite database private to the test.
+func testGormStore(t testing.TB) *gormStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/test.db"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return newGormStore(db)
+}
+
+// testStores runs fn against every Store implementation.
+func testStores(t *testing.T, fn func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) { fn(t, newMemStore()) })
+	t.Run("gorm", func(t *testing.T) { fn(t, testGormStore(t)) })
+}
+
+type testServer struct {
+	*server
+	URL string
+}
+
+func newTestServer(t *testing.T, store Store, tweak ...func(*Config)) testServer {
+	t.Helper()
+	cfg := testConfig()
+	for _, f := range tweak {
+		f(&cfg)
+	}
+	s := newServer(&cfg, store)
+	srv := httptest.NewServer(s.router())
+	t.Cleanup(srv.Close)
+	return testServer{server: s, URL: srv.URL}
+}
+
+// testClient is one browser: it keeps cookies and does not follow redirects.
+type testClient struct {
+	t    *testing.T
+	base string
+	http *http.Client
+}
+
+func (ts testServer) client(t *testing.T) *testClient {
+	jar, _ := cookiejar.New(nil)
+	return &testClient{t: t, base: ts.URL, http: &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+}
+
+// user registers name and returns a client signed in as them.
+func (ts testServer) user(t *testing.T, name string) *testClient {
+	c := ts.client(t)
+	c.post("/register", url.Values{"username": {name}, "password": {testPassword}, "confirm_password": {testPassword}})
+	if res := c.post("/login", url.Values{"username": {name}, "password": {testPassword}}); res.Location != "/dashboard" {
+		t.Fatalf("login %s: %d %s", name, res.Status, res.Location)
+	}
+	return c
+}
+
+const testPassword = "correct horse battery"
+
+type testResponse struct {
+	Status   int
+	Location string
+	Body     string
+}
+
+func (c *testClient) do(req *http.Request) testResponse {
+	c.t.Helper()
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return testResponse{Status: res.StatusCode, Location: res.Header.Get("Location"), Body: string(body)}
+}
+
+func (c *testClient) get(path string) testResponse {
+	c.t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, c.base+path, nil)
+	return c.do(req)
+}
+
+func (c *testClient) post(path string, form url.Values) testResponse {
+	c.t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, c.base+path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req)
+}
+
+func mustURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,264 @@
+// Dashboard: rune picker, log-run modal, keyboard quick log and session timer.
+
+const runeNames = Array.from(document.querySelectorAll("[data-rune]"), b => b.dataset.rune);
+
+function chips(el, runes, onRemove, empty) {
+	el.replaceChildren(...runes.map((item, i) => {
+		const chip = document.createElement("div");
+		chip.className = "rune-chip";
+		chip.textContent = item.rune + " × " + item.qty;
+		chip.onclick = () => onRemove(i);
+		return chip;
+	}));
+	if (!runes.length && empty) el.textContent = empty;
+}
+
+function addRune(runes, r, qty) {
+	const have = runes.find(item => item.rune === r);
+	if (have) have.qty += qty;
+	else runes.push({rune: r, qty: qty});
+}
+
+function pickRune(r) {
+	if (quickOn()) quickAddRune(r);
+	else addRuneToCurrent(r);
+}
+
+// ==================== LOG RUN MODAL ====================
+let currentRunes = [];
+
+function addRuneToCurrent(r) {
+	let qty = prompt(i18n.qty_prompt.replace("%s", r), "1");
+	if (qty && parseInt(qty) > 0) {
+		addRune(currentRunes, r, parseInt(qty));
+		renderSelected();
+	}
+}
+
+function renderSelected() {
+	chips(document.getElementById("selectedRunes"), currentRunes, removeRune, i18n.pick_runes);
+}
+
+function removeRune(i) { currentRunes.splice(i, 1); renderSelected(); }
+
+function showLogModal() { currentRunes = []; renderSelected(); document.getElementById("logModal").classList.remove("hidden"); }
+
+function hideLogModal() { document.getElementById("logModal").classList.add("hidden"); }
+
+function modalOpen() { return !document.getElementById("logModal").classList.contains("hidden"); }
+
+function submitRun(e) {
+	e.preventDefault();
+	const form = new FormData(e.target);
+	logRun({
+		area: form.get("area"),
+		difficulty: form.get("difficulty"),
+		uniques: parseInt(form.get("uniques")) || 0,
+		sets: parseInt(form.get("sets")) || 0,
+		runes: currentRunes,
+	});
+	hideLogModal();
+}
+
+// ==================== SUBMISSION ====================
+// Runs are posted one after another so the counters always end on the
+// totals of the last run the server saw; nothing waits for the reply.
+let queue = Promise.resolve();
+
+function logRun(run) {
+	run = JSON.parse(JSON.stringify(run));
+	localStorage.setItem("d2r.lastRun", JSON.stringify(run));
+	queue = queue.then(() => {
+		const form = new FormData();
+		form.append("area", run.area);
+		form.append("difficulty", run.difficulty);
+		form.append("uniques", run.uniques);
+		form.append("sets", run.sets);
+		form.append("runes", JSON.stringify(run.runes));
+		return fetch("/log-run", {method: "POST", body: form})
+			.then(r => r.ok ? r.json() : Promise.reject(r.status))
+			.then(d => {
+				paintTotals(d.totals);
+				status(i18n.saved.replace("%s", d.hr) + (d.flagged ? " " + i18n.flagged : ""), false);
+			});
+	}).catch(() => status(i18n.save_failed, true));
+}
+
+const numbers = new Intl.NumberFormat(document.documentElement.lang);
+const decimals = n => new Intl.NumberFormat(document.documentElement.lang, {minimumFractionDigits: n, maximumFractionDigits: n});
+
+function paintTotals(t) {
+	const text = {
+		runs: numbers.format(t.runs), hr: numbers.format(t.hr), uniques: numbers.format(t.uniques), sets: numbers.format(t.sets),
+		avg_hr: decimals(2).format(t.avg_hr), efficiency: decimals(1).format(t.efficiency),
+	};
+	document.querySelectorAll("[data-stat]").forEach(el => {
+		if (el.dataset.stat in text) el.textContent = text[el.dataset.stat];
+	});
+}
+
+function status(msg, error) {
+	const el = document.getElementById("runStatus");
+	el.textContent = msg;
+	el.className = "mt-6 text-xl text-center " + (error ? "text-red-400" : "text-emerald-400");
+}
+
+// ==================== QUICK LOG ====================
+// A keyboard-only mode for farming nights: the area and difficulty stay put
+// between runs, runes are typed by name and Enter logs the run.
+let quick = {runes: [], uniques: 0, sets: 0}, buffer = "", bufferTimer;
+
+function quickOn() { return !document.getElementById("quickLog").classList.contains("hidden"); }
+
+function toggleQuickLog() {
+	const on = !quickOn();
+	document.getElementById("quickLog").classList.toggle("hidden", !on);
+	localStorage.setItem("d2r.quick", on ? "1" : "");
+	quickClear();
+}
+
+function saveQuickPlace() {
+	localStorage.setItem("d2r.place", JSON.stringify({area: qlArea.value, difficulty: qlDifficulty.value}));
+}
+
+function restoreQuickPlace() {
+	const place = JSON.parse(localStorage.getItem("d2r.place") || "null");
+	if (place) {
+		selectValue(qlArea, place.area);
+		selectValue(qlDifficulty, place.difficulty);
+	}
+}
+
+function selectValue(select, value) {
+	if (Array.from(select.options).some(o => o.value === value)) select.value = value;
+}
+
+function stepSelect(select, i, relative) {
+	const n = select.options.length;
+	i = relative ? (select.selectedIndex + i + n) % n : i;
+	if (i >= 0 && i < n) {
+		select.selectedIndex = i;
+		saveQuickPlace();
+	}
+}
+
+function quickAddRune(r) {
+	addRune(quick.runes, r, 1);
+	renderQuick();
+}
+
+function quickRemoveRune(i) { quick.runes.splice(i, 1); renderQuick(); }
+
+function quickClear() {
+	quick = {runes: [], uniques: 0, sets: 0};
+	buffer = rest = "";
+	renderQuick();
+}
+
+function renderQuick() {
+	chips(document.getElementById("qlRunes"), quick.runes, quickRemoveRune);
+	document.getElementById("qlBuffer").textContent = buffer;
+	document.getElementById("qlUniques").textContent = quick.uniques;
+	document.getElementById("qlSets").textContent = quick.sets;
+}
+
+function quickSubmit() {
+	commitBuffer();
+	logRun({area: qlArea.value, difficulty: qlDifficulty.value, uniques: quick.uniques, sets: quick.sets, runes: quick.runes});
+	quickClear();
+}
+
+function quickRepeat() {
+	const last = JSON.parse(localStorage.getItem("d2r.lastRun") || "null");
+	if (!last) {
+		status(i18n.no_last_run, true);
+		return;
+	}
+	logRun(last);
+}
+
+// Typed letters narrow the rune list; a rune is picked as soon as only one
+// name fits, and the rest of its name may still be typed out. "El" is also
+// the start of "Eld", so an exact match is picked after a pause or on the
+// next key that does not continue it.
+const matching = prefix => runeNames.filter(r => r.toLowerCase().startsWith(prefix));
+let rest = "";
+
+function typeRune(ch) {
+	clearTimeout(bufferTimer);
+	if (rest) {
+		const more = rest[0] === ch;
+		rest = more ? rest.slice(1) : "";
+		if (more) return;
+	}
+	if (buffer && !matching(buffer + ch).length) commitBuffer();
+	buffer += ch;
+	const found = matching(buffer);
+	if (!found.length) buffer = "";
+	else if (found.length === 1) {
+		quickAddRune(found[0]);
+		rest = found[0].toLowerCase().slice(buffer.length);
+		buffer = "";
+	} else bufferTimer = setTimeout(() => { commitBuffer(); renderQuick(); }, 800);
+	renderQuick();
+}
+
+function commitBuffer() {
+	clearTimeout(bufferTimer);
+	const exact = runeNames.find(r => r.toLowerCase() === buffer);
+	if (exact) addRune(quick.runes, exact, 1);
+	buffer = "";
+}
+
+function quickKey(e) {
+	if (e.ctrlKey || e.metaKey || e.altKey || modalOpen()) return;
+	if (["INPUT", "SELECT", "TEXTAREA"].includes(e.target.tagName)) return;
+	if (e.key === "q" || e.key === "Q") {
+		toggleQuickLog();
+		e.preventDefault();
+		return;
+	}
+	if (!quickOn()) return;
+
+	const digit = /^Digit(\d)$/.exec(e.code);
+	if (digit && e.shiftKey) stepSelect(qlDifficulty, parseInt(digit[1]) - 1);
+	else if (digit) stepSelect(qlArea, (parseInt(digit[1]) + 9) % 10);
+	else if (e.key === "[") stepSelect(qlArea, -1, true);
+	else if (e.key === "]") stepSelect(qlArea, 1, true);
+	else if (/^[a-z]$/i.test(e.key)) typeRune(e.key.toLowerCase());
+	else if (e.key === "ArrowUp") quick.uniques++;
+	else if (e.key === "ArrowDown") quick.uniques = Math.max(0, quick.uniques - 1);
+	else if (e.key === "ArrowRight") quick.sets++;
+	else if (e.key === "ArrowLeft") quick.sets = Math.max(0, quick.sets - 1);
+	else if (e.key === "Backspace") {
+		if (buffer) buffer = buffer.slice(0, -1);
+		else if (quick.runes.length) {
+			const last = quick.runes[quick.runes.length - 1];
+			if (--last.qty === 0) quick.runes.pop();
+		}
+	} else if (e.key === "Enter") quickSubmit();
+	else if (e.key === "=") quickRepeat();
+	else if (e.key === "Escape") quickClear();
+	else return;
+	e.preventDefault();
+	renderQuick();
+}
+
+const qlArea = document.getElementById("qlArea"), qlDifficulty = document.getElementById("qlDifficulty");
+document.addEventListener("keydown", quickKey);
+restoreQuickPlace();
+if (localStorage.getItem("d2r.quick")) toggleQuickLog();
+
+// ==================== SESSION TIMER ====================
+let seconds = 0, timer;
+
+function startSession() {
+	clearInterval(timer);
+	seconds = 0;
+	timer = setInterval(() => {
+		seconds++;
+		let h = Math.floor(seconds / 3600), m = Math.floor((seconds % 3600) / 60), s = seconds % 60;
+		document.getElementById("timer").textContent = h.toString().padStart(2, '0') + ":" + m.toString().padStart(2, '0') + ":" + s.toString().padStart(2, '0');
+	}, 1000);
+	status(i18n.session_started, false);
+}
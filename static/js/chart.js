@@ -0,0 +1,55 @@
+// Minimal canvas line chart for /my-stats, replacing Chart.js from a CDN.
+// lineChart(canvas, {labels, data, color, grid}) draws one series with a
+// zero-based y axis and redraws when the canvas is resized.
+
+function lineChart(canvas, opts) {
+	const color = opts.color || "#c9a14d", grid = opts.grid || "#4a2c0f";
+	const labels = opts.labels || [], data = opts.data || [];
+
+	function draw() {
+		const dpr = window.devicePixelRatio || 1;
+		const w = canvas.clientWidth, h = canvas.clientHeight;
+		canvas.width = w * dpr;
+		canvas.height = h * dpr;
+		const ctx = canvas.getContext("2d");
+		ctx.scale(dpr, dpr);
+		ctx.clearRect(0, 0, w, h);
+
+		const pad = {l: 40, r: 12, t: 12, b: 28};
+		const pw = w - pad.l - pad.r, ph = h - pad.t - pad.b;
+		const max = Math.max(1, ...data);
+		const step = Math.max(1, Math.ceil(max / 5));
+		const top = step * Math.ceil(max / step);
+		const x = i => pad.l + (data.length > 1 ? i * pw / (data.length - 1) : pw / 2);
+		const y = v => pad.t + ph - v * ph / top;
+
+		ctx.font = "12px system-ui, sans-serif";
+		ctx.fillStyle = "#e8d5a3";
+		ctx.strokeStyle = grid;
+		ctx.lineWidth = 1;
+		ctx.textAlign = "right";
+		ctx.textBaseline = "middle";
+		for (let v = 0; v <= top; v += step) {
+			ctx.beginPath();
+			ctx.moveTo(pad.l, y(v));
+			ctx.lineTo(w - pad.r, y(v));
+			ctx.stroke();
+			ctx.fillText(v, pad.l - 6, y(v));
+		}
+		ctx.textAlign = "center";
+		ctx.textBaseline = "top";
+		const every = Math.ceil(labels.length / Math.max(1, Math.floor(pw / 48)));
+		labels.forEach((l, i) => { if (i % every === 0) ctx.fillText(l, x(i), h - pad.b + 8); });
+
+		ctx.strokeStyle = color;
+		ctx.lineWidth = 2;
+		ctx.beginPath();
+		data.forEach((v, i) => i ? ctx.lineTo(x(i), y(v)) : ctx.moveTo(x(i), y(v)));
+		ctx.stroke();
+		ctx.fillStyle = color;
+		data.forEach((v, i) => { ctx.beginPath(); ctx.arc(x(i), y(v), 3, 0, 2 * Math.PI); ctx.fill(); });
+	}
+
+	draw();
+	window.addEventListener("resize", draw);
+}
@@ -0,0 +1,141 @@
+// Import page: the chosen file is sent for a dry run, the column mapping is
+// adjusted against the preview (each change previews again), then imported.
+
+const importForm = document.getElementById("importForm");
+let mapping = null, columns = [];
+
+function sendImport(dryRun) {
+	const data = new FormData(importForm);
+	if (mapping) data.append("mapping", JSON.stringify(mapping));
+	if (dryRun) data.append("dry_run", "1");
+	importStatus(i18n.working, false);
+	return fetch("/import", {method: "POST", body: data})
+		.then(r => r.json().catch(() => ({})).then(d => r.ok ? d : Promise.reject(d.error || i18n.failed)));
+}
+
+function preview() {
+	sendImport(true).then(report => {
+		renderReport(report);
+		importStatus("", false);
+	}, err => importStatus(err, true));
+}
+
+function runImport() {
+	document.getElementById("importSubmit").disabled = true;
+	sendImport(false).then(report => {
+		renderReport(report);
+		importStatus(i18n.done.replace("%d", report.imported), false);
+	}, err => importStatus(err, true));
+}
+
+function importStatus(msg, error) {
+	const el = document.getElementById("importStatus");
+	el.textContent = msg;
+	el.className = "mt-6 text-xl " + (error ? "text-red-400" : "text-emerald-400");
+}
+
+// ==================== MAPPING ====================
+const areaLabels = Object.fromEntries(importSetup.areas.map(o => [o.Value, o.Label]));
+const difficultyLabels = Object.fromEntries(importSetup.difficulties.map(o => [o.Value, o.Label]));
+
+function option(value, label) {
+	const o = document.createElement("option");
+	o.value = value;
+	o.textContent = label;
+	return o;
+}
+
+// mappingSelect offers every column, and for area and difficulty a fixed
+// value for files that hold only one.
+function mappingSelect(field) {
+	const select = document.createElement("select");
+	select.className = "d2-input w-full p-3";
+	select.append(option("", i18n.unmapped), ...columns.map(c => option(c, c)));
+	const constants = {area: importSetup.areas, difficulty: importSetup.difficulties}[field];
+	if (constants) {
+		const group = document.createElement("optgroup");
+		group.label = i18n.always;
+		group.append(...constants.map(o => option("=" + o.Value, o.Label)));
+		select.append(group);
+	}
+	select.value = mapping[field] || "";
+	select.onchange = () => {
+		if (select.value) mapping[field] = select.value;
+		else delete mapping[field];
+		preview();
+	};
+	return select;
+}
+
+function renderMapping() {
+	const fields = importSetup.fields.map(f => [f.Value, f.Label]);
+	for (const key of Object.keys(mapping).sort()) {
+		if (key.startsWith("rune:")) fields.push([key, i18n.rune_column.replace("%s", key.slice(5))]);
+	}
+	document.getElementById("mappingFields").replaceChildren(...fields.map(([field, label]) => {
+		const wrap = document.createElement("label");
+		wrap.className = "block";
+		const span = document.createElement("span");
+		span.className = "text-amber-300";
+		span.textContent = label;
+		wrap.append(span, mappingSelect(field));
+		return wrap;
+	}));
+	document.getElementById("importMapping").classList.remove("hidden");
+}
+
+// ==================== PREVIEW ====================
+const statusClass = {new: "text-emerald-400", duplicate: "text-amber-300", error: "text-red-400"};
+
+function cell(text, className) {
+	const td = document.createElement("td");
+	td.textContent = text;
+	if (className) td.className = className;
+	return td;
+}
+
+function renderRows(rows) {
+	document.getElementById("importRows").replaceChildren(...rows.map(row => {
+		const tr = document.createElement("tr");
+		tr.className = "border-b border-amber-900";
+		tr.append(cell(row.line, "py-3 px-4"), cell(i18n["status_" + row.status], statusClass[row.status]));
+		if (row.status === "error") {
+			const err = cell(row.error, "text-red-400");
+			err.colSpan = 5;
+			tr.append(err);
+		} else {
+			const r = row.run;
+			tr.append(
+				cell(new Date(r.timestamp).toLocaleString(document.documentElement.lang)),
+				cell(areaLabels[r.area] || r.area),
+				cell(difficultyLabels[r.difficulty] || r.difficulty),
+				cell(r.uniques + " / " + r.sets),
+				cell((r.runes || []).map(d => d.rune + " × " + d.qty).join(", "), r.hr_count ? "text-emerald-400" : ""),
+			);
+		}
+		return tr;
+	}));
+}
+
+function renderReport(report) {
+	columns = report.columns;
+	mapping = report.mapping || {};
+	renderMapping();
+	renderRows(report.rows);
+	const counts = [report.total, report.new, report.duplicate, report.errors];
+	document.getElementById("importSummary").textContent = counts.reduce((text, n) => text.replace("%d", n), i18n.summary)
+		+ (report.rows.length < report.total ? " " + i18n.partial : "");
+	const submit = document.getElementById("importSubmit");
+	submit.disabled = !report.dry_run || report.new === 0;
+	submit.classList.toggle("hidden", !report.dry_run);
+	document.getElementById("importResult").classList.remove("hidden");
+}
+
+importForm.onsubmit = e => {
+	e.preventDefault();
+	mapping = null;
+	preview();
+};
+importForm.elements.file.onchange = () => { mapping = null; };
+document.getElementById("importSubmit").onclick = runImport;
+importForm.elements.tz.value = Intl.DateTimeFormat().resolvedOptions().timeZone || "UTC";
@@ -0,0 +1,37 @@
+// Live updates over /events. The browser reconnects on its own after a drop.
+
+const live = new EventSource("/events");
+
+// Dashboard: counters of runs logged on another device or by a script.
+if (typeof paintTotals === "function") {
+	live.addEventListener("stats", e => paintTotals(JSON.parse(e.data)));
+}
+
+// Leaderboard: re-fetch this page (same filters) and swap the table in, at
+// most every few seconds however busy the night gets.
+const board = document.getElementById("leaderboardLive");
+let boardDue = false, boardBusy = false;
+
+function refreshBoard() {
+	if (boardBusy) {
+		boardDue = true;
+		return;
+	}
+	boardBusy = true;
+	fetch(location.href, {headers: {"Accept": "text/html"}})
+		.then(r => r.ok ? r.text() : Promise.reject(r.status))
+		.then(html => {
+			const fresh = new DOMParser().parseFromString(html, "text/html").getElementById("leaderboardLive");
+			if (fresh) board.replaceChildren(...fresh.childNodes);
+		})
+		.catch(() => {})
+		.finally(() => setTimeout(() => {
+			boardBusy = false;
+			if (boardDue) {
+				boardDue = false;
+				refreshBoard();
+			}
+		}, 5000));
+}
+
+if (board) live.addEventListener("leaderboard", refreshBoard);
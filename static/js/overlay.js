@@ -0,0 +1,42 @@
+// OBS overlay: paints the session state, ticks the timer and follows the
+// streamer's runs over /overlay/<token>/events.
+
+const root = document.getElementById("overlay");
+const lang = document.documentElement.lang;
+const numbers = new Intl.NumberFormat(lang);
+const rate = new Intl.NumberFormat(lang, {minimumFractionDigits: 1, maximumFractionDigits: 1});
+let state = overlay.state;
+
+const set = (name, text) => { root.querySelector(`[data-o="${name}"]`).textContent = text; };
+const pad = n => String(n).padStart(2, "0");
+
+// A session with no run for longer than the gap is over, even without an
+// event telling us so.
+function active() {
+	return state.active && Date.now() - Date.parse(state.last_run) <= overlay.gap * 1000;
+}
+
+function paint() {
+	const on = active();
+	root.classList.toggle("idle", !on);
+	const secs = on ? Math.max(0, Math.floor((Date.now() - Date.parse(state.start)) / 1000)) : 0;
+	set("timer", pad(Math.floor(secs / 3600)) + ":" + pad(Math.floor(secs % 3600 / 60)) + ":" + pad(secs % 60));
+	set("runs", numbers.format(on ? state.runs : 0));
+	set("hr", numbers.format(on ? state.hr : 0));
+	set("rph", on && secs >= 60 ? rate.format(state.runs * 3600 / secs) : "–");
+
+	const drop = root.querySelector('[data-o="drop"]');
+	if (on && state.last_drop) {
+		const img = document.createElement("img");
+		img.src = state.last_drop.icon;
+		img.alt = "";
+		drop.replaceChildren(img, state.last_drop.rune + (state.last_drop.qty > 1 ? " ×" + state.last_drop.qty : ""));
+	} else drop.textContent = "–";
+}
+
+new EventSource("/overlay/" + encodeURIComponent(overlay.token) + "/events").addEventListener("overlay", e => {
+	state = JSON.parse(e.data);
+	paint();
+});
+paint();
+setInterval(paint, 1000);
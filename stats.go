@@ -0,0 +1,48 @@
+package main
+
+import "time"
+
+// UserDailyStat is the per-user, per-day rollup of runs. The store keeps it
+// in step with the runs table on every write so the dashboard and stats
+// pages never have to scan a player's full history.
+type UserDailyStat struct {
+	UserID     uint   `gorm:"primaryKey;autoIncrement:false"`
+	Day        string `gorm:"primaryKey"` // UTC, 2006-01-02
+	Runs       int
+	HR         int
+	HRRuns     int // runs with at least one HR
+	Uniques    int
+	Sets       int
+	SessionSec int
+}
+
+const statDayLayout = "2006-01-02"
+
+func statDay(t time.Time) string { return t.UTC().Format(statDayLayout) }
+
+// dailyDelta is run's contribution to its day's rollup, negated when sign is
+// -1 so removing a run is the same upsert as adding one.
+func dailyDelta(run Run, sign int) UserDailyStat {
+	d := UserDailyStat{
+		UserID:     run.UserID,
+		Day:        statDay(run.Timestamp),
+		Runs:       sign,
+		HR:         sign * run.HRCount,
+		Uniques:    sign * run.Uniques,
+		Sets:       sign * run.Sets,
+		SessionSec: sign * run.SessionSec,
+	}
+	if run.HRCount > 0 {
+		d.HRRuns = sign
+	}
+	return d
+}
+
+func (d *UserDailyStat) add(o UserDailyStat) {
+	d.Runs += o.Runs
+	d.HR += o.HR
+	d.HRRuns += o.HRRuns
+	d.Uniques += o.Uniques
+	d.Sets += o.Sets
+	d.SessionSec += o.SessionSec
+}
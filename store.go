@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// ErrNotFound is returned by Store lookups that match no row.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned by writes a unique constraint rejected.
+var ErrConflict = errors.New("conflict")
+
+// Store is everything the HTTP handlers need from persistence. gormStore backs
+// production; memStore keeps the same semantics in memory for tests and local
+// experiments without a database.
+type Store interface {
+	// Ping reports whether the backing database answers.
+	Ping(ctx context.Context) error
+	// WithContext returns the store bound to ctx: its queries are cancelled
+	// with the request and logged with the request's IDs.
+	WithContext(ctx context.Context) Store
+	UserStore
+	RunStore
+	StatsStore
+	AuthStore
+	FlagStore
+	WebhookStore
+	TeamStore
+}
+
+type UserStore interface {
+	UserByID(id uint) (User, error)
+	UserByUsername(username string) (User, error)
+	UserByOverlayToken(token string) (User, error)
+	CreateUser(user *User) error
+	UpdateUser(id uint, fields map[string]any) error
+	UsernameTaken(username string, exceptID uint) (bool, error)
+	ListUsers(search string, limit int) ([]User, error)
+	CountUsers() (int64, error)
+	// DeleteUser removes the user with their runs, drops, flags, recovery
+	// codes, linked identities and chat accounts, webhooks, and team
+	// membership and requests. Handing over a team they own is up to the
+	// caller.
+	DeleteUser(id uint) error
+}
+
+type RunStore interface {
+	// CreateRun stores run and its drops, filling in their IDs.
+	CreateRun(run *Run, drops []RuneDrop) error
+	// CreateRuns is CreateRun for a batch in one transaction; drops[i]
+	// belong to runs[i].
+	CreateRuns(runs []Run, drops [][]RuneDrop) error
+	// ExternalRunIDs reports which of ids the user's runs already carry.
+	ExternalRunIDs(userID uint, ids []string) (map[string]bool, error)
+	RunByID(id uint) (Run, error)
+	RunsByUser(userID uint) ([]Run, error)
+	DropsByUser(userID uint) ([]RuneDrop, error)
+	DropsByRuns(runIDs []uint) ([]RuneDrop, error)
+	// FirstDrops returns those of runes that none of the user's runs other
+	// than runID has dropped.
+	FirstDrops(userID, runID uint, runes []string) ([]string, error)
+	ListRuns(filter RunFilter) ([]RunWithUser, error)
+	// RunsPage returns up to filter.Limit runs oldest first, starting after
+	// the run at cursor (zero Run = from the start), so a whole history can
+	// be walked without loading it at once.
+	RunsPage(filter RunFilter, cursor Run) ([]Run, error)
+	SetRunHidden(id uint, hidden bool) error
+	DeleteRun(id uint) error
+	CountRunsSince(userID uint, since time.Time) (int64, error)
+	RunCountsByUser() (map[uint]int, error)
+}
+
+// StatsStore answers from the UserDailyStat rollup where it can; every
+// RunStore write keeps that rollup in step.
+type StatsStore interface {
+	UserTotals(userID uint) (UserTotals, error)
+	// TotalsByUser is UserTotals for several users at once; users without
+	// runs are missing from the map.
+	TotalsByUser(userIDs []uint) (map[uint]UserTotals, error)
+	// DailyStats returns the user's rollup rows from day since onwards, oldest first.
+	DailyStats(userID uint, since time.Time) ([]UserDailyStat, error)
+	// Leaderboard ranks players by HR over visible, unflagged runs of
+	// unbanned users. limit <= 0 returns every player.
+	Leaderboard(f LeaderboardFilter, limit int) ([]LeaderboardRow, error)
+	// RecentAreaHR sums HR over the user's last window runs in area/difficulty.
+	RecentAreaHR(userID uint, area, difficulty string, window int) (RunHR, error)
+	// AreaBaseline sums HR over visible, unflagged runs by everyone else.
+	AreaBaseline(excludeUserID uint, area, difficulty string) (RunHR, error)
+}
+
+type AuthStore interface {
+	ReplaceRecoveryCodes(userID uint, codes []RecoveryCode) error
+	UnusedRecoveryCodes(userID uint) ([]RecoveryCode, error)
+	// UseRecoveryCode marks the code used; false means someone beat us to it.
+	UseRecoveryCode(id uint, at time.Time) (bool, error)
+	// UseTOTPStep records an accepted TOTP time step; false means that step
+	// or a later one was already used.
+	UseTOTPStep(userID uint, step int64) (bool, error)
+	// TwoFAFailure counts a wrong second-factor code and returns the count
+	// since the first failure less than window before now; an older one
+	// starts the count again.
+	TwoFAFailure(userID uint, now time.Time, window time.Duration) (int, error)
+	ClearTwoFAFailures(userID uint) error
+	ResetTwoFA(userID uint) error
+
+	IdentityBySubject(provider, subject string) (OIDCIdentity, error)
+	IdentitiesByUser(userID uint) ([]OIDCIdentity, error)
+	CreateIdentity(ident *OIDCIdentity) error
+	// CreateUserWithIdentity registers an OIDC-only account: both rows or
+	// neither. A taken username or identity fails with ErrConflict.
+	CreateUserWithIdentity(user *User, ident *OIDCIdentity) error
+	DeleteIdentity(userID uint, provider string) error
+
+	ChatLinkByChatUser(platform, chatUserID string) (ChatLink, error)
+	ChatLinksByUser(userID uint) ([]ChatLink, error)
+	// LinkChat stores link, replacing whatever account the chat user was
+	// linked to before.
+	LinkChat(link *ChatLink) error
+	DeleteChatLink(userID, id uint) error
+}
+
+type FlagStore interface {
+	// FlagRun stores the flags and marks the run as awaiting review.
+	FlagRun(runID uint, flags []RunFlag) error
+	OpenFlags(runIDs []uint) ([]RunFlag, error)
+	ResolveFlags(runID uint, resolution string, reviewerID uint) error
+}
+
+// WebhookStore keeps webhook subscriptions and their delivery log. A
+// delivery is queued as pending and claimed by whichever instance gets to it
+// first; a claim is a lease, so a delivery whose sender died is retried.
+type WebhookStore interface {
+	// WebhooksFor returns the webhooks owned by any of ownerIDs, oldest
+	// first; owner 0 holds the global ones.
+	WebhooksFor(ownerIDs []uint) ([]Webhook, error)
+	WebhookByID(id uint) (Webhook, error)
+	CreateWebhook(hook *Webhook) error
+	// DeleteWebhook removes the webhook with its delivery log.
+	DeleteWebhook(id uint) error
+	QueueDeliveries(deliveries []WebhookDelivery) error
+	// ClaimDeliveries leases up to limit pending deliveries that are due at
+	// now, pushing their next attempt lease into the future.
+	ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	// SaveDelivery records the outcome of an attempt.
+	SaveDelivery(d *WebhookDelivery) error
+	// RecentDeliveries returns the newest limit deliveries of any of webhookIDs.
+	RecentDeliveries(webhookIDs []uint, limit int) ([]WebhookDelivery, error)
+	// PruneDeliveries drops finished deliveries created before before.
+	PruneDeliveries(before time.Time) (int64, error)
+}
+
+// TeamStore keeps teams, who is in them and the pending invites and join
+// requests. A player belongs to at most one team.
+type TeamStore interface {
+	// CreateTeam stores team with ownerID as its owner, dropping the
+	// owner's pending invites and requests.
+	CreateTeam(team *Team, ownerID uint) error
+	TeamByID(id uint) (Team, error)
+	TeamNameTaken(name string) (bool, error)
+	// ListTeams returns every team by name.
+	ListTeams() ([]Team, error)
+	// DeleteTeam removes the team with its members and requests.
+	DeleteTeam(id uint) error
+
+	TeamMembers(teamID uint) ([]TeamMemberWithUser, error)
+	// TeamMemberships returns every membership of every team.
+	TeamMemberships() ([]TeamMember, error)
+	MembershipOf(userID uint) (TeamMember, error)
+	// AddTeamMember stores m, dropping the user's other invites and requests.
+	AddTeamMember(m *TeamMember) error
+	SetTeamRole(teamID, userID uint, role string) error
+	// TransferTeam makes toID the owner and fromID an officer in one step.
+	TransferTeam(teamID, fromID, toID uint) error
+	RemoveTeamMember(teamID, userID uint) error
+
+	// TeamRequests returns pending invites and join requests, oldest first,
+	// of teamID and/or userID; zero matches any.
+	TeamRequests(teamID, userID uint) ([]TeamRequestWithNames, error)
+	TeamRequestByID(id uint) (TeamRequest, error)
+	CreateTeamRequest(r *TeamRequest) error
+	DeleteTeamRequest(id uint) error
+}
+
+// RunFilter narrows ListRuns. Zero values mean "no filter"; runs come back
+// by HR descending unless Recent is set.
+type RunFilter struct {
+	UserID      uint
+	HiddenOnly  bool
+	FlaggedOnly bool
+	Recent      bool
+	Since       time.Time // only runs at or after Since
+	Until       time.Time // only runs before Until
+	Area        string
+	Limit       int
+}
+
+type RunWithUser struct {
+	Run
+	Username string
+}
+
+type UserTotals struct {
+	Runs    int64
+	HR      int64
+	HRRuns  int64
+	Uniques int64
+	Sets    int64
+}
+
+// LeaderboardFilter selects a leaderboard view; empty fields match everything.
+type LeaderboardFilter struct {
+	Area       string
+	Difficulty string
+}
+
+type TeamMemberWithUser struct {
+	TeamMember
+	Username string
+}
+
+type TeamRequestWithNames struct {
+	TeamRequest
+	Username string
+	TeamName string
+}
+
+type LeaderboardRow struct {
+	UserID   uint
+	Username string
+	TotalHR  int
+	Runs     int
+	AvgHR    float64
+}
+
+type RunHR struct {
+	Runs int64
+	HR   int64
+}
+
+// firstDrops returns the runes that are not in seen, once each, in the
+// order given.
+func firstDrops(runes, seen []string) []string {
+	var first []string
+	for _, r := range runes {
+		if !slices.Contains(seen, r) && !slices.Contains(first, r) {
+			first = append(first, r)
+		}
+	}
+	return first
+}
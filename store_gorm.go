@@ -0,0 +1,691 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type gormStore struct {
+	db *gorm.DB
+}
+
+func newGormStore(db *gorm.DB) *gormStore { return &gormStore{db: db} }
+
+func (s *gormStore) WithContext(ctx context.Context) Store {
+	return newGormStore(s.db.WithContext(ctx))
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// conflict maps a unique-constraint violation to ErrConflict.
+func (s *gormStore) conflict(err error) error {
+	if t, ok := s.db.Dialector.(gorm.ErrorTranslator); ok && errors.Is(t.Translate(err), gorm.ErrDuplicatedKey) {
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	return err
+}
+
+func (s *gormStore) Ping(ctx context.Context) error {
+	pool, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return pool.PingContext(ctx)
+}
+
+// ==================== USERS ====================
+func (s *gormStore) UserByID(id uint) (User, error) {
+	var user User
+	return user, notFound(s.db.First(&user, id).Error)
+}
+
+func (s *gormStore) UserByUsername(username string) (User, error) {
+	var user User
+	return user, notFound(s.db.Where("username = ?", username).First(&user).Error)
+}
+
+func (s *gormStore) UserByOverlayToken(token string) (User, error) {
+	var user User
+	if token == "" {
+		return user, ErrNotFound
+	}
+	return user, notFound(s.db.Where("overlay_token = ?", token).First(&user).Error)
+}
+
+func (s *gormStore) CreateUser(user *User) error { return s.db.Create(user).Error }
+
+func (s *gormStore) UpdateUser(id uint, fields map[string]any) error {
+	return s.db.Model(&User{}).Where("id = ?", id).Updates(fields).Error
+}
+
+func (s *gormStore) UsernameTaken(username string, exceptID uint) (bool, error) {
+	var n int64
+	err := s.db.Model(&User{}).Where("username = ? AND id <> ?", username, exceptID).Count(&n).Error
+	return n > 0, err
+}
+
+func (s *gormStore) ListUsers(search string, limit int) ([]User, error) {
+	query := s.db.Model(&User{}).Order("id").Limit(limit)
+	if search != "" {
+		query = query.Where("LOWER(username) LIKE ?", "%"+strings.ToLower(search)+"%")
+	}
+	var users []User
+	return users, query.Find(&users).Error
+}
+
+func (s *gormStore) CountUsers() (int64, error) {
+	var n int64
+	return n, s.db.Model(&User{}).Count(&n).Error
+}
+
+func (s *gormStore) DeleteUser(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		runIDs := tx.Model(&Run{}).Select("id").Where("user_id = ?", id)
+		for _, model := range []any{&RuneDrop{}, &RunFlag{}} {
+			if err := tx.Where("run_id IN (?)", runIDs).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		hookIDs := tx.Model(&Webhook{}).Select("id").Where("user_id = ?", id)
+		if err := tx.Where("webhook_id IN (?)", hookIDs).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []any{&Run{}, &UserDailyStat{}, &RecoveryCode{}, &OIDCIdentity{}, &ChatLink{}, &Webhook{}, &TeamMember{}, &TeamRequest{}} {
+			if err := tx.Where("user_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&User{}, id).Error
+	})
+}
+
+// ==================== RUNS ====================
+func (s *gormStore) CreateRun(run *Run, drops []RuneDrop) error {
+	return s.db.Transaction(func(tx *gorm.DB) error { return createRun(tx, run, drops) })
+}
+
+func (s *gormStore) CreateRuns(runs []Run, drops [][]RuneDrop) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for i := range runs {
+			if err := createRun(tx, &runs[i], drops[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func createRun(tx *gorm.DB, run *Run, drops []RuneDrop) error {
+	if err := tx.Create(run).Error; err != nil {
+		return err
+	}
+	if err := bumpDailyStats(tx, dailyDelta(*run, 1)); err != nil {
+		return err
+	}
+	for i := range drops {
+		drops[i].RunID = run.ID
+	}
+	if len(drops) == 0 {
+		return nil
+	}
+	return tx.Create(&drops).Error
+}
+
+func (s *gormStore) ExternalRunIDs(userID uint, ids []string) (map[string]bool, error) {
+	found := map[string]bool{}
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), 500)]
+		ids = ids[len(chunk):]
+		var have []string
+		if err := s.db.Model(&Run{}).Where("user_id = ? AND external_id IN ?", userID, chunk).Pluck("external_id", &have).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range have {
+			found[id] = true
+		}
+	}
+	return found, nil
+}
+
+func (s *gormStore) RunByID(id uint) (Run, error) {
+	var run Run
+	return run, notFound(s.db.First(&run, id).Error)
+}
+
+func (s *gormStore) RunsByUser(userID uint) ([]Run, error) {
+	var runs []Run
+	return runs, s.db.Where("user_id = ?", userID).Order("timestamp").Find(&runs).Error
+}
+
+func (s *gormStore) DropsByUser(userID uint) ([]RuneDrop, error) {
+	var drops []RuneDrop
+	err := s.db.Where("run_id IN (?)", s.db.Model(&Run{}).Select("id").Where("user_id = ?", userID)).Order("id").Find(&drops).Error
+	return drops, err
+}
+
+func (s *gormStore) DropsByRuns(runIDs []uint) ([]RuneDrop, error) {
+	var drops []RuneDrop
+	if len(runIDs) == 0 {
+		return drops, nil
+	}
+	return drops, s.db.Where("run_id IN ?", runIDs).Order("id").Find(&drops).Error
+}
+
+func (s *gormStore) FirstDrops(userID, runID uint, runes []string) ([]string, error) {
+	if len(runes) == 0 {
+		return nil, nil
+	}
+	var seen []string
+	err := s.db.Model(&RuneDrop{}).Distinct("rune").
+		Where("rune IN ? AND run_id IN (?)", runes, s.db.Model(&Run{}).Select("id").Where("user_id = ? AND id <> ?", userID, runID)).
+		Pluck("rune", &seen).Error
+	if err != nil {
+		return nil, err
+	}
+	return firstDrops(runes, seen), nil
+}
+
+func (s *gormStore) ListRuns(f RunFilter) ([]RunWithUser, error) {
+	query := s.db.Table("runs r").Select("r.*, u.username").Joins("JOIN users u ON u.id = r.user_id")
+	if f.UserID != 0 {
+		query = query.Where("r.user_id = ?", f.UserID)
+	}
+	if f.HiddenOnly {
+		query = query.Where("r.hidden = ?", true)
+	}
+	if f.FlaggedOnly {
+		query = query.Where("r.flagged = ?", true)
+	}
+	query = f.bounds(query, "r.")
+	if f.Recent {
+		query = query.Order("r.timestamp DESC")
+	} else {
+		query = query.Order("r.hr_count DESC, r.timestamp DESC")
+	}
+	var runs []RunWithUser
+	return runs, query.Limit(f.Limit).Scan(&runs).Error
+}
+
+func (s *gormStore) RunsPage(f RunFilter, cursor Run) ([]Run, error) {
+	query := f.bounds(s.db.Model(&Run{}), "")
+	if f.UserID != 0 {
+		query = query.Where("user_id = ?", f.UserID)
+	}
+	if cursor.ID != 0 {
+		query = query.Where("(timestamp > ? OR (timestamp = ? AND id > ?))", cursor.Timestamp, cursor.Timestamp, cursor.ID)
+	}
+	if f.Limit > 0 {
+		query = query.Limit(f.Limit)
+	}
+	var runs []Run
+	return runs, query.Order("timestamp, id").Find(&runs).Error
+}
+
+// bounds applies the time and area filters shared by ListRuns and RunsPage;
+// prefix qualifies the columns when the runs table is aliased.
+func (f RunFilter) bounds(query *gorm.DB, prefix string) *gorm.DB {
+	if !f.Since.IsZero() {
+		query = query.Where(prefix+"timestamp >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		query = query.Where(prefix+"timestamp < ?", f.Until)
+	}
+	if f.Area != "" {
+		query = query.Where(prefix+"area = ?", f.Area)
+	}
+	return query
+}
+
+func (s *gormStore) SetRunHidden(id uint, hidden bool) error {
+	return s.db.Model(&Run{}).Where("id = ?", id).Update("hidden", hidden).Error
+}
+
+func (s *gormStore) DeleteRun(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var run Run
+		if err := tx.First(&run, id).Error; err != nil {
+			return notFound(err)
+		}
+		if err := bumpDailyStats(tx, dailyDelta(run, -1)); err != nil {
+			return err
+		}
+		for _, model := range []any{&RuneDrop{}, &RunFlag{}} {
+			if err := tx.Where("run_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&Run{}, id).Error
+	})
+}
+
+func (s *gormStore) CountRunsSince(userID uint, since time.Time) (int64, error) {
+	var n int64
+	err := s.db.Model(&Run{}).Where("user_id = ? AND timestamp > ?", userID, since).Count(&n).Error
+	return n, err
+}
+
+func (s *gormStore) RunCountsByUser() (map[uint]int, error) {
+	var rows []struct {
+		UserID uint
+		N      int
+	}
+	if err := s.db.Model(&Run{}).Select("user_id, COUNT(*) AS n").Group("user_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[uint]int, len(rows))
+	for _, r := range rows {
+		counts[r.UserID] = r.N
+	}
+	return counts, nil
+}
+
+// ==================== STATS ====================
+func (s *gormStore) UserTotals(userID uint) (UserTotals, error) {
+	var t UserTotals
+	err := s.db.Model(&UserDailyStat{}).
+		Select("COALESCE(SUM(runs),0) AS runs, COALESCE(SUM(hr),0) AS hr, COALESCE(SUM(hr_runs),0) AS hr_runs, COALESCE(SUM(uniques),0) AS uniques, COALESCE(SUM(sets),0) AS sets").
+		Where("user_id = ?", userID).Scan(&t).Error
+	return t, err
+}
+
+func (s *gormStore) TotalsByUser(userIDs []uint) (map[uint]UserTotals, error) {
+	var rows []struct {
+		UserID uint
+		UserTotals
+	}
+	totals := make(map[uint]UserTotals, len(userIDs))
+	if len(userIDs) == 0 {
+		return totals, nil
+	}
+	err := s.db.Model(&UserDailyStat{}).
+		Select("user_id, SUM(runs) AS runs, SUM(hr) AS hr, SUM(hr_runs) AS hr_runs, SUM(uniques) AS uniques, SUM(sets) AS sets").
+		Where("user_id IN ?", userIDs).Group("user_id").Scan(&rows).Error
+	for _, r := range rows {
+		totals[r.UserID] = r.UserTotals
+	}
+	return totals, err
+}
+
+func (s *gormStore) DailyStats(userID uint, since time.Time) ([]UserDailyStat, error) {
+	var days []UserDailyStat
+	return days, s.db.Where("user_id = ? AND day >= ?", userID, statDay(since)).Order("day").Find(&days).Error
+}
+
+// bumpDailyStats adds delta to its (user, day) rollup row, creating it on the
+// first run of the day and dropping it once the last run is gone.
+func bumpDailyStats(tx *gorm.DB, delta UserDailyStat) error {
+	add := func(col string, n int) clause.Expr { return gorm.Expr("user_daily_stats."+col+" + ?", n) }
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"runs":        add("runs", delta.Runs),
+			"hr":          add("hr", delta.HR),
+			"hr_runs":     add("hr_runs", delta.HRRuns),
+			"uniques":     add("uniques", delta.Uniques),
+			"sets":        add("sets", delta.Sets),
+			"session_sec": add("session_sec", delta.SessionSec),
+		}),
+	}).Create(&delta).Error
+	if err != nil || delta.Runs > 0 {
+		return err
+	}
+	return tx.Where("user_id = ? AND day = ? AND runs <= 0", delta.UserID, delta.Day).Delete(&UserDailyStat{}).Error
+}
+
+func (s *gormStore) Leaderboard(f LeaderboardFilter, limit int) ([]LeaderboardRow, error) {
+	query := s.db.Table("runs r").
+		Select("u.id AS user_id, u.username, SUM(r.hr_count) AS total_hr, COUNT(*) AS runs, AVG(r.hr_count) AS avg_hr").
+		Joins("JOIN users u ON r.user_id = u.id").
+		Where("r.hidden = ? AND r.flagged = ? AND u.banned = ?", false, false, false).
+		Group("u.id, u.username").Order("total_hr DESC, u.username")
+	if f.Area != "" {
+		query = query.Where("r.area = ?", f.Area)
+	}
+	if f.Difficulty != "" {
+		query = query.Where("r.difficulty = ?", f.Difficulty)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var rows []LeaderboardRow
+	return rows, query.Scan(&rows).Error
+}
+
+func (s *gormStore) RecentAreaHR(userID uint, area, difficulty string, window int) (RunHR, error) {
+	var agg RunHR
+	err := s.db.Raw(`SELECT COUNT(*) AS runs, COALESCE(SUM(hr_count),0) AS hr FROM (
+			SELECT hr_count FROM runs WHERE user_id = ? AND area = ? AND difficulty = ? ORDER BY timestamp DESC LIMIT ?
+		) recent`, userID, area, difficulty, window).Scan(&agg).Error
+	return agg, err
+}
+
+func (s *gormStore) AreaBaseline(excludeUserID uint, area, difficulty string) (RunHR, error) {
+	var agg RunHR
+	err := s.db.Model(&Run{}).Select("COUNT(*) AS runs, COALESCE(SUM(hr_count),0) AS hr").
+		Where("user_id <> ? AND area = ? AND difficulty = ? AND flagged = ? AND hidden = ?", excludeUserID, area, difficulty, false, false).
+		Scan(&agg).Error
+	return agg, err
+}
+
+// ==================== 2FA / OIDC ====================
+func (s *gormStore) ReplaceRecoveryCodes(userID uint, codes []RecoveryCode) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil || len(codes) == 0 {
+			return err
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (s *gormStore) UnusedRecoveryCodes(userID uint) ([]RecoveryCode, error) {
+	var codes []RecoveryCode
+	return codes, s.db.Where("user_id = ? AND used_at IS NULL", userID).Find(&codes).Error
+}
+
+func (s *gormStore) UseRecoveryCode(id uint, at time.Time) (bool, error) {
+	res := s.db.Model(&RecoveryCode{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", &at)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (s *gormStore) UseTOTPStep(userID uint, step int64) (bool, error) {
+	res := s.db.Model(&User{}).Where("id = ? AND totp_last_step < ?", userID, step).Update("totp_last_step", step)
+	return res.RowsAffected == 1, res.Error
+}
+
+// TwoFAFailure is a single UPDATE so concurrent wrong codes, on any
+// instance, all count.
+func (s *gormStore) TwoFAFailure(userID uint, now time.Time, window time.Duration) (int, error) {
+	since := now.Add(-window).Unix()
+	err := s.db.Exec(`UPDATE users SET
+			two_fa_failures = CASE WHEN two_fa_failed_at > ? THEN two_fa_failures + 1 ELSE 1 END,
+			two_fa_failed_at = CASE WHEN two_fa_failed_at > ? THEN two_fa_failed_at ELSE ? END
+		WHERE id = ?`, since, since, now.Unix(), userID).Error
+	if err != nil {
+		return 0, err
+	}
+	var user User
+	err = s.db.Select("two_fa_failures").First(&user, userID).Error
+	return user.TwoFAFailures, notFound(err)
+}
+
+func (s *gormStore) ClearTwoFAFailures(userID uint) error {
+	return s.db.Model(&User{}).Where("id = ?", userID).Update("two_fa_failures", 0).Error
+}
+
+func (s *gormStore) ResetTwoFA(userID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]any{"totp_enabled": false, "totp_secret": "", "two_fa_failures": 0}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+func (s *gormStore) IdentityBySubject(provider, subject string) (OIDCIdentity, error) {
+	var ident OIDCIdentity
+	return ident, notFound(s.db.Where("provider = ? AND subject = ?", provider, subject).First(&ident).Error)
+}
+
+func (s *gormStore) IdentitiesByUser(userID uint) ([]OIDCIdentity, error) {
+	var idents []OIDCIdentity
+	return idents, s.db.Where("user_id = ?", userID).Order("provider").Find(&idents).Error
+}
+
+func (s *gormStore) CreateIdentity(ident *OIDCIdentity) error { return s.db.Create(ident).Error }
+
+func (s *gormStore) CreateUserWithIdentity(user *User, ident *OIDCIdentity) error {
+	return s.conflict(s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		ident.UserID = user.ID
+		return tx.Create(ident).Error
+	}))
+}
+
+func (s *gormStore) DeleteIdentity(userID uint, provider string) error {
+	return s.db.Where("user_id = ? AND provider = ?", userID, provider).Delete(&OIDCIdentity{}).Error
+}
+
+func (s *gormStore) ChatLinkByChatUser(platform, chatUserID string) (ChatLink, error) {
+	var link ChatLink
+	return link, notFound(s.db.Where("platform = ? AND chat_user_id = ?", platform, chatUserID).First(&link).Error)
+}
+
+func (s *gormStore) ChatLinksByUser(userID uint) ([]ChatLink, error) {
+	var links []ChatLink
+	return links, s.db.Where("user_id = ?", userID).Order("id").Find(&links).Error
+}
+
+func (s *gormStore) LinkChat(link *ChatLink) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("platform = ? AND chat_user_id = ?", link.Platform, link.ChatUserID).Delete(&ChatLink{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(link).Error
+	})
+}
+
+func (s *gormStore) DeleteChatLink(userID, id uint) error {
+	return s.db.Where("user_id = ? AND id = ?", userID, id).Delete(&ChatLink{}).Error
+}
+
+// ==================== FLAGS ====================
+func (s *gormStore) FlagRun(runID uint, flags []RunFlag) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if len(flags) > 0 {
+			if err := tx.Create(&flags).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&Run{}).Where("id = ?", runID).Update("flagged", true).Error
+	})
+}
+
+func (s *gormStore) OpenFlags(runIDs []uint) ([]RunFlag, error) {
+	var flags []RunFlag
+	if len(runIDs) == 0 {
+		return flags, nil
+	}
+	return flags, s.db.Where("run_id IN ? AND resolution = ?", runIDs, "").Order("id").Find(&flags).Error
+}
+
+func (s *gormStore) ResolveFlags(runID uint, resolution string, reviewerID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&RunFlag{}).Where("run_id = ? AND resolution = ?", runID, "").
+			Updates(map[string]any{"resolution": resolution, "reviewed_by": reviewerID}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Run{}).Where("id = ?", runID).Update("flagged", false).Error
+	})
+}
+
+// ==================== WEBHOOKS ====================
+func (s *gormStore) WebhooksFor(ownerIDs []uint) ([]Webhook, error) {
+	var hooks []Webhook
+	if len(ownerIDs) == 0 {
+		return hooks, nil
+	}
+	return hooks, s.db.Where("user_id IN ?", ownerIDs).Order("id").Find(&hooks).Error
+}
+
+func (s *gormStore) WebhookByID(id uint) (Webhook, error) {
+	var hook Webhook
+	return hook, notFound(s.db.First(&hook, id).Error)
+}
+
+func (s *gormStore) CreateWebhook(hook *Webhook) error { return s.db.Create(hook).Error }
+
+func (s *gormStore) DeleteWebhook(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Webhook{}, id).Error
+	})
+}
+
+func (s *gormStore) QueueDeliveries(deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return s.db.Create(&deliveries).Error
+}
+
+func (s *gormStore) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	var due []WebhookDelivery
+	err := s.db.Where("status = ? AND next_attempt_at <= ?", deliveryPending, now).Order("next_attempt_at, id").Limit(limit).Find(&due).Error
+	if err != nil {
+		return nil, err
+	}
+	claimed := due[:0]
+	for _, d := range due {
+		// Another instance that claimed it first has moved next_attempt_at
+		// past now, so this update then matches nothing.
+		res := s.db.Model(&WebhookDelivery{}).Where("id = ? AND status = ? AND next_attempt_at <= ?", d.ID, deliveryPending, now).
+			Update("next_attempt_at", now.Add(lease))
+		if res.Error != nil {
+			return claimed, res.Error
+		}
+		if res.RowsAffected == 1 {
+			d.NextAttemptAt = now.Add(lease)
+			claimed = append(claimed, d)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *gormStore) SaveDelivery(d *WebhookDelivery) error { return s.db.Save(d).Error }
+
+func (s *gormStore) RecentDeliveries(webhookIDs []uint, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	if len(webhookIDs) == 0 {
+		return deliveries, nil
+	}
+	return deliveries, s.db.Where("webhook_id IN ?", webhookIDs).Order("id DESC").Limit(limit).Find(&deliveries).Error
+}
+
+func (s *gormStore) PruneDeliveries(before time.Time) (int64, error) {
+	res := s.db.Where("status <> ? AND created_at < ?", deliveryPending, before).Delete(&WebhookDelivery{})
+	return res.RowsAffected, res.Error
+}
+
+// ==================== TEAMS ====================
+func (s *gormStore) CreateTeam(team *Team, ownerID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(team).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", ownerID).Delete(&TeamRequest{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&TeamMember{TeamID: team.ID, UserID: ownerID, Role: teamOwner, CreatedAt: team.CreatedAt}).Error
+	})
+}
+
+func (s *gormStore) TeamByID(id uint) (Team, error) {
+	var team Team
+	return team, notFound(s.db.First(&team, id).Error)
+}
+
+func (s *gormStore) TeamNameTaken(name string) (bool, error) {
+	var n int64
+	err := s.db.Model(&Team{}).Where("name = ?", name).Count(&n).Error
+	return n > 0, err
+}
+
+func (s *gormStore) ListTeams() ([]Team, error) {
+	var teams []Team
+	return teams, s.db.Order("name").Find(&teams).Error
+}
+
+func (s *gormStore) DeleteTeam(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []any{&TeamRequest{}, &TeamMember{}} {
+			if err := tx.Where("team_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&Team{}, id).Error
+	})
+}
+
+func (s *gormStore) TeamMembers(teamID uint) ([]TeamMemberWithUser, error) {
+	var members []TeamMemberWithUser
+	return members, s.db.Table("team_members m").Select("m.*, u.username").Joins("JOIN users u ON u.id = m.user_id").
+		Where("m.team_id = ?", teamID).Order("m.id").Scan(&members).Error
+}
+
+func (s *gormStore) TeamMemberships() ([]TeamMember, error) {
+	var members []TeamMember
+	return members, s.db.Order("id").Find(&members).Error
+}
+
+func (s *gormStore) MembershipOf(userID uint) (TeamMember, error) {
+	var m TeamMember
+	return m, notFound(s.db.Where("user_id = ?", userID).First(&m).Error)
+}
+
+func (s *gormStore) AddTeamMember(m *TeamMember) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", m.UserID).Delete(&TeamRequest{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(m).Error
+	})
+}
+
+func (s *gormStore) SetTeamRole(teamID, userID uint, role string) error {
+	return s.db.Model(&TeamMember{}).Where("team_id = ? AND user_id = ?", teamID, userID).Update("role", role).Error
+}
+
+func (s *gormStore) TransferTeam(teamID, fromID, toID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range []TeamMember{{UserID: fromID, Role: teamOfficer}, {UserID: toID, Role: teamOwner}} {
+			res := tx.Model(&TeamMember{}).Where("team_id = ? AND user_id = ?", teamID, m.UserID).Update("role", m.Role)
+			if res.Error != nil {
+				return res.Error
+			} else if res.RowsAffected == 0 {
+				return ErrNotFound
+			}
+		}
+		return nil
+	})
+}
+
+func (s *gormStore) RemoveTeamMember(teamID, userID uint) error {
+	return s.db.Where("team_id = ? AND user_id = ?", teamID, userID).Delete(&TeamMember{}).Error
+}
+
+func (s *gormStore) TeamRequests(teamID, userID uint) ([]TeamRequestWithNames, error) {
+	query := s.db.Table("team_requests r").Select("r.*, u.username, t.name AS team_name").
+		Joins("JOIN users u ON u.id = r.user_id").Joins("JOIN teams t ON t.id = r.team_id")
+	if teamID != 0 {
+		query = query.Where("r.team_id = ?", teamID)
+	}
+	if userID != 0 {
+		query = query.Where("r.user_id = ?", userID)
+	}
+	var requests []TeamRequestWithNames
+	return requests, query.Order("r.id").Scan(&requests).Error
+}
+
+func (s *gormStore) TeamRequestByID(id uint) (TeamRequest, error) {
+	var r TeamRequest
+	return r, notFound(s.db.First(&r, id).Error)
+}
+
+func (s *gormStore) CreateTeamRequest(r *TeamRequest) error { return s.db.Create(r).Error }
+
+func (s *gormStore) DeleteTeamRequest(id uint) error { return s.db.Delete(&TeamRequest{}, id).Error }
@@ -0,0 +1,1186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memStore is an in-memory Store with the same semantics as gormStore. It
+// exists so handler and stats logic can be exercised without a database.
+type memStore struct {
+	mu     sync.Mutex
+	nextID uint
+
+	users    map[uint]*User
+	runs     map[uint]*Run
+	drops    map[uint]*RuneDrop
+	codes    map[uint]*RecoveryCode
+	idents   map[uint]*OIDCIdentity
+	chats    map[uint]*ChatLink
+	runFlags map[uint]*RunFlag
+	hooks    map[uint]*Webhook
+	sends    map[uint]*WebhookDelivery
+	teams    map[uint]*Team
+	members  map[uint]*TeamMember
+	teamReqs map[uint]*TeamRequest
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		users:    map[uint]*User{},
+		runs:     map[uint]*Run{},
+		drops:    map[uint]*RuneDrop{},
+		codes:    map[uint]*RecoveryCode{},
+		idents:   map[uint]*OIDCIdentity{},
+		chats:    map[uint]*ChatLink{},
+		runFlags: map[uint]*RunFlag{},
+		hooks:    map[uint]*Webhook{},
+		sends:    map[uint]*WebhookDelivery{},
+		teams:    map[uint]*Team{},
+		members:  map[uint]*TeamMember{},
+		teamReqs: map[uint]*TeamRequest{},
+	}
+}
+
+func (s *memStore) Ping(context.Context) error { return nil }
+
+// WithContext returns s: nothing in memory waits or logs.
+func (s *memStore) WithContext(context.Context) Store { return s }
+
+func (s *memStore) id() uint {
+	s.nextID++
+	return s.nextID
+}
+
+// sortedIDs returns the keys of m in ascending order so results are as
+// deterministic as an ORDER BY id.
+func sortedIDs[T any](m map[uint]*T) []uint {
+	ids := make([]uint, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// ==================== USERS ====================
+func (s *memStore) UserByID(id uint) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[id]; ok {
+		return *u, nil
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memStore) UserByUsername(username string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == username {
+			return *u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memStore) UserByOverlayToken(token string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if token != "" && u.OverlayToken == token {
+			return *u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memStore) CreateUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == user.Username {
+			return fmt.Errorf("username %q already exists", user.Username)
+		}
+	}
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+	user.ID = s.id()
+	u := *user
+	s.users[u.ID] = &u
+	return nil
+}
+
+func (s *memStore) UpdateUser(id uint, fields map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	next := *u
+	for k, v := range fields {
+		switch k {
+		case "username":
+			for _, other := range s.users {
+				if other.ID != id && other.Username == v.(string) {
+					return fmt.Errorf("username %q already exists", v)
+				}
+			}
+			next.Username = v.(string)
+		case "password":
+			next.Password = v.(string)
+		case "role":
+			next.Role = v.(string)
+		case "banned":
+			next.Banned = v.(bool)
+		case "totp_secret":
+			next.TOTPSecret = v.(string)
+		case "totp_enabled":
+			next.TOTPEnabled = v.(bool)
+		case "session_epoch":
+			next.SessionEpoch = v.(int)
+		case "two_fa_failures":
+			next.TwoFAFailures = v.(int)
+		case "locale":
+			next.Locale = v.(string)
+		case "overlay_token":
+			next.OverlayToken = v.(string)
+		default:
+			return fmt.Errorf("memStore: unknown user field %q", k)
+		}
+	}
+	*u = next
+	return nil
+}
+
+func (s *memStore) UsernameTaken(username string, exceptID uint) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == username && u.ID != exceptID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memStore) ListUsers(search string, limit int) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []User
+	for _, id := range sortedIDs(s.users) {
+		u := s.users[id]
+		if search != "" && !strings.Contains(strings.ToLower(u.Username), strings.ToLower(search)) {
+			continue
+		}
+		users = append(users, *u)
+		if len(users) == limit {
+			break
+		}
+	}
+	return users, nil
+}
+
+func (s *memStore) CountUsers() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.users)), nil
+}
+
+func (s *memStore) DeleteUser(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for runID, r := range s.runs {
+		if r.UserID == id {
+			s.deleteRunLocked(runID)
+		}
+	}
+	for cid, c := range s.codes {
+		if c.UserID == id {
+			delete(s.codes, cid)
+		}
+	}
+	for cid, c := range s.chats {
+		if c.UserID == id {
+			delete(s.chats, cid)
+		}
+	}
+	for mid, m := range s.members {
+		if m.UserID == id {
+			delete(s.members, mid)
+		}
+	}
+	s.dropTeamRequestsLocked(0, id)
+	for iid, i := range s.idents {
+		if i.UserID == id {
+			delete(s.idents, iid)
+		}
+	}
+	for hid, h := range s.hooks {
+		if h.UserID == id {
+			s.deleteWebhookLocked(hid)
+		}
+	}
+	delete(s.users, id)
+	return nil
+}
+
+// ==================== RUNS ====================
+func (s *memStore) CreateRun(run *Run, drops []RuneDrop) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if run.ExternalID != "" && s.externalIDsLocked(run.UserID)[run.ExternalID] {
+		return fmt.Errorf("run %q already imported", run.ExternalID)
+	}
+	s.createRunLocked(run, drops)
+	return nil
+}
+
+func (s *memStore) CreateRuns(runs []Run, drops [][]RuneDrop) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := map[uint]map[string]bool{}
+	for _, r := range runs {
+		if r.ExternalID == "" {
+			continue
+		}
+		if seen[r.UserID] == nil {
+			seen[r.UserID] = s.externalIDsLocked(r.UserID)
+		}
+		if seen[r.UserID][r.ExternalID] {
+			return fmt.Errorf("run %q already imported", r.ExternalID)
+		}
+		seen[r.UserID][r.ExternalID] = true
+	}
+	for i := range runs {
+		s.createRunLocked(&runs[i], drops[i])
+	}
+	return nil
+}
+
+func (s *memStore) ExternalRunIDs(userID uint, ids []string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	have := s.externalIDsLocked(userID)
+	found := map[string]bool{}
+	for _, id := range ids {
+		if have[id] {
+			found[id] = true
+		}
+	}
+	return found, nil
+}
+
+func (s *memStore) externalIDsLocked(userID uint) map[string]bool {
+	ids := map[string]bool{}
+	for _, r := range s.runs {
+		if r.UserID == userID && r.ExternalID != "" {
+			ids[r.ExternalID] = true
+		}
+	}
+	return ids
+}
+
+func (s *memStore) createRunLocked(run *Run, drops []RuneDrop) {
+	run.ID = s.id()
+	r := *run
+	s.runs[r.ID] = &r
+	for i := range drops {
+		drops[i].ID = s.id()
+		drops[i].RunID = run.ID
+		d := drops[i]
+		s.drops[d.ID] = &d
+	}
+}
+
+func (s *memStore) RunByID(id uint) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.runs[id]; ok {
+		return *r, nil
+	}
+	return Run{}, ErrNotFound
+}
+
+func (s *memStore) RunsByUser(userID uint) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var runs []Run
+	for _, r := range s.runs {
+		if r.UserID == userID {
+			runs = append(runs, *r)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Timestamp.Before(runs[j].Timestamp) })
+	return runs, nil
+}
+
+func (s *memStore) DropsByUser(userID uint) ([]RuneDrop, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var drops []RuneDrop
+	for _, id := range sortedIDs(s.drops) {
+		d := s.drops[id]
+		if r, ok := s.runs[d.RunID]; ok && r.UserID == userID {
+			drops = append(drops, *d)
+		}
+	}
+	return drops, nil
+}
+
+func (s *memStore) DropsByRuns(runIDs []uint) ([]RuneDrop, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	want := make(map[uint]bool, len(runIDs))
+	for _, id := range runIDs {
+		want[id] = true
+	}
+	var drops []RuneDrop
+	for _, id := range sortedIDs(s.drops) {
+		if d := s.drops[id]; want[d.RunID] {
+			drops = append(drops, *d)
+		}
+	}
+	return drops, nil
+}
+
+func (s *memStore) FirstDrops(userID, runID uint, runes []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var seen []string
+	for _, d := range s.drops {
+		if r, ok := s.runs[d.RunID]; ok && r.UserID == userID && r.ID != runID {
+			seen = append(seen, d.Rune)
+		}
+	}
+	return firstDrops(runes, seen), nil
+}
+
+func (s *memStore) ListRuns(f RunFilter) ([]RunWithUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var runs []RunWithUser
+	for _, r := range s.runs {
+		u, ok := s.users[r.UserID]
+		if !ok || (f.UserID != 0 && r.UserID != f.UserID) || (f.HiddenOnly && !r.Hidden) || (f.FlaggedOnly && !r.Flagged) || !f.within(*r) {
+			continue
+		}
+		runs = append(runs, RunWithUser{Run: *r, Username: u.Username})
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if !f.Recent && runs[i].HRCount != runs[j].HRCount {
+			return runs[i].HRCount > runs[j].HRCount
+		}
+		return runs[i].Timestamp.After(runs[j].Timestamp)
+	})
+	if f.Limit > 0 && len(runs) > f.Limit {
+		runs = runs[:f.Limit]
+	}
+	return runs, nil
+}
+
+func (s *memStore) RunsPage(f RunFilter, cursor Run) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := func(a, b Run) bool {
+		return a.Timestamp.Before(b.Timestamp) || (a.Timestamp.Equal(b.Timestamp) && a.ID < b.ID)
+	}
+	var runs []Run
+	for _, r := range s.runs {
+		if (f.UserID == 0 || r.UserID == f.UserID) && f.within(*r) && (cursor.ID == 0 || before(cursor, *r)) {
+			runs = append(runs, *r)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return before(runs[i], runs[j]) })
+	if f.Limit > 0 && len(runs) > f.Limit {
+		runs = runs[:f.Limit]
+	}
+	return runs, nil
+}
+
+// within applies the time and area filters shared by ListRuns and RunsPage.
+func (f RunFilter) within(r Run) bool {
+	return !r.Timestamp.Before(f.Since) && (f.Until.IsZero() || r.Timestamp.Before(f.Until)) && (f.Area == "" || r.Area == f.Area)
+}
+
+func (s *memStore) SetRunHidden(id uint, hidden bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.runs[id]; ok {
+		r.Hidden = hidden
+	}
+	return nil
+}
+
+func (s *memStore) DeleteRun(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.runs[id]; !ok {
+		return ErrNotFound
+	}
+	s.deleteRunLocked(id)
+	return nil
+}
+
+func (s *memStore) deleteRunLocked(id uint) {
+	for did, d := range s.drops {
+		if d.RunID == id {
+			delete(s.drops, did)
+		}
+	}
+	for fid, f := range s.runFlags {
+		if f.RunID == id {
+			delete(s.runFlags, fid)
+		}
+	}
+	delete(s.runs, id)
+}
+
+func (s *memStore) CountRunsSince(userID uint, since time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, r := range s.runs {
+		if r.UserID == userID && r.Timestamp.After(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memStore) RunCountsByUser() (map[uint]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[uint]int{}
+	for _, r := range s.runs {
+		counts[r.UserID]++
+	}
+	return counts, nil
+}
+
+// ==================== STATS ====================
+func (s *memStore) UserTotals(userID uint) (UserTotals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totalsLocked([]uint{userID})[userID], nil
+}
+
+func (s *memStore) TotalsByUser(userIDs []uint) (map[uint]UserTotals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totalsLocked(userIDs), nil
+}
+
+func (s *memStore) totalsLocked(userIDs []uint) map[uint]UserTotals {
+	totals := make(map[uint]UserTotals, len(userIDs))
+	for _, r := range s.runs {
+		if !slices.Contains(userIDs, r.UserID) {
+			continue
+		}
+		t := totals[r.UserID]
+		t.Runs++
+		t.HR += int64(r.HRCount)
+		if r.HRCount > 0 {
+			t.HRRuns++
+		}
+		t.Uniques += int64(r.Uniques)
+		t.Sets += int64(r.Sets)
+		totals[r.UserID] = t
+	}
+	return totals
+}
+
+// DailyStats rolls the runs up on the fly; there is no table to keep in step.
+func (s *memStore) DailyStats(userID uint, since time.Time) ([]UserDailyStat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from := statDay(since)
+	byDay := map[string]*UserDailyStat{}
+	for _, r := range s.runs {
+		day := statDay(r.Timestamp)
+		if r.UserID != userID || day < from {
+			continue
+		}
+		if byDay[day] == nil {
+			byDay[day] = &UserDailyStat{UserID: userID, Day: day}
+		}
+		byDay[day].add(dailyDelta(*r, 1))
+	}
+	days := make([]UserDailyStat, 0, len(byDay))
+	for _, d := range byDay {
+		days = append(days, *d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Day < days[j].Day })
+	return days, nil
+}
+
+func (s *memStore) Leaderboard(f LeaderboardFilter, limit int) ([]LeaderboardRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byUser := map[uint]*LeaderboardRow{}
+	for _, r := range s.runs {
+		u, ok := s.users[r.UserID]
+		if !ok || u.Banned || r.Hidden || r.Flagged || (f.Area != "" && r.Area != f.Area) || (f.Difficulty != "" && r.Difficulty != f.Difficulty) {
+			continue
+		}
+		row := byUser[u.ID]
+		if row == nil {
+			row = &LeaderboardRow{UserID: u.ID, Username: u.Username}
+			byUser[u.ID] = row
+		}
+		row.TotalHR += r.HRCount
+		row.Runs++
+	}
+	rows := make([]LeaderboardRow, 0, len(byUser))
+	for _, row := range byUser {
+		row.AvgHR = float64(row.TotalHR) / float64(row.Runs)
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].TotalHR != rows[j].TotalHR {
+			return rows[i].TotalHR > rows[j].TotalHR
+		}
+		return rows[i].Username < rows[j].Username
+	})
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows, nil
+}
+
+func (s *memStore) RecentAreaHR(userID uint, area, difficulty string, window int) (RunHR, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var runs []*Run
+	for _, r := range s.runs {
+		if r.UserID == userID && r.Area == area && r.Difficulty == difficulty {
+			runs = append(runs, r)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Timestamp.After(runs[j].Timestamp) })
+	if len(runs) > window {
+		runs = runs[:window]
+	}
+	agg := RunHR{Runs: int64(len(runs))}
+	for _, r := range runs {
+		agg.HR += int64(r.HRCount)
+	}
+	return agg, nil
+}
+
+func (s *memStore) AreaBaseline(excludeUserID uint, area, difficulty string) (RunHR, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var agg RunHR
+	for _, r := range s.runs {
+		if r.UserID != excludeUserID && r.Area == area && r.Difficulty == difficulty && !r.Flagged && !r.Hidden {
+			agg.Runs++
+			agg.HR += int64(r.HRCount)
+		}
+	}
+	return agg, nil
+}
+
+// ==================== 2FA / OIDC ====================
+func (s *memStore) ReplaceRecoveryCodes(userID uint, codes []RecoveryCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, c := range s.codes {
+		if c.UserID == userID {
+			delete(s.codes, id)
+		}
+	}
+	for i := range codes {
+		codes[i].ID = s.id()
+		c := codes[i]
+		s.codes[c.ID] = &c
+	}
+	return nil
+}
+
+func (s *memStore) UnusedRecoveryCodes(userID uint) ([]RecoveryCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var codes []RecoveryCode
+	for _, id := range sortedIDs(s.codes) {
+		if c := s.codes[id]; c.UserID == userID && c.UsedAt == nil {
+			codes = append(codes, *c)
+		}
+	}
+	return codes, nil
+}
+
+func (s *memStore) UseRecoveryCode(id uint, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.codes[id]
+	if !ok || c.UsedAt != nil {
+		return false, nil
+	}
+	c.UsedAt = &at
+	return true, nil
+}
+
+func (s *memStore) UseTOTPStep(userID uint, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userID]
+	if !ok || u.TOTPLastStep >= step {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	return true, nil
+}
+
+func (s *memStore) TwoFAFailure(userID uint, now time.Time, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if u.TwoFAFailedAt > now.Add(-window).Unix() {
+		u.TwoFAFailures++
+	} else {
+		u.TwoFAFailures, u.TwoFAFailedAt = 1, now.Unix()
+	}
+	return u.TwoFAFailures, nil
+}
+
+func (s *memStore) ClearTwoFAFailures(userID uint) error {
+	return s.UpdateUser(userID, map[string]any{"two_fa_failures": 0})
+}
+
+func (s *memStore) ResetTwoFA(userID uint) error {
+	if err := s.UpdateUser(userID, map[string]any{"totp_enabled": false, "totp_secret": "", "two_fa_failures": 0}); err != nil {
+		return err
+	}
+	return s.ReplaceRecoveryCodes(userID, nil)
+}
+
+func (s *memStore) IdentityBySubject(provider, subject string) (OIDCIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, i := range s.idents {
+		if i.Provider == provider && i.Subject == subject {
+			return *i, nil
+		}
+	}
+	return OIDCIdentity{}, ErrNotFound
+}
+
+func (s *memStore) IdentitiesByUser(userID uint) ([]OIDCIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var idents []OIDCIdentity
+	for _, i := range s.idents {
+		if i.UserID == userID {
+			idents = append(idents, *i)
+		}
+	}
+	sort.Slice(idents, func(a, b int) bool { return idents[a].Provider < idents[b].Provider })
+	return idents, nil
+}
+
+func (s *memStore) CreateIdentity(ident *OIDCIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, i := range s.idents {
+		if i.Provider == ident.Provider && i.Subject == ident.Subject {
+			return fmt.Errorf("identity %s/%s already linked", ident.Provider, ident.Subject)
+		}
+	}
+	ident.ID = s.id()
+	if ident.CreatedAt.IsZero() {
+		ident.CreatedAt = time.Now()
+	}
+	i := *ident
+	s.idents[i.ID] = &i
+	return nil
+}
+
+func (s *memStore) CreateUserWithIdentity(user *User, ident *OIDCIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == user.Username {
+			return fmt.Errorf("%w: username %q already exists", ErrConflict, user.Username)
+		}
+	}
+	for _, i := range s.idents {
+		if i.Provider == ident.Provider && i.Subject == ident.Subject {
+			return fmt.Errorf("%w: identity %s/%s already linked", ErrConflict, ident.Provider, ident.Subject)
+		}
+	}
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+	user.ID = s.id()
+	u := *user
+	s.users[u.ID] = &u
+	ident.ID, ident.UserID = s.id(), user.ID
+	if ident.CreatedAt.IsZero() {
+		ident.CreatedAt = time.Now()
+	}
+	i := *ident
+	s.idents[i.ID] = &i
+	return nil
+}
+
+func (s *memStore) DeleteIdentity(userID uint, provider string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, i := range s.idents {
+		if i.UserID == userID && i.Provider == provider {
+			delete(s.idents, id)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) ChatLinkByChatUser(platform, chatUserID string) (ChatLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.chats {
+		if c.Platform == platform && c.ChatUserID == chatUserID {
+			return *c, nil
+		}
+	}
+	return ChatLink{}, ErrNotFound
+}
+
+func (s *memStore) ChatLinksByUser(userID uint) ([]ChatLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var links []ChatLink
+	for _, id := range sortedIDs(s.chats) {
+		if c := s.chats[id]; c.UserID == userID {
+			links = append(links, *c)
+		}
+	}
+	return links, nil
+}
+
+func (s *memStore) LinkChat(link *ChatLink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, c := range s.chats {
+		if c.Platform == link.Platform && c.ChatUserID == link.ChatUserID {
+			delete(s.chats, id)
+		}
+	}
+	link.ID = s.id()
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+	c := *link
+	s.chats[c.ID] = &c
+	return nil
+}
+
+func (s *memStore) DeleteChatLink(userID, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.chats[id]; ok && c.UserID == userID {
+		delete(s.chats, id)
+	}
+	return nil
+}
+
+// ==================== FLAGS ====================
+func (s *memStore) FlagRun(runID uint, flags []RunFlag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range flags {
+		flags[i].ID = s.id()
+		if flags[i].CreatedAt.IsZero() {
+			flags[i].CreatedAt = time.Now()
+		}
+		f := flags[i]
+		s.runFlags[f.ID] = &f
+	}
+	if r, ok := s.runs[runID]; ok {
+		r.Flagged = true
+	}
+	return nil
+}
+
+func (s *memStore) OpenFlags(runIDs []uint) ([]RunFlag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	want := make(map[uint]bool, len(runIDs))
+	for _, id := range runIDs {
+		want[id] = true
+	}
+	var flags []RunFlag
+	for _, id := range sortedIDs(s.runFlags) {
+		if f := s.runFlags[id]; want[f.RunID] && f.Resolution == "" {
+			flags = append(flags, *f)
+		}
+	}
+	return flags, nil
+}
+
+func (s *memStore) ResolveFlags(runID uint, resolution string, reviewerID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.runFlags {
+		if f.RunID == runID && f.Resolution == "" {
+			f.Resolution = resolution
+			reviewer := reviewerID
+			f.ReviewedBy = &reviewer
+		}
+	}
+	if r, ok := s.runs[runID]; ok {
+		r.Flagged = false
+	}
+	return nil
+}
+
+// ==================== WEBHOOKS ====================
+func (s *memStore) WebhooksFor(ownerIDs []uint) ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hooks []Webhook
+	for _, id := range sortedIDs(s.hooks) {
+		if h := s.hooks[id]; slices.Contains(ownerIDs, h.UserID) {
+			hooks = append(hooks, *h)
+		}
+	}
+	return hooks, nil
+}
+
+func (s *memStore) WebhookByID(id uint) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.hooks[id]; ok {
+		return *h, nil
+	}
+	return Webhook{}, ErrNotFound
+}
+
+func (s *memStore) CreateWebhook(hook *Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hook.ID = s.id()
+	if hook.CreatedAt.IsZero() {
+		hook.CreatedAt = time.Now()
+	}
+	h := *hook
+	s.hooks[h.ID] = &h
+	return nil
+}
+
+func (s *memStore) DeleteWebhook(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteWebhookLocked(id)
+	return nil
+}
+
+func (s *memStore) deleteWebhookLocked(id uint) {
+	for did, d := range s.sends {
+		if d.WebhookID == id {
+			delete(s.sends, did)
+		}
+	}
+	delete(s.hooks, id)
+}
+
+func (s *memStore) QueueDeliveries(deliveries []WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range deliveries {
+		deliveries[i].ID = s.id()
+		if deliveries[i].CreatedAt.IsZero() {
+			deliveries[i].CreatedAt = time.Now()
+		}
+		d := deliveries[i]
+		s.sends[d.ID] = &d
+	}
+	return nil
+}
+
+func (s *memStore) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []*WebhookDelivery
+	for _, d := range s.sends {
+		if d.Status == deliveryPending && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	var claimed []WebhookDelivery
+	for _, d := range due[:min(len(due), limit)] {
+		d.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, *d)
+	}
+	return claimed, nil
+}
+
+func (s *memStore) SaveDelivery(d *WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sends[d.ID]; !ok {
+		return ErrNotFound
+	}
+	saved := *d
+	s.sends[d.ID] = &saved
+	return nil
+}
+
+func (s *memStore) RecentDeliveries(webhookIDs []uint, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := sortedIDs(s.sends)
+	var deliveries []WebhookDelivery
+	for i := len(ids) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if d := s.sends[ids[i]]; slices.Contains(webhookIDs, d.WebhookID) {
+			deliveries = append(deliveries, *d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *memStore) PruneDeliveries(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for id, d := range s.sends {
+		if d.Status != deliveryPending && d.CreatedAt.Before(before) {
+			delete(s.sends, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ==================== TEAMS ====================
+func (s *memStore) CreateTeam(team *Team, ownerID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.teams {
+		if t.Name == team.Name {
+			return fmt.Errorf("team %q already exists", team.Name)
+		}
+	}
+	if s.membershipLocked(ownerID) != nil {
+		return fmt.Errorf("user %d is already in a team", ownerID)
+	}
+	team.ID = s.id()
+	if team.CreatedAt.IsZero() {
+		team.CreatedAt = time.Now()
+	}
+	t := *team
+	s.teams[t.ID] = &t
+	s.dropTeamRequestsLocked(0, ownerID)
+	m := &TeamMember{ID: s.id(), TeamID: t.ID, UserID: ownerID, Role: teamOwner, CreatedAt: t.CreatedAt}
+	s.members[m.ID] = m
+	return nil
+}
+
+func (s *memStore) TeamByID(id uint) (Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.teams[id]; ok {
+		return *t, nil
+	}
+	return Team{}, ErrNotFound
+}
+
+func (s *memStore) TeamNameTaken(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.teams {
+		if t.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memStore) ListTeams() ([]Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	teams := make([]Team, 0, len(s.teams))
+	for _, t := range s.teams {
+		teams = append(teams, *t)
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return teams, nil
+}
+
+func (s *memStore) DeleteTeam(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for mid, m := range s.members {
+		if m.TeamID == id {
+			delete(s.members, mid)
+		}
+	}
+	s.dropTeamRequestsLocked(id, 0)
+	delete(s.teams, id)
+	return nil
+}
+
+func (s *memStore) TeamMembers(teamID uint) ([]TeamMemberWithUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var members []TeamMemberWithUser
+	for _, id := range sortedIDs(s.members) {
+		m := s.members[id]
+		if u, ok := s.users[m.UserID]; ok && m.TeamID == teamID {
+			members = append(members, TeamMemberWithUser{TeamMember: *m, Username: u.Username})
+		}
+	}
+	return members, nil
+}
+
+func (s *memStore) TeamMemberships() ([]TeamMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := make([]TeamMember, 0, len(s.members))
+	for _, id := range sortedIDs(s.members) {
+		members = append(members, *s.members[id])
+	}
+	return members, nil
+}
+
+func (s *memStore) MembershipOf(userID uint) (TeamMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.membershipLocked(userID); m != nil {
+		return *m, nil
+	}
+	return TeamMember{}, ErrNotFound
+}
+
+func (s *memStore) membershipLocked(userID uint) *TeamMember {
+	for _, m := range s.members {
+		if m.UserID == userID {
+			return m
+		}
+	}
+	return nil
+}
+
+func (s *memStore) AddTeamMember(m *TeamMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.membershipLocked(m.UserID) != nil {
+		return fmt.Errorf("user %d is already in a team", m.UserID)
+	}
+	s.dropTeamRequestsLocked(0, m.UserID)
+	m.ID = s.id()
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+	stored := *m
+	s.members[stored.ID] = &stored
+	return nil
+}
+
+func (s *memStore) SetTeamRole(teamID, userID uint, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.membershipLocked(userID); m != nil && m.TeamID == teamID {
+		m.Role = role
+	}
+	return nil
+}
+
+func (s *memStore) TransferTeam(teamID, fromID, toID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from, to := s.membershipLocked(fromID), s.membershipLocked(toID)
+	if from == nil || to == nil || from.TeamID != teamID || to.TeamID != teamID {
+		return ErrNotFound
+	}
+	from.Role, to.Role = teamOfficer, teamOwner
+	return nil
+}
+
+func (s *memStore) RemoveTeamMember(teamID, userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, m := range s.members {
+		if m.TeamID == teamID && m.UserID == userID {
+			delete(s.members, id)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) TeamRequests(teamID, userID uint) ([]TeamRequestWithNames, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []TeamRequestWithNames
+	for _, id := range sortedIDs(s.teamReqs) {
+		r := s.teamReqs[id]
+		if (teamID != 0 && r.TeamID != teamID) || (userID != 0 && r.UserID != userID) {
+			continue
+		}
+		u, okUser := s.users[r.UserID]
+		t, okTeam := s.teams[r.TeamID]
+		if okUser && okTeam {
+			requests = append(requests, TeamRequestWithNames{TeamRequest: *r, Username: u.Username, TeamName: t.Name})
+		}
+	}
+	return requests, nil
+}
+
+func (s *memStore) TeamRequestByID(id uint) (TeamRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.teamReqs[id]; ok {
+		return *r, nil
+	}
+	return TeamRequest{}, ErrNotFound
+}
+
+func (s *memStore) CreateTeamRequest(r *TeamRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, x := range s.teamReqs {
+		if x.TeamID == r.TeamID && x.UserID == r.UserID {
+			return fmt.Errorf("user %d already has a request for team %d", r.UserID, r.TeamID)
+		}
+	}
+	r.ID = s.id()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	stored := *r
+	s.teamReqs[stored.ID] = &stored
+	return nil
+}
+
+func (s *memStore) DeleteTeamRequest(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.teamReqs, id)
+	return nil
+}
+
+// dropTeamRequestsLocked deletes the requests of teamID and/or userID; zero
+// matches any.
+func (s *memStore) dropTeamRequestsLocked(teamID, userID uint) {
+	for id, r := range s.teamReqs {
+		if (teamID == 0 || r.TeamID == teamID) && (userID == 0 || r.UserID == userID) {
+			delete(s.teamReqs, id)
+		}
+	}
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== TEAMS ====================
+
+// A team (clan) pools its members' runs: /teams/:id adds up their totals the
+// way the dashboard does for one player, and the leaderboard ranks teams by
+// their members' rows. Officers invite players and answer join requests; the
+// owner also sets roles and may disband the team.
+
+type Team struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"uniqueIndex"`
+	Tag       string // up to teamTagMax characters shown as [TAG]; may be empty
+	CreatedAt time.Time
+}
+
+type TeamMember struct {
+	ID        uint   `gorm:"primaryKey"`
+	TeamID    uint   `gorm:"index"`
+	UserID    uint   `gorm:"uniqueIndex"` // a player is in at most one team
+	Role      string // teamOwner, teamOfficer or teamMember
+	CreatedAt time.Time
+}
+
+// TeamRequest is a pending invite (the team asked the player) or join
+// request (the player asked the team); either side may withdraw it.
+type TeamRequest struct {
+	ID        uint `gorm:"primaryKey"`
+	TeamID    uint `gorm:"uniqueIndex:idx_team_requests_team_user"`
+	UserID    uint `gorm:"uniqueIndex:idx_team_requests_team_user;index"`
+	Invite    bool
+	CreatedAt time.Time
+}
+
+const (
+	teamOwner   = "owner"
+	teamOfficer = "officer"
+	teamMember  = "member"
+)
+
+var (
+	teamRoles    = []string{teamMember, teamOfficer, teamOwner}
+	teamRoleRank = map[string]int{teamMember: 0, teamOfficer: 1, teamOwner: 2}
+)
+
+const (
+	teamNameMin    = 3
+	teamNameMax    = 32
+	teamTagMax     = 5
+	teamMaxMembers = 50
+)
+
+// ==================== TEAMS: LEADERBOARD ====================
+
+// TeamLeaderboardRow sums a team's members' rows of one leaderboard view.
+type TeamLeaderboardRow struct {
+	TeamID  uint
+	Name    string
+	Tag     string
+	Members int
+	TotalHR int
+	Runs    int
+	AvgHR   float64
+}
+
+// teamLeaderboard ranks teams over the cached player view for f, so it
+// counts exactly the runs the individual leaderboard does. Teams without a
+// counted run are left out.
+func (s *server) teamLeaderboard(ctx context.Context, f LeaderboardFilter) ([]TeamLeaderboardRow, error) {
+	store := s.store.WithContext(ctx)
+	players, _, err := s.board.withContext(ctx).View(f, 0)
+	if err != nil {
+		return nil, err
+	}
+	teams, err := store.ListTeams()
+	if err != nil {
+		return nil, err
+	}
+	members, err := store.TeamMemberships()
+	if err != nil {
+		return nil, err
+	}
+
+	byTeam := make(map[uint]*TeamLeaderboardRow, len(teams))
+	for _, t := range teams {
+		byTeam[t.ID] = &TeamLeaderboardRow{TeamID: t.ID, Name: t.Name, Tag: t.Tag}
+	}
+	teamOf := make(map[uint]uint, len(members))
+	for _, m := range members {
+		if row := byTeam[m.TeamID]; row != nil {
+			row.Members++
+			teamOf[m.UserID] = m.TeamID
+		}
+	}
+	for _, p := range players {
+		if row := byTeam[teamOf[p.UserID]]; row != nil {
+			row.TotalHR += p.TotalHR
+			row.Runs += p.Runs
+		}
+	}
+
+	var rows []TeamLeaderboardRow
+	for _, row := range byTeam {
+		if row.Runs > 0 {
+			row.AvgHR = float64(row.TotalHR) / float64(row.Runs)
+			rows = append(rows, *row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].TotalHR != rows[j].TotalHR {
+			return rows[i].TotalHR > rows[j].TotalHR
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	return rows, nil
+}
+
+// ==================== TEAMS: PAGES ====================
+
+// teamsView is /team for a player without a team: their invites and
+// requests, every team, and the form to found one.
+type teamsView struct {
+	page
+	Notice   notice
+	Invites  []TeamRequestWithNames
+	Requests []TeamRequestWithNames
+	Teams    []teamListRow
+}
+
+type teamListRow struct {
+	Team
+	Members int
+	Pending bool // the viewer already has an invite or request here
+}
+
+type teamView struct {
+	page
+	Notice     notice
+	Team       Team
+	Rank       int // on the overall team leaderboard; 0 when unranked
+	Totals     UserTotals
+	AvgHR      float64
+	Efficiency float64
+	Members    []teamMemberRow
+	Role       string // the viewer's role here; empty for outsiders
+	IsOfficer  bool
+	IsOwner    bool
+	Requests   []TeamRequestWithNames // join requests, shown to officers
+	Invites    []TeamRequestWithNames // invites sent, shown to officers
+	Pending    *TeamRequestWithNames  // the viewer's own invite or request
+	CanJoin    bool
+}
+
+type teamMemberRow struct {
+	TeamMemberWithUser
+	Totals    UserTotals
+	AvgHR     float64
+	Share     float64 // percent of the team's HR
+	CanRemove bool
+	Roles     []option // for the owner to change this member's role
+}
+
+func (s *server) teamHomePage(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	if m, err := s.reqStore(c).MembershipOf(userID); err == nil {
+		c.Redirect(http.StatusFound, "/teams/"+strconv.FormatUint(uint64(m.TeamID), 10))
+		return
+	}
+	s.renderTeams(c, http.StatusOK, notice{})
+}
+
+func (s *server) renderTeams(c *gin.Context, status int, n notice) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	teams, err := s.reqStore(c).ListTeams()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "teams: %v", err)
+		return
+	}
+	members, _ := s.reqStore(c).TeamMemberships()
+	mine, _ := s.reqStore(c).TeamRequests(0, userID)
+
+	count := map[uint]int{}
+	for _, m := range members {
+		count[m.TeamID]++
+	}
+	pending := map[uint]bool{}
+	v := teamsView{page: newPage(c, "title.teams"), Notice: n}
+	for _, r := range mine {
+		pending[r.TeamID] = true
+		if r.Invite {
+			v.Invites = append(v.Invites, r)
+		} else {
+			v.Requests = append(v.Requests, r)
+		}
+	}
+	for _, t := range teams {
+		v.Teams = append(v.Teams, teamListRow{Team: t, Members: count[t.ID], Pending: pending[t.ID]})
+	}
+	c.HTML(status, "teams", v)
+}
+
+func (s *server) teamPage(c *gin.Context) {
+	s.renderTeam(c, http.StatusOK, notice{})
+}
+
+func (s *server) renderTeam(c *gin.Context, status int, n notice) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	team, err := s.teamParam(c)
+	if err != nil {
+		return
+	}
+	members, err := s.reqStore(c).TeamMembers(team.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "team: %v", err)
+		return
+	}
+	ids := make([]uint, len(members))
+	for i, m := range members {
+		ids[i] = m.UserID
+	}
+	totals, err := s.reqStore(c).TotalsByUser(ids)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "team: %v", err)
+		return
+	}
+
+	l := loc(c)
+	v := teamView{page: page{Title: l.T("title.team", team.Name), localizer: l}, Notice: n, Team: team}
+	for _, m := range members {
+		t := totals[m.UserID]
+		v.Totals.Runs += t.Runs
+		v.Totals.HR += t.HR
+		v.Totals.HRRuns += t.HRRuns
+		v.Totals.Uniques += t.Uniques
+		v.Totals.Sets += t.Sets
+		if m.UserID == userID {
+			v.Role = m.Role
+		}
+	}
+	v.AvgHR, v.Efficiency = v.Totals.rates()
+	v.IsOfficer = v.Role != "" && teamRoleRank[v.Role] >= teamRoleRank[teamOfficer]
+	v.IsOwner = v.Role == teamOwner
+
+	for _, m := range members {
+		t := totals[m.UserID]
+		row := teamMemberRow{TeamMemberWithUser: m, Totals: t}
+		row.AvgHR, _ = t.rates()
+		if v.Totals.HR > 0 {
+			row.Share = 100 * float64(t.HR) / float64(v.Totals.HR)
+		}
+		row.CanRemove = v.IsOfficer && m.UserID != userID && teamRoleRank[v.Role] > teamRoleRank[m.Role]
+		if v.IsOwner && m.UserID != userID {
+			row.Roles = selectOptions(teamRoles, m.Role, func(r string) string { return l.T("team.role." + r) })
+		}
+		v.Members = append(v.Members, row)
+	}
+	sort.SliceStable(v.Members, func(i, j int) bool {
+		a, b := v.Members[i], v.Members[j]
+		if teamRoleRank[a.Role] != teamRoleRank[b.Role] {
+			return teamRoleRank[a.Role] > teamRoleRank[b.Role]
+		}
+		return a.Totals.HR > b.Totals.HR
+	})
+
+	if board, err := s.teamLeaderboard(c.Request.Context(), LeaderboardFilter{}); err == nil {
+		for i, row := range board {
+			if row.TeamID == team.ID {
+				v.Rank = i + 1
+			}
+		}
+	}
+
+	requests, _ := s.reqStore(c).TeamRequests(team.ID, 0)
+	for i, r := range requests {
+		switch {
+		case r.UserID == userID:
+			v.Pending = &requests[i]
+		case !v.IsOfficer:
+		case r.Invite:
+			v.Invites = append(v.Invites, r)
+		default:
+			v.Requests = append(v.Requests, r)
+		}
+	}
+	if v.Role == "" && v.Pending == nil {
+		_, err := s.reqStore(c).MembershipOf(userID)
+		v.CanJoin = errors.Is(err, ErrNotFound)
+	}
+	c.HTML(status, "team", v)
+}
+
+// teamParam loads the team named by :id, answering 404 itself.
+func (s *server) teamParam(c *gin.Context) (Team, error) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	team, err := s.reqStore(c).TeamByID(uint(id))
+	if err != nil {
+		c.HTML(http.StatusNotFound, "message", messageView{page: newPage(c, "title.teams"), Text: loc(c).T("team.err.not_found"), Error: true, Link: "/team", LinkText: loc(c).T("team.back")})
+	}
+	return team, err
+}
+
+// teamActor is the signed-in player's membership in the team at :id, or
+// ok=false after answering 403 when their role is below min.
+func (s *server) teamActor(c *gin.Context, team Team, min string) (TeamMember, bool) {
+	m, err := s.reqStore(c).MembershipOf(sessions.Default(c).Get("user_id").(uint))
+	if err != nil || m.TeamID != team.ID || teamRoleRank[m.Role] < teamRoleRank[min] {
+		s.renderTeam(c, http.StatusForbidden, teamError(c, "team.err.forbidden"))
+		return m, false
+	}
+	return m, true
+}
+
+func teamError(c *gin.Context, key string) notice {
+	return notice{Text: loc(c).T(key), Error: true}
+}
+
+func teamNotice(c *gin.Context, key string, args ...any) notice {
+	return notice{Text: loc(c).T(key, args...)}
+}
+
+// ==================== TEAMS: MEMBERSHIP ====================
+func (s *server) createTeamHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	name := strings.Join(strings.Fields(c.PostForm("name")), " ")
+	tag := strings.ToUpper(strings.TrimSpace(c.PostForm("tag")))
+
+	if n := utf8.RuneCountInString(name); n < teamNameMin || n > teamNameMax {
+		s.renderTeams(c, http.StatusBadRequest, teamError(c, "team.err.name"))
+		return
+	}
+	if utf8.RuneCountInString(tag) > teamTagMax || strings.IndexFunc(tag, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+		s.renderTeams(c, http.StatusBadRequest, teamError(c, "team.err.tag"))
+		return
+	}
+	if _, err := s.reqStore(c).MembershipOf(userID); err == nil {
+		s.renderTeams(c, http.StatusConflict, teamError(c, "team.err.already_member"))
+		return
+	}
+	if taken, _ := s.reqStore(c).TeamNameTaken(name); taken {
+		s.renderTeams(c, http.StatusConflict, teamError(c, "team.err.name_taken"))
+		return
+	}
+	// The unique indexes still guard against a concurrent create racing us.
+	team := Team{Name: name, Tag: tag, CreatedAt: time.Now()}
+	if err := s.reqStore(c).CreateTeam(&team, userID); err != nil {
+		s.renderTeams(c, http.StatusConflict, teamError(c, "team.err.create_failed"))
+		return
+	}
+	s.leaderboardChanged()
+	c.Redirect(http.StatusFound, "/teams/"+strconv.FormatUint(uint64(team.ID), 10))
+}
+
+// joinTeamHandler asks to join, or accepts straight away when the team has
+// already invited the player.
+func (s *server) joinTeamHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	team, err := s.teamParam(c)
+	if err != nil {
+		return
+	}
+	if _, err := s.reqStore(c).MembershipOf(userID); err == nil {
+		s.renderTeam(c, http.StatusConflict, teamError(c, "team.err.already_member"))
+		return
+	}
+	if r, ok := s.pendingTeamRequest(c.Request.Context(), team.ID, userID); ok {
+		if r.Invite {
+			s.acceptTeamRequest(c, team, r)
+			return
+		}
+		s.renderTeam(c, http.StatusOK, teamNotice(c, "team.ok.requested"))
+		return
+	}
+	if err := s.reqStore(c).CreateTeamRequest(&TeamRequest{TeamID: team.ID, UserID: userID, CreatedAt: time.Now()}); err != nil {
+		s.renderTeam(c, http.StatusInternalServerError, teamError(c, "team.err.failed"))
+		return
+	}
+	s.renderTeam(c, http.StatusOK, teamNotice(c, "team.ok.requested"))
+}
+
+// inviteTeamHandler invites a player by name, or takes them in straight
+// away when they have already asked to join.
+func (s *server) inviteTeamHandler(c *gin.Context) {
+	team, err := s.teamParam(c)
+	if err != nil {
+		return
+	}
+	if _, ok := s.teamActor(c, team, teamOfficer); !ok {
+		return
+	}
+	user, err := s.reqStore(c).UserByUsername(strings.TrimSpace(c.PostForm("username")))
+	if err != nil {
+		s.renderTeam(c, http.StatusNotFound, teamError(c, "team.err.no_user"))
+		return
+	}
+	if _, err := s.reqStore(c).MembershipOf(user.ID); err == nil {
+		s.renderTeam(c, http.StatusConflict, teamError(c, "team.err.user_in_team"))
+		return
+	}
+	if r, ok := s.pendingTeamRequest(c.Request.Context(), team.ID, user.ID); ok {
+		if !r.Invite {
+			s.acceptTeamRequest(c, team, r)
+			return
+		}
+		s.renderTeam(c, http.StatusOK, teamNotice(c, "team.ok.invited", user.Username))
+		return
+	}
+	if err := s.reqStore(c).CreateTeamRequest(&TeamRequest{TeamID: team.ID, UserID: user.ID, Invite: true, CreatedAt: time.Now()}); err != nil {
+		s.renderTeam(c, http.StatusInternalServerError, teamError(c, "team.err.failed"))
+		return
+	}
+	s.renderTeam(c, http.StatusOK, teamNotice(c, "team.ok.invited", user.Username))
+}
+
+func (s *server) pendingTeamRequest(ctx context.Context, teamID, userID uint) (TeamRequest, bool) {
+	requests, _ := s.store.WithContext(ctx).TeamRequests(teamID, userID)
+	if len(requests) == 0 {
+		return TeamRequest{}, false
+	}
+	return requests[0].TeamRequest, true
+}
+
+// teamRequestHandler accepts or declines an invite or join request. An
+// invite is accepted by the invited player and a join request by an officer;
+// either side may decline or withdraw.
+func (s *server) teamRequestHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	team, err := s.teamParam(c)
+	if err != nil {
+		return
+	}
+	rid, _ := strconv.ParseUint(c.Param("rid"), 10, 64)
+	r, err := s.reqStore(c).TeamRequestByID(uint(rid))
+	if err != nil || r.TeamID != team.ID {
+		s.renderTeam(c, http.StatusNotFound, teamError(c, "team.err.no_request"))
+		return
+	}
+	own := r.UserID == userID
+	officer := false
+	if m, err := s.reqStore(c).MembershipOf(userID); err == nil && m.TeamID == team.ID {
+		officer = teamRoleRank[m.Role] >= teamRoleRank[teamOfficer]
+	}
+
+	switch accept := c.Param("action") == "accept"; {
+	case accept && ((r.Invite && own) || (!r.Invite && officer)):
+		s.acceptTeamRequest(c, team, r)
+	case !accept && (own || officer):
+		s.reqStore(c).DeleteTeamRequest(r.ID)
+		if own && r.Invite {
+			s.renderTeams(c, http.StatusOK, teamNotice(c, "team.ok.declined"))
+			return
+		}
+		s.renderTeam(c, http.StatusOK, teamNotice(c, "team.ok.declined"))
+	default:
+		s.renderTeam(c, http.StatusForbidden, teamError(c, "team.err.forbidden"))
+	}
+}
+
+func (s *server) acceptTeamRequest(c *gin.Context, team Team, r TeamRequest) {
+	members, _ := s.reqStore(c).TeamMembers(team.ID)
+	if len(members) >= teamMaxMembers {
+		s.renderTeam(c, http.StatusConflict, teamError(c, "team.err.full"))
+		return
+	}
+	// AddTeamMember fails when the player joined another team meanwhile.
+	if err := s.reqStore(c).AddTeamMember(&TeamMember{TeamID: team.ID, UserID: r.UserID, Role: teamMember, CreatedAt: time.Now()}); err != nil {
+		s.reqStore(c).DeleteTeamRequest(r.ID)
+		s.renderTeam(c, http.StatusConflict, teamError(c, "team.err.user_in_team"))
+		return
+	}
+	s.leaderboardChanged()
+	s.renderTeam(c, http.StatusOK, teamNotice(c, "team.ok.joined"))
+}
+
+// teamRoleHandler lets the owner set a member's role; naming another owner
+// hands the team over and leaves the old owner an officer.
+func (s *server) teamRoleHandler(c *gin.Context) {
+	team, err := s.teamParam(c)
+	if err != nil {
+		return
+	}
+	owner, ok := s.teamActor(c, team, teamOwner)
+	if !ok {
+		return
+	}
+	target, ok := s.teamTarget(c, team)
+	if !ok {
+		return
+	}
+	role := c.PostForm("role")
+	if _, known := teamRoleRank[role]; !known || target.UserID == owner.UserID {
+		s.renderTeam(c, http.StatusBadRequest, teamError(c, "team.err.role"))
+		return
+	}
+	if role == teamOwner {
+		err = s.reqStore(c).TransferTeam(team.ID, owner.UserID, target.UserID)
+	} else {
+		err = s.reqStore(c).SetTeamRole(team.ID, target.UserID, role)
+	}
+	if err != nil {
+		s.renderTeam(c, http.StatusInternalServerError, teamError(c, "team.err.failed"))
+		return
+	}
+	s.renderTeam(c, http.StatusOK, teamNotice(c, "team.ok.role"))
+}
+
+// teamRemoveHandler removes a member ranked below the acting officer.
+func (s *server) teamRemoveHandler(c *gin.Context) {
+	team, err := s.teamParam(c)
+	if err != nil {
+		return
+	}
+	actor, ok := s.teamActor(c, team, teamOfficer)
+	if !ok {
+		return
+	}
+	target, ok := s.teamTarget(c, team)
+	if !ok {
+		return
+	}
+	if teamRoleRank[actor.Role] <= teamRoleRank[target.Role] {
+		s.renderTeam(c, http.StatusForbidden, teamError(c, "team.err.forbidden"))
+		return
+	}
+	if err := s.reqStore(c).RemoveTeamMember(team.ID, target.UserID); err != nil {
+		s.renderTeam(c, http.StatusInternalServerError, teamError(c, "team.err.failed"))
+		return
+	}
+	s.leaderboardChanged()
+	s.renderTeam(c, http.StatusOK, teamNotice(c, "team.ok.removed"))
+}
+
+// teamTarget is the member named by :uid.
+func (s *server) teamTarget(c *gin.Context, team Team) (TeamMember, bool) {
+	uid, _ := strconv.ParseUint(c.Param("uid"), 10, 64)
+	m, err := s.reqStore(c).MembershipOf(uint(uid))
+	if err != nil || m.TeamID != team.ID {
+		s.renderTeam(c, http.StatusNotFound, teamError(c, "team.err.no_member"))
+		return m, false
+	}
+	return m, true
+}
+
+func (s *server) leaveTeamHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	team, err := s.teamParam(c)
+	if err != nil {
+		return
+	}
+	if _, ok := s.teamActor(c, team, teamMember); !ok {
+		return
+	}
+	if err := s.leaveTeam(c.Request.Context(), userID); err != nil {
+		s.renderTeam(c, http.StatusInternalServerError, teamError(c, "team.err.failed"))
+		return
+	}
+	s.renderTeams(c, http.StatusOK, teamNotice(c, "team.ok.left", team.Name))
+}
+
+// leaveTeam takes userID out of their team. An owner hands the team to the
+// longest-serving officer, else the longest-serving member; the last one out
+// disbands it.
+func (s *server) leaveTeam(ctx context.Context, userID uint) error {
+	store := s.store.WithContext(ctx)
+	m, err := store.MembershipOf(userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer s.leaderboardChanged()
+	members, err := store.TeamMembers(m.TeamID)
+	if err != nil {
+		return err
+	}
+	var heir *TeamMemberWithUser
+	for i, other := range members {
+		if other.UserID != userID && (heir == nil || teamRoleRank[other.Role] > teamRoleRank[heir.Role]) {
+			heir = &members[i]
+		}
+	}
+	if heir == nil {
+		return store.DeleteTeam(m.TeamID)
+	}
+	if m.Role == teamOwner {
+		if err := store.TransferTeam(m.TeamID, userID, heir.UserID); err != nil {
+			return err
+		}
+	}
+	return store.RemoveTeamMember(m.TeamID, userID)
+}
+
+// deleteTeamHandler disbands the team; the owner confirms with its name.
+func (s *server) deleteTeamHandler(c *gin.Context) {
+	team, err := s.teamParam(c)
+	if err != nil {
+		return
+	}
+	if _, ok := s.teamActor(c, team, teamOwner); !ok {
+		return
+	}
+	if c.PostForm("confirm") != team.Name {
+		s.renderTeam(c, http.StatusBadRequest, teamError(c, "team.err.delete_confirm"))
+		return
+	}
+	if err := s.reqStore(c).DeleteTeam(team.ID); err != nil {
+		s.renderTeam(c, http.StatusInternalServerError, teamError(c, "team.err.failed"))
+		return
+	}
+	s.leaderboardChanged()
+	s.renderTeams(c, http.StatusOK, teamNotice(c, "team.ok.deleted", team.Name))
+}
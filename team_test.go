@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestTeamRoles walks the role matrix: who may accept, remove and promote,
+// and where ownership goes on a hand-off and when the owner leaves.
+func TestTeamRoles(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		alice := ts.user(t, "alice")
+		res := alice.post("/teams", url.Values{"name": {"Rune Farmers"}, "tag": {"RF"}})
+		if res.Status != http.StatusFound {
+			t.Fatalf("create team: %d %s", res.Status, res.Body)
+		}
+		team := res.Location
+		teamID, _ := strconv.ParseUint(strings.TrimPrefix(team, "/teams/"), 10, 64)
+
+		users := map[string]User{}
+		clients := map[string]*testClient{"alice": alice}
+		for _, name := range []string{"alice", "bob", "carol", "dave"} {
+			if name != "alice" {
+				clients[name] = ts.user(t, name)
+				if res := clients[name].post(team+"/join", nil); res.Status != http.StatusOK {
+					t.Fatalf("%s join: %d %s", name, res.Status, res.Body)
+				}
+			}
+			users[name], _ = store.UserByUsername(name)
+		}
+		role := func(name string) string {
+			t.Helper()
+			m, err := store.MembershipOf(users[name].ID)
+			if errors.Is(err, ErrNotFound) {
+				return ""
+			} else if err != nil || m.TeamID != uint(teamID) {
+				t.Fatalf("%s: %+v %v", name, m, err)
+			}
+			return m.Role
+		}
+		request := func(name string) string {
+			t.Helper()
+			list, err := store.TeamRequests(uint(teamID), users[name].ID)
+			if err != nil || len(list) != 1 {
+				t.Fatalf("%s requests: %+v %v", name, list, err)
+			}
+			return fmt.Sprintf("%s/requests/%d/accept", team, list[0].ID)
+		}
+		member := func(name, action string) string {
+			return fmt.Sprintf("%s/members/%d/%s", team, users[name].ID, action)
+		}
+		expect := func(what string, res testResponse, status int) {
+			t.Helper()
+			if res.Status != status {
+				t.Fatalf("%s: %d, want %d: %s", what, res.Status, status, res.Body)
+			}
+		}
+
+		// A join request is for the team to accept, not the player.
+		expect("bob accepts his own request", clients["bob"].post(request("bob"), nil), http.StatusForbidden)
+		if r := role("bob"); r != "" {
+			t.Fatalf("bob joined by accepting himself: %q", r)
+		}
+		for _, name := range []string{"bob", "carol", "dave"} {
+			expect("alice accepts "+name, alice.post(request(name), nil), http.StatusOK)
+		}
+		expect("dave promotes himself", clients["dave"].post(member("dave", "role"), url.Values{"role": {teamOfficer}}), http.StatusForbidden)
+		for _, name := range []string{"bob", "carol"} {
+			expect("alice promotes "+name, alice.post(member(name, "role"), url.Values{"role": {teamOfficer}}), http.StatusOK)
+		}
+
+		// Officers remove members but not each other, nor the owner.
+		expect("bob removes carol", clients["bob"].post(member("carol", "remove"), nil), http.StatusForbidden)
+		expect("bob removes alice", clients["bob"].post(member("alice", "remove"), nil), http.StatusForbidden)
+		expect("bob promotes dave", clients["bob"].post(member("dave", "role"), url.Values{"role": {teamOfficer}}), http.StatusForbidden)
+		expect("bob removes dave", clients["bob"].post(member("dave", "remove"), nil), http.StatusOK)
+		if r := role("carol") + "," + role("dave"); r != "officer," {
+			t.Errorf("after the removals: carol,dave = %q", r)
+		}
+
+		// Naming a new owner demotes the old one in the same step.
+		expect("alice hands over to bob", alice.post(member("bob", "role"), url.Values{"role": {teamOwner}}), http.StatusOK)
+		if r := role("alice") + "," + role("bob"); r != "officer,owner" {
+			t.Errorf("after the hand-off: alice,bob = %q", r)
+		}
+		expect("alice acts as owner", alice.post(member("carol", "role"), url.Values{"role": {teamMember}}), http.StatusForbidden)
+
+		// An owner who leaves hands the team to the longest-serving officer.
+		expect("bob leaves", clients["bob"].post(team+"/leave", nil), http.StatusOK)
+		if r := role("alice") + "," + role("bob") + "," + role("carol"); r != "owner,,officer" {
+			t.Errorf("after the owner left: alice,bob,carol = %q", r)
+		}
+	})
+}
+
+// A hand-off to someone outside the team changes nobody's role.
+func TestTransferTeamIsAtomic(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		owner, stranger := User{Username: "owner"}, User{Username: "stranger"}
+		for _, u := range []*User{&owner, &stranger} {
+			if err := store.CreateUser(u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		team := Team{Name: "Solo", Tag: "S"}
+		if err := store.CreateTeam(&team, owner.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.TransferTeam(team.ID, owner.ID, stranger.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("transfer to a stranger: %v", err)
+		}
+		if m, err := store.MembershipOf(owner.ID); err != nil || m.Role != teamOwner {
+			t.Errorf("owner after the failed transfer: %+v %v", m, err)
+		}
+		if _, err := store.MembershipOf(stranger.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("stranger after the failed transfer: %v", err)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin/render"
+)
+
+// ==================== TEMPLATES ====================
+
+//go:embed templates
+var templateFiles embed.FS
+
+// Every page file defines "content" (and optionally "scripts") and is
+// rendered inside "layout"; partials.html holds fragments shared between
+// pages. Each page gets its own clone of layout+partials so the "content"
+// definitions do not collide, and a page that is not part of the site (the
+// OBS overlay) may redefine "layout" itself.
+const (
+	layoutFile   = "templates/layout.html"
+	partialsFile = "templates/partials.html"
+)
+
+var templateFuncs = template.FuncMap{
+	"asset":   asset,
+	"inc":     func(i int) int { return i + 1 },
+	"locales": availableLocales,
+	"upper":   strings.ToUpper,
+}
+
+// pageRenderer is gin's HTMLRender for the embedded pages: c.HTML(status,
+// "leaderboard", view) renders templates/leaderboard.html with view.
+type pageRenderer map[string]*template.Template
+
+func loadTemplates() (pageRenderer, error) {
+	base, err := template.New("").Funcs(templateFuncs).ParseFS(templateFiles, layoutFile, partialsFile)
+	if err != nil {
+		return nil, err
+	}
+	files, err := fs.Glob(templateFiles, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	pages := pageRenderer{}
+	for _, f := range files {
+		if f == layoutFile || f == partialsFile {
+			continue
+		}
+		t, err := template.Must(base.Clone()).ParseFS(templateFiles, f)
+		if err != nil {
+			return nil, err
+		}
+		pages[strings.TrimSuffix(path.Base(f), ".html")] = t
+	}
+	return pages, nil
+}
+
+func (p pageRenderer) Instance(name string, data any) render.Render {
+	t, ok := p[name]
+	if !ok {
+		panic("template: no page " + name)
+	}
+	return render.HTML{Template: t, Name: "layout", Data: data}
+}
+
+// ==================== VIEW MODELS ====================
+
+// page is embedded in every view model; the layout reads Title from it and
+// templates reach the request's localizer through it. Build it with newPage.
+type page struct {
+	Title string
+	*localizer
+}
+
+// notice is a one-line success or error message above a form. The zero value
+// renders nothing.
+type notice struct {
+	Text  string
+	Error bool
+}
+
+// option is one <option> of a select, rendered by the "options" partial.
+type option struct {
+	Value, Label string
+	Selected     bool
+}
+
+// messageView is a single panel with a line of text and an optional link.
+type messageView struct {
+	page
+	Text     string
+	Error    bool
+	Link     string
+	LinkText string
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// Every page that can show a player-chosen name must escape it. The names
+// below reach each template at least once; none may come back as markup.
+func TestPagesEscapeNames(t *testing.T) {
+	const (
+		evil     = "<script>alert(1)</script>"
+		evilBob  = `"><script>alert(2)</script>`
+		evilTeam = "<script>alert(3)</script>"
+		escaped  = "&lt;script&gt;alert("
+	)
+	ts := newTestServer(t, newMemStore(), func(cfg *Config) { cfg.Bot.SlackSigningSecret = "test-signing-secret" })
+	admin := ts.admin(t, evil, RoleAdmin)
+	bob := ts.user(t, evilBob)
+	me, _ := ts.store.UserByUsername(evil)
+	target, _ := ts.store.UserByUsername(evilBob)
+
+	// Bob's run is over the HR limit, so it lands in the flag queue while
+	// the admin's makes the leaderboard.
+	for c, runes := range map[*testClient]string{admin: `[{"rune":"Ber","qty":1}]`, bob: `[{"rune":"Ber","qty":4}]`} {
+		res := c.post("/log-run", url.Values{"area": {"Mephisto"}, "difficulty": {"Hell"}, "runes": {runes}})
+		if res.Status != http.StatusOK {
+			t.Fatalf("log-run: %d %s", res.Status, res.Body)
+		}
+	}
+	res := admin.post("/teams", url.Values{"name": {evilTeam}, "tag": {"XSS"}})
+	if res.Status != http.StatusFound {
+		t.Fatalf("create team: %d %s", res.Status, res.Body)
+	}
+	team := res.Location
+	admin.post(team+"/invite", url.Values{"username": {evilBob}})
+	admin.post("/account/overlay", nil)
+	admin.post("/account/2fa/setup", nil)
+	me, _ = ts.store.UserByID(me.ID)
+	link := ts.botLinkToken(botCommand{Platform: "slack", ChatUserID: "U1", ChatName: evil}, time.Now())
+
+	check := func(t *testing.T, page string, res testResponse, shows bool) {
+		t.Helper()
+		if res.Status >= http.StatusInternalServerError || res.Location != "" {
+			t.Fatalf("%s: %d %s", page, res.Status, res.Location)
+		}
+		if strings.Contains(res.Body, "<script>alert") {
+			t.Errorf("%s renders a name as markup", page)
+		}
+		if shows && !strings.Contains(res.Body, escaped) {
+			t.Errorf("%s does not show the escaped name", page)
+		}
+	}
+
+	for _, p := range []struct {
+		path  string
+		shows bool
+	}{
+		{"/dashboard", false},
+		{"/leaderboard", true},
+		{"/my-stats", false},
+		{team, true},
+		{"/account", true},
+		{"/account/2fa", false},
+		{"/import", false},
+		{"/account/webhooks", false},
+		{"/account/chat/link?t=" + url.QueryEscape(link), true},
+		{"/overlay/" + me.OverlayToken, false},
+		{"/admin", true},
+		{"/admin/runs", true},
+		{"/admin/flags", true},
+		{"/admin/webhooks", false},
+	} {
+		check(t, p.path, admin.get(p.path), p.shows)
+	}
+	check(t, "/team with an invite", bob.get("/team"), true)
+
+	check(t, "temporary password", admin.post(fmt.Sprintf("/admin/users/%d/reset-password", target.ID), nil), true)
+	check(t, "failed 2FA enrolment", admin.post("/account/2fa/enable", url.Values{"code": {"000000"}}), false)
+	code, _ := totp.GenerateCode(me.TOTPSecret, time.Now())
+	check(t, "recovery codes", admin.post("/account/2fa/enable", url.Values{"code": {code}}), false)
+
+	anon := ts.client(t)
+	check(t, "/login", anon.get("/login"), false)
+	check(t, "/register", anon.get("/register"), false)
+	anon.post("/login", url.Values{"username": {evil}, "password": {testPassword}})
+	check(t, "/login/2fa", anon.get("/login/2fa"), false)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"html/template"
+	"image/png"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer        = "D2R Farm Tracker"
+	totpPeriod        = 30
+	recoveryCodeCount = 10
+	pendingTwoFAKey   = "2fa_login"
+	pendingTwoFATTL   = 5 * time.Minute
+	twoFAMaxFailures  = 5
+)
+
+// twoFALockWindow is how long twoFAMaxFailures wrong codes lock a user's
+// second factor, counted from the first of them.
+var twoFALockWindow = 15 * time.Minute
+
+// RecoveryCode is a single-use fallback for a lost authenticator. Only the
+// bcrypt hash is stored; the plain code is shown to the user exactly once.
+type RecoveryCode struct {
+	ID     uint `gorm:"primaryKey"`
+	UserID uint `gorm:"index"`
+	Hash   string
+	UsedAt *time.Time
+}
+
+// ==================== 2FA: LOGIN STEP ====================
+
+// twoFALogins holds logins that passed the password (or OIDC) step and wait
+// for a code. Wrong codes are counted on the user in the store, not here: a
+// new password step starts a new login but not a new allowance.
+type twoFALogins struct {
+	mu      sync.Mutex
+	pending map[string]*twoFALogin
+}
+
+type twoFALogin struct {
+	UserID  uint
+	Started time.Time
+}
+
+func newTwoFALogins() *twoFALogins {
+	return &twoFALogins{pending: map[string]*twoFALogin{}}
+}
+
+func (p *twoFALogins) start(userID uint) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, l := range p.pending {
+		if time.Since(l.Started) > pendingTwoFATTL {
+			delete(p.pending, key)
+		}
+	}
+	key := randomToken()
+	p.pending[key] = &twoFALogin{UserID: userID, Started: time.Now()}
+	return key
+}
+
+func (p *twoFALogins) get(key string) (twoFALogin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.pending[key]
+	if !ok || time.Since(l.Started) > pendingTwoFATTL {
+		delete(p.pending, key)
+		return twoFALogin{}, false
+	}
+	return *l, true
+}
+
+func (p *twoFALogins) finish(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, key)
+}
+
+// beginTwoFA parks a login that still needs a code and sends it to /login/2fa.
+func (s *server) beginTwoFA(c *gin.Context, user User) {
+	session := sessions.Default(c)
+	session.Set(pendingTwoFAKey, s.twoFA.start(user.ID))
+	session.Save()
+	c.Redirect(http.StatusFound, "/login/2fa")
+}
+
+func (s *server) twoFAPage(c *gin.Context) {
+	key, _ := sessions.Default(c).Get(pendingTwoFAKey).(string)
+	if _, ok := s.twoFA.get(key); !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	c.HTML(http.StatusOK, "twofa_login", twoFALoginView{page: newPage(c, "title.twofa_verify")})
+}
+
+func (s *server) twoFAHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	key, _ := session.Get(pendingTwoFAKey).(string)
+	pending, ok := s.twoFA.get(key)
+	if !ok {
+		session.Delete(pendingTwoFAKey)
+		session.Save()
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := s.reqStore(c).UserByID(pending.UserID)
+	if err != nil || !user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	locked := func() {
+		s.twoFA.finish(key)
+		session.Delete(pendingTwoFAKey)
+		session.Save()
+		renderLogin(c, http.StatusUnauthorized, loc(c).T("twofa.too_many", int(twoFALockWindow.Minutes())))
+	}
+	if twoFALocked(user, time.Now()) {
+		locked()
+		return
+	}
+	code := strings.TrimSpace(c.PostForm("code"))
+	if !s.acceptTOTP(c.Request.Context(), user, code) && !s.consumeRecoveryCode(c.Request.Context(), user.ID, code) {
+		failures, err := s.reqStore(c).TwoFAFailure(user.ID, time.Now(), twoFALockWindow)
+		if err != nil {
+			reqLog(c).Error("2fa: counting a failed code failed", "err", err)
+		}
+		if failures >= twoFAMaxFailures {
+			reqLog(c).Warn("2fa login locked after failed codes", "failures", failures)
+			locked()
+			return
+		}
+		c.HTML(http.StatusUnauthorized, "twofa_login", twoFALoginView{page: newPage(c, "title.twofa_verify"), Error: loc(c).T("twofa.bad_code")})
+		return
+	}
+
+	if user.TwoFAFailures > 0 {
+		s.reqStore(c).ClearTwoFAFailures(user.ID)
+	}
+	s.twoFA.finish(key)
+	session.Delete(pendingTwoFAKey)
+	signIn(session, user)
+	c.Redirect(http.StatusFound, "/dashboard")
+}
+
+// twoFALocked reports whether the user's wrong codes still lock the second
+// factor at now.
+func twoFALocked(user User, now time.Time) bool {
+	return user.TwoFAFailures >= twoFAMaxFailures && now.Before(time.Unix(user.TwoFAFailedAt, 0).Add(twoFALockWindow))
+}
+
+// ==================== 2FA: ENROLLMENT ====================
+func (s *server) twoFASettingsPage(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	user, err := s.reqStore(c).UserByID(userID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/logout")
+		return
+	}
+
+	if user.TOTPEnabled {
+		left, _ := s.reqStore(c).UnusedRecoveryCodes(user.ID)
+		c.HTML(http.StatusOK, "twofa_enabled", twoFAEnabledView{page: newPage(c, "title.twofa"), CodesLeft: len(left)})
+		return
+	}
+
+	view := twoFAEnrollView{page: newPage(c, "title.twofa_enable")}
+	if user.TOTPSecret != "" {
+		key, err := totpKey(user)
+		if err == nil {
+			view.QR, err = qrDataURI(key)
+		}
+		if err != nil {
+			c.String(http.StatusInternalServerError, "totp: %v", err)
+			return
+		}
+		view.Secret = user.TOTPSecret
+	}
+	c.HTML(http.StatusOK, "twofa_enroll", view)
+}
+
+// twoFASetupHandler issues a new secret. It stays unconfirmed, with
+// TOTPEnabled false and login unaffected, until twoFAEnableHandler sees a
+// valid code for it.
+func (s *server) twoFASetupHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	user, err := s.reqStore(c).UserByID(userID)
+	if err != nil || user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/account/2fa")
+		return
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Username})
+	if err == nil {
+		err = s.reqStore(c).UpdateUser(user.ID, map[string]any{"totp_secret": key.Secret()})
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "totp: %v", err)
+		return
+	}
+	c.Redirect(http.StatusFound, "/account/2fa")
+}
+
+func (s *server) twoFAEnableHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	user, err := s.reqStore(c).UserByID(userID)
+	if err != nil || user.TOTPEnabled || user.TOTPSecret == "" {
+		c.Redirect(http.StatusFound, "/account/2fa")
+		return
+	}
+	if !s.acceptTOTP(c.Request.Context(), user, strings.TrimSpace(c.PostForm("code"))) {
+		c.HTML(http.StatusBadRequest, "message", messageView{page: newPage(c, "title.twofa_enable"), Text: loc(c).T("twofa.enroll_failed"), Error: true, Link: "/account/2fa", LinkText: loc(c).T("twofa.retry")})
+		return
+	}
+
+	codes, err := s.issueRecoveryCodes(c.Request.Context(), user.ID)
+	if err == nil {
+		err = s.reqStore(c).UpdateUser(user.ID, map[string]any{"totp_enabled": true})
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "recovery codes: %v", err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "recovery_codes", recoveryCodesView{page: newPage(c, "title.recovery_codes"), Codes: codes})
+}
+
+func (s *server) twoFADisableHandler(c *gin.Context) {
+	userID := sessions.Default(c).Get("user_id").(uint)
+	user, err := s.reqStore(c).UserByID(userID)
+	if err != nil || !user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/account/2fa")
+		return
+	}
+	code := strings.TrimSpace(c.PostForm("code"))
+	if !s.acceptTOTP(c.Request.Context(), user, code) && !s.consumeRecoveryCode(c.Request.Context(), user.ID, code) {
+		c.Redirect(http.StatusFound, "/account/2fa")
+		return
+	}
+	s.reqStore(c).ResetTwoFA(user.ID)
+	c.Redirect(http.StatusFound, "/account/2fa")
+}
+
+// ==================== 2FA: HELPERS ====================
+var totpOpts = totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+// totpStep returns the time step code is valid for, allowing one step of
+// clock skew either way.
+func totpStep(secret, code string, now time.Time) (int64, bool) {
+	if secret == "" || len(code) != 6 {
+		return 0, false
+	}
+	for skew := -1; skew <= 1; skew++ {
+		at := now.UTC().Add(time.Duration(skew*totpPeriod) * time.Second)
+		want, err := totp.GenerateCodeCustom(secret, at, totpOpts)
+		if err == nil && subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return at.Unix() / totpPeriod, true
+		}
+	}
+	return 0, false
+}
+
+// acceptTOTP checks code and burns its time step, so a code seen once (or
+// one from an earlier step) cannot be replayed.
+func (s *server) acceptTOTP(ctx context.Context, user User, code string) bool {
+	step, ok := totpStep(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false
+	}
+	fresh, err := s.store.WithContext(ctx).UseTOTPStep(user.ID, step)
+	return err == nil && fresh
+}
+
+// totpKey rebuilds the enrollment key for the user's pending secret.
+func totpKey(user User) (*otp.Key, error) {
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(user.TOTPSecret)
+	if err != nil {
+		return nil, err
+	}
+	return totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Username, Secret: secret})
+}
+
+// qrDataURI renders the enrollment QR code inline. It is typed as a trusted
+// URL because html/template rejects data: URIs in src attributes otherwise.
+func qrDataURI(key *otp.Key) (template.URL, error) {
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+func (s *server) issueRecoveryCodes(ctx context.Context, userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = raw[:4] + "-" + raw[4:]
+		hashed, err := bcrypt.GenerateFromPassword([]byte(codes[i]), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = RecoveryCode{UserID: userID, Hash: string(hashed)}
+	}
+	return codes, s.store.WithContext(ctx).ReplaceRecoveryCodes(userID, rows)
+}
+
+func (s *server) consumeRecoveryCode(ctx context.Context, userID uint, code string) bool {
+	code = strings.ToLower(code)
+	if len(code) != 9 {
+		return false
+	}
+	store := s.store.WithContext(ctx)
+	unused, _ := store.UnusedRecoveryCodes(userID)
+	for _, rc := range unused {
+		if bcrypt.CompareHashAndPassword([]byte(rc.Hash), []byte(code)) == nil {
+			ok, err := store.UseRecoveryCode(rc.ID, time.Now())
+			return err == nil && ok
+		}
+	}
+	return false
+}
+
+// ==================== 2FA: VIEWS ====================
+type twoFALoginView struct {
+	page
+	Error string
+}
+
+type twoFAEnrollView struct {
+	page
+	QR     template.URL
+	Secret string
+}
+
+type twoFAEnabledView struct {
+	page
+	CodesLeft int
+}
+
+type recoveryCodesView struct {
+	page
+	Codes []string
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// twoFAUser registers name with TOTP enabled and returns its secret.
+func twoFAUser(t *testing.T, ts testServer, name string) string {
+	t.Helper()
+	ts.user(t, name)
+	user, err := ts.store.UserByUsername(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.UpdateUser(user.ID, map[string]any{"totp_secret": key.Secret(), "totp_enabled": true}); err != nil {
+		t.Fatal(err)
+	}
+	return key.Secret()
+}
+
+func passwordStep(t *testing.T, ts testServer, name string) *testClient {
+	t.Helper()
+	c := ts.client(t)
+	if res := c.post("/login", url.Values{"username": {name}, "password": {testPassword}}); res.Location != "/login/2fa" {
+		t.Fatalf("password step: %d %q", res.Status, res.Location)
+	}
+	return c
+}
+
+func TestTwoFALockout(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		secret := twoFAUser(t, ts, "locked")
+		c := passwordStep(t, ts, "locked")
+
+		for i := 1; i < twoFAMaxFailures; i++ {
+			if res := c.post("/login/2fa", url.Values{"code": {"000000"}}); res.Status != 401 || !strings.Contains(res.Body, `action="/login/2fa"`) {
+				t.Fatalf("failure %d: %d", i, res.Status)
+			}
+		}
+		saved := c.http.Jar.Cookies(mustURL(t, ts.URL))
+		if res := c.post("/login/2fa", url.Values{"code": {"000000"}}); strings.Contains(res.Body, `action="/login/2fa"`) {
+			t.Fatalf("login step still offered after %d failures", twoFAMaxFailures)
+		}
+
+		// Replaying the cookie from before the last failure must not help.
+		c.http.Jar.SetCookies(mustURL(t, ts.URL), saved)
+		if res := c.post("/login/2fa", url.Values{"code": {"000000"}}); res.Location != "/login" {
+			t.Fatalf("replayed pending cookie: %d %q", res.Status, res.Location)
+		}
+
+		// A new password step does not bring new guesses, not even a right one.
+		code, _ := totp.GenerateCode(secret, time.Now())
+		if res := passwordStep(t, ts, "locked").post("/login/2fa", url.Values{"code": {code}}); res.Status != 401 || strings.Contains(res.Body, `action="/login/2fa"`) {
+			t.Fatalf("login again while locked: %d %q", res.Status, res.Location)
+		}
+
+		// Once the window is over the right code works, and clears the count.
+		window := twoFALockWindow
+		twoFALockWindow = 0
+		t.Cleanup(func() { twoFALockWindow = window })
+		if res := passwordStep(t, ts, "locked").post("/login/2fa", url.Values{"code": {code}}); res.Location != "/dashboard" {
+			t.Fatalf("login after the lockout: %d %q", res.Status, res.Location)
+		}
+		if user, _ := ts.store.UserByUsername("locked"); user.TwoFAFailures != 0 {
+			t.Errorf("failures after a good code: %d", user.TwoFAFailures)
+		}
+	})
+}
+
+func TestTwoFARejectsReplayedCode(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ts := newTestServer(t, store)
+		secret := twoFAUser(t, ts, "replay")
+		code, err := totp.GenerateCode(secret, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res := passwordStep(t, ts, "replay").post("/login/2fa", url.Values{"code": {code}}); res.Location != "/dashboard" {
+			t.Fatalf("first use: %d %q", res.Status, res.Location)
+		}
+		if res := passwordStep(t, ts, "replay").post("/login/2fa", url.Values{"code": {code}}); res.Status != 401 {
+			t.Fatalf("replayed code accepted: %d %q", res.Status, res.Location)
+		}
+	})
+}
+
+func TestTwoFASettingsPageKeepsSecret(t *testing.T) {
+	ts := newTestServer(t, newMemStore())
+	c := ts.user(t, "enroll")
+	secret := func() string {
+		u, _ := ts.store.UserByUsername("enroll")
+		return u.TOTPSecret
+	}
+
+	c.get("/account/2fa")
+	if secret() != "" {
+		t.Fatal("GET /account/2fa issued a secret")
+	}
+	c.post("/account/2fa/setup", nil)
+	first := secret()
+	if first == "" {
+		t.Fatal("setup issued no secret")
+	}
+	if res := c.get("/account/2fa"); !strings.Contains(res.Body, first) {
+		t.Fatal("enrollment page does not show the pending secret")
+	}
+	if secret() != first {
+		t.Fatal("GET /account/2fa rotated the secret")
+	}
+
+	code, _ := totp.GenerateCode(first, time.Now())
+	if res := c.post("/account/2fa/enable", url.Values{"code": {code}}); res.Status != 200 {
+		t.Fatalf("enable: %d", res.Status)
+	}
+	if u, _ := ts.store.UserByUsername("enroll"); !u.TOTPEnabled {
+		t.Fatal("2FA not enabled")
+	}
+}
@@ -0,0 +1,677 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== WEBHOOKS ====================
+
+// Players subscribe their own webhooks on /account/webhooks and admins add
+// global ones on /admin/webhooks. Every event is queued in the delivery log
+// and sent in the background, signed with the webhook's secret:
+//
+//	X-D2R-Signature: sha256=<hex HMAC-SHA256 of the body>
+//
+// The JSON body carries the event plus a one-line message as both "content"
+// and "text", so a Discord or Slack incoming-webhook URL works unchanged.
+
+// Webhook is a subscription; UserID 0 makes it global, hearing every player.
+type Webhook struct {
+	ID        uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"index"`
+	URL       string
+	Secret    string // HMAC key for X-D2R-Signature
+	Events    string // comma-separated webhookEvents
+	CreatedAt time.Time
+}
+
+// WebhookDelivery is one event for one webhook and the outcome of its latest
+// attempt; it stays pending until delivered or out of retries.
+type WebhookDelivery struct {
+	ID            uint `gorm:"primaryKey"`
+	WebhookID     uint `gorm:"index"`
+	Event         string
+	Payload       string
+	Status        string // deliveryPending, deliveryDelivered or deliveryFailed
+	Attempts      int
+	ResponseCode  int // 0 when no response came back
+	Error         string
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+}
+
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+const (
+	eventRunLogged  = "run_logged"
+	eventHighRune   = "high_rune"
+	eventGrailItem  = "grail_item" // first drop of a rune ever, for that player
+	eventRankChange = "rank_change"
+	eventPing       = "ping" // the test button; never subscribed to
+)
+
+var webhookEvents = []string{eventRunLogged, eventHighRune, eventGrailItem, eventRankChange}
+
+const (
+	webhookMaxPerOwner = 5
+	webhookBatch       = 10 // deliveries sent at once
+	webhookPoll        = 15 * time.Second
+	webhookLogKeep     = 30 * 24 * time.Hour
+	webhookLogShown    = 50
+	webhookErrorBytes  = 200 // of a failed response body, kept in the log
+)
+
+// webhookBackoff is the wait before each retry; a delivery that fails once
+// more after the last one is given up.
+var webhookBackoff = []time.Duration{30 * time.Second, 2 * time.Minute, 10 * time.Minute, time.Hour, 6 * time.Hour}
+
+// webhookRetryAfterMax caps a receiver's Retry-After at the longest backoff.
+var webhookRetryAfterMax = webhookBackoff[len(webhookBackoff)-1]
+
+func (h Webhook) wants(event string) bool {
+	return event == eventPing || slices.Contains(strings.Split(h.Events, ","), event)
+}
+
+// webhookPayload is the JSON body of every delivery.
+type webhookPayload struct {
+	Event      string       `json:"event"`
+	OccurredAt time.Time    `json:"occurred_at"`
+	User       webhookUser  `json:"user"`
+	Run        *exportRun   `json:"run,omitempty"`
+	Runes      []string     `json:"runes,omitempty"` // high_rune: the high runes; grail_item: the first finds
+	Rank       *webhookRank `json:"rank,omitempty"`
+	Content    string       `json:"content"` // Discord
+	Text       string       `json:"text"`    // Slack
+}
+
+type webhookUser struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+}
+
+// webhookRank is a move on the overall leaderboard; 0 means outside the top
+// leaderboardSize.
+type webhookRank struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// message is the one-line chat text of p in l's language.
+func (p webhookPayload) message(l *localizer) string {
+	name := p.User.Username
+	switch p.Event {
+	case eventRunLogged:
+		return l.T("webhook.msg.run_logged", name, l.Area(p.Run.Area), l.Difficulty(p.Run.Difficulty), p.Run.HRCount)
+	case eventHighRune:
+		var found []string
+		for _, d := range p.Run.Drops {
+			if highRunes[d.Rune] {
+				found = append(found, l.T("webhook.msg.rune_qty", d.Rune, d.Qty))
+			}
+		}
+		return l.T("webhook.msg.high_rune", name, strings.Join(found, ", "), l.Area(p.Run.Area), l.Difficulty(p.Run.Difficulty))
+	case eventGrailItem:
+		return l.T("webhook.msg.grail_item", name, strings.Join(p.Runes, ", "))
+	case eventRankChange:
+		switch r := p.Rank; {
+		case r.From == 0:
+			return l.T("webhook.msg.rank_entered", name, r.To, leaderboardSize)
+		case r.To == 0:
+			return l.T("webhook.msg.rank_left", name, leaderboardSize)
+		case r.To < r.From:
+			return l.T("webhook.msg.rank_up", name, r.To, r.From)
+		default:
+			return l.T("webhook.msg.rank_down", name, r.To, r.From)
+		}
+	}
+	return l.T("webhook.msg.ping", name)
+}
+
+// ==================== WEBHOOKS: EVENTS ====================
+
+// rankSnapshot is the top of the overall leaderboard, taken before a run is
+// stored so runWebhooks can tell whom the run moved.
+func (s *server) rankSnapshot(ctx context.Context) []LeaderboardRow {
+	if !s.cfg.Features.Webhooks {
+		return nil
+	}
+	rows, _, _ := s.board.withContext(ctx).View(LeaderboardFilter{}, leaderboardSize)
+	return rows
+}
+
+// runWebhooks announces a freshly logged run. A flagged run is announced as
+// logged, but its drops are not shouted about before a moderator looked.
+func (s *server) runWebhooks(ctx context.Context, run Run, drops []RuneDrop, before []LeaderboardRow) {
+	if !s.cfg.Features.Webhooks {
+		return
+	}
+	store := s.store.WithContext(ctx)
+	user, err := store.UserByID(run.UserID)
+	if err != nil {
+		ctxLog(ctx).Error("webhooks: user lookup failed", "user_id", run.UserID, "err", err)
+		return
+	}
+	now := time.Now()
+	out := exportRun{ID: run.ID, Area: run.Area, Difficulty: run.Difficulty, Uniques: run.Uniques, Sets: run.Sets, HRCount: run.HRCount,
+		SessionSec: run.SessionSec, Timestamp: run.Timestamp, Hidden: run.Hidden, Flagged: run.Flagged, Drops: []exportDrop{}}
+	var dropped, high []string
+	for _, d := range drops {
+		out.Drops = append(out.Drops, exportDrop{Rune: d.Rune, Qty: d.Qty})
+		dropped = append(dropped, d.Rune)
+		if highRunes[d.Rune] {
+			high = append(high, d.Rune)
+		}
+	}
+	who := webhookUser{ID: user.ID, Username: user.Username}
+	events := []webhookPayload{{Event: eventRunLogged, OccurredAt: now, User: who, Run: &out}}
+
+	if !run.Flagged {
+		if len(high) > 0 {
+			events = append(events, webhookPayload{Event: eventHighRune, OccurredAt: now, User: who, Run: &out, Runes: high})
+		}
+		first, err := store.FirstDrops(user.ID, run.ID, dropped)
+		if err != nil {
+			ctxLog(ctx).Error("webhooks: first drops failed", "run_id", run.ID, "err", err)
+		}
+		if len(first) > 0 {
+			events = append(events, webhookPayload{Event: eventGrailItem, OccurredAt: now, User: who, Run: &out, Runes: first})
+		}
+	}
+
+	// Passing players moves them too; each move is its own event.
+	after, _, _ := s.board.withContext(ctx).View(LeaderboardFilter{}, leaderboardSize)
+	for _, move := range rankMoves(before, after) {
+		ev := webhookPayload{Event: eventRankChange, OccurredAt: now, User: move.user, Rank: &move.rank}
+		if move.user.ID == user.ID {
+			ev.Run = &out
+		}
+		events = append(events, ev)
+	}
+	s.queueWebhooks(ctx, events)
+}
+
+type rankMove struct {
+	user webhookUser
+	rank webhookRank
+}
+
+// rankMoves compares two tops of the leaderboard, best first.
+func rankMoves(before, after []LeaderboardRow) []rankMove {
+	ranks := func(rows []LeaderboardRow) map[uint]int {
+		m := make(map[uint]int, len(rows))
+		for i, r := range rows {
+			m[r.UserID] = i + 1
+		}
+		return m
+	}
+	was, is := ranks(before), ranks(after)
+	var moves []rankMove
+	for _, r := range after {
+		if was[r.UserID] != is[r.UserID] {
+			moves = append(moves, rankMove{webhookUser{r.UserID, r.Username}, webhookRank{From: was[r.UserID], To: is[r.UserID]}})
+		}
+	}
+	for _, r := range before {
+		if is[r.UserID] == 0 {
+			moves = append(moves, rankMove{webhookUser{r.UserID, r.Username}, webhookRank{From: was[r.UserID]}})
+		}
+	}
+	return moves
+}
+
+// queueWebhooks writes a delivery for every webhook that wants each event:
+// the player's own webhooks and the global ones.
+func (s *server) queueWebhooks(ctx context.Context, events []webhookPayload) {
+	store := s.store.WithContext(ctx)
+	owners := []uint{0}
+	for _, ev := range events {
+		if !slices.Contains(owners, ev.User.ID) {
+			owners = append(owners, ev.User.ID)
+		}
+	}
+	hooks, err := store.WebhooksFor(owners)
+	if err != nil {
+		ctxLog(ctx).Error("webhooks: lookup failed", "err", err)
+		return
+	}
+	var deliveries []WebhookDelivery
+	for _, hook := range hooks {
+		l := s.webhookLocalizer(ctx, hook)
+		for _, ev := range events {
+			if !hook.wants(ev.Event) || (hook.UserID != 0 && hook.UserID != ev.User.ID) {
+				continue
+			}
+			ev.Content = ev.message(l)
+			ev.Text = ev.Content
+			body, err := json.Marshal(ev)
+			if err != nil {
+				ctxLog(ctx).Error("webhooks: encode failed", "event", ev.Event, "err", err)
+				continue
+			}
+			deliveries = append(deliveries, WebhookDelivery{WebhookID: hook.ID, Event: ev.Event, Payload: string(body),
+				Status: deliveryPending, CreatedAt: ev.OccurredAt, NextAttemptAt: ev.OccurredAt})
+		}
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	if err := store.QueueDeliveries(deliveries); err != nil {
+		ctxLog(ctx).Error("webhooks: queueing failed", "deliveries", len(deliveries), "err", err)
+		return
+	}
+	s.webhooks.wake()
+}
+
+// webhookLocalizer speaks the owner's language; global webhooks use the
+// site default.
+func (s *server) webhookLocalizer(ctx context.Context, hook Webhook) *localizer {
+	if hook.UserID != 0 {
+		if u, err := s.store.WithContext(ctx).UserByID(hook.UserID); err == nil && u.Locale != "" {
+			return newLocalizer(u.Locale)
+		}
+	}
+	return newLocalizer(defaultLocale)
+}
+
+// ==================== WEBHOOKS: SENDER ====================
+
+// webhookSender sends due deliveries in the background. Queueing wakes it;
+// retries and deliveries queued by other instances are picked up by polling.
+type webhookSender struct {
+	store   Store
+	metrics *metrics
+	client  *http.Client
+	lease   time.Duration // a claim outlives the longest attempt
+	wakeup  chan struct{}
+}
+
+var errPrivateAddress = errors.New("webhook address is not public")
+
+func newWebhookSender(cfg WebhookConfig, store Store, m *metrics) *webhookSender {
+	dialer := &net.Dialer{Timeout: cfg.Timeout.Duration}
+	if !cfg.AllowPrivate {
+		// Checked on the resolved address at connect time, so neither DNS
+		// tricks nor redirects reach the internal network.
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, _ := net.SplitHostPort(address)
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !ip.Unmap().IsGlobalUnicast() || ip.Unmap().IsPrivate() {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &webhookSender{
+		store:   store,
+		metrics: m,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout.Duration,
+			// A redirect is an answer like any other; receivers must not move.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		lease:  cfg.Timeout.Duration + 30*time.Second,
+		wakeup: make(chan struct{}, 1),
+	}
+}
+
+func (w *webhookSender) wake() {
+	select {
+	case w.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// start runs the sender until the returned stop is called. Attempts cut short
+// by stop are not recorded; their lease runs out and they are sent again.
+func (w *webhookSender) start() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (w *webhookSender) run(ctx context.Context) {
+	poll := time.NewTicker(webhookPoll)
+	defer poll.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+	for {
+		w.sendDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wakeup:
+		case <-poll.C:
+		case <-prune.C:
+			if n, err := w.store.PruneDeliveries(time.Now().Add(-webhookLogKeep)); err != nil {
+				slog.Error("webhooks: pruning the delivery log failed", "err", err)
+			} else if n > 0 {
+				slog.Info("webhooks: delivery log pruned", "deliveries", n)
+			}
+		}
+	}
+}
+
+func (w *webhookSender) sendDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		batch, err := w.store.ClaimDeliveries(time.Now(), w.lease, webhookBatch)
+		if err != nil {
+			slog.Error("webhooks: claiming deliveries failed", "err", err)
+		}
+		var wg sync.WaitGroup
+		for _, d := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.attempt(ctx, d)
+			}()
+		}
+		wg.Wait()
+		if len(batch) < webhookBatch {
+			return
+		}
+	}
+}
+
+// attempt sends d once and records the outcome, scheduling a retry for
+// network errors, 429 and 5xx while webhookBackoff lasts.
+func (w *webhookSender) attempt(ctx context.Context, d WebhookDelivery) {
+	hook, err := w.store.WebhookByID(d.WebhookID)
+	if err != nil {
+		return // deleted together with its log
+	}
+	code, retryAfter, err := w.post(ctx, hook, d)
+	if ctx.Err() != nil {
+		return
+	}
+	d.Attempts++
+	d.ResponseCode = code
+	d.Error = ""
+	result := deliveryDelivered
+	switch {
+	case err == nil:
+		d.Status = deliveryDelivered
+	case (code == 0 || code == http.StatusTooManyRequests || code >= 500) && d.Attempts <= len(webhookBackoff):
+		d.Status, d.Error, result = deliveryPending, err.Error(), "retry"
+		d.NextAttemptAt = time.Now().Add(max(webhookBackoff[d.Attempts-1], min(retryAfter, webhookRetryAfterMax)))
+	default:
+		d.Status, d.Error, result = deliveryFailed, err.Error(), deliveryFailed
+	}
+	if err := w.store.SaveDelivery(&d); err != nil {
+		slog.Error("webhooks: saving delivery failed", "delivery_id", d.ID, "err", err)
+	}
+	w.metrics.webhookDeliveries.WithLabelValues(d.Event, result).Inc()
+	if d.Status == deliveryFailed {
+		slog.Warn("webhook delivery failed", "webhook_id", hook.ID, "delivery_id", d.ID, "attempts", d.Attempts, "err", d.Error)
+	}
+}
+
+// post returns the response status (0 without a response), how long the
+// receiver asked us to wait, and an error unless the status was 2xx.
+func (w *webhookSender) post(ctx context.Context, hook Webhook, d WebhookDelivery) (int, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "D2R-Farm-Tracker-Webhook/2.1")
+	req.Header.Set("X-D2R-Event", d.Event)
+	req.Header.Set("X-D2R-Delivery", strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set("X-D2R-Signature", webhookSignature(hook.Secret, []byte(d.Payload)))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookErrorBytes))
+	if resp.StatusCode/100 == 2 {
+		return resp.StatusCode, 0, nil
+	}
+	secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	return resp.StatusCode, time.Duration(secs) * time.Second, fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+}
+
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ==================== WEBHOOKS: PAGES ====================
+
+// One page serves both lists: /account/webhooks for the player's own
+// webhooks and /admin/webhooks for the global ones.
+type webhooksView struct {
+	page
+	Notice     notice
+	Base       string // URL prefix of the list's forms
+	Global     bool
+	ShowGlobal bool // link an admin to the global list
+	Events     []option
+	Hooks      []webhookRow
+	Deliveries []deliveryRow
+}
+
+type webhookRow struct {
+	Webhook
+	EventLabels []string
+}
+
+type deliveryRow struct {
+	WebhookDelivery
+	URL string
+}
+
+// webhookOwner is whose list the request is about: 0 under /admin.
+func webhookOwner(c *gin.Context) uint {
+	if strings.HasPrefix(c.FullPath(), "/admin/") {
+		return 0
+	}
+	return sessions.Default(c).Get("user_id").(uint)
+}
+
+func (s *server) webhooksPage(c *gin.Context) {
+	s.renderWebhooks(c, http.StatusOK, notice{})
+}
+
+func (s *server) renderWebhooks(c *gin.Context, status int, n notice) {
+	owner := webhookOwner(c)
+	hooks, err := s.reqStore(c).WebhooksFor([]uint{owner})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "webhooks: %v", err)
+		return
+	}
+	l := loc(c)
+	rows := make([]webhookRow, len(hooks))
+	urls := map[uint]string{}
+	ids := make([]uint, len(hooks))
+	for i, h := range hooks {
+		rows[i] = webhookRow{Webhook: h}
+		for _, ev := range strings.Split(h.Events, ",") {
+			rows[i].EventLabels = append(rows[i].EventLabels, l.T("webhook.event."+ev))
+		}
+		urls[h.ID], ids[i] = h.URL, h.ID
+	}
+	recent, err := s.reqStore(c).RecentDeliveries(ids, webhookLogShown)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "webhooks: %v", err)
+		return
+	}
+	deliveries := make([]deliveryRow, len(recent))
+	for i, d := range recent {
+		deliveries[i] = deliveryRow{WebhookDelivery: d, URL: urls[d.WebhookID]}
+	}
+
+	view := webhooksView{
+		page:       newPage(c, "title.webhooks"),
+		Notice:     n,
+		Base:       "/account/webhooks",
+		Global:     owner == 0,
+		ShowGlobal: owner != 0 && c.GetString("role") == RoleAdmin,
+		Events:     selectOptions(webhookEvents, "", func(ev string) string { return l.T("webhook.event." + ev) }),
+		Hooks:      rows,
+		Deliveries: deliveries,
+	}
+	if view.Global {
+		view.Base = "/admin/webhooks"
+	}
+	c.HTML(status, "webhooks", view)
+}
+
+func (s *server) createWebhookHandler(c *gin.Context) {
+	owner := webhookOwner(c)
+	raw := strings.TrimSpace(c.PostForm("url"))
+	if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		s.renderWebhooks(c, http.StatusBadRequest, accountError(c, "webhook.err.url"))
+		return
+	}
+	var events []string
+	for _, ev := range webhookEvents {
+		if slices.Contains(c.PostFormArray("events"), ev) {
+			events = append(events, ev)
+		}
+	}
+	if len(events) == 0 {
+		s.renderWebhooks(c, http.StatusBadRequest, accountError(c, "webhook.err.events"))
+		return
+	}
+	if hooks, err := s.reqStore(c).WebhooksFor([]uint{owner}); err != nil || len(hooks) >= webhookMaxPerOwner {
+		s.renderWebhooks(c, http.StatusConflict, notice{Text: loc(c).T("webhook.err.limit", webhookMaxPerOwner), Error: true})
+		return
+	}
+	hook := Webhook{UserID: owner, URL: raw, Secret: randomToken(), Events: strings.Join(events, ","), CreatedAt: time.Now()}
+	if err := s.reqStore(c).CreateWebhook(&hook); err != nil {
+		c.String(http.StatusInternalServerError, "webhooks: %v", err)
+		return
+	}
+	s.renderWebhooks(c, http.StatusOK, accountNotice(c, "webhook.ok.created"))
+}
+
+// webhookTarget loads :id, answering 404 unless it belongs to this list.
+func (s *server) webhookTarget(c *gin.Context) (Webhook, bool) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	hook, err := s.reqStore(c).WebhookByID(uint(id))
+	if err != nil || hook.UserID != webhookOwner(c) {
+		c.String(http.StatusNotFound, "webhook not found")
+		return hook, false
+	}
+	return hook, true
+}
+
+func (s *server) deleteWebhookHandler(c *gin.Context) {
+	hook, ok := s.webhookTarget(c)
+	if !ok {
+		return
+	}
+	if err := s.reqStore(c).DeleteWebhook(hook.ID); err != nil {
+		c.String(http.StatusInternalServerError, "webhooks: %v", err)
+		return
+	}
+	s.renderWebhooks(c, http.StatusOK, accountNotice(c, "webhook.ok.deleted"))
+}
+
+// testWebhookHandler queues a ping so the receiver can be checked without
+// farming a Ber first.
+func (s *server) testWebhookHandler(c *gin.Context) {
+	hook, ok := s.webhookTarget(c)
+	if !ok {
+		return
+	}
+	who := webhookUser{}
+	if u, err := s.reqStore(c).UserByID(sessions.Default(c).Get("user_id").(uint)); err == nil {
+		who = webhookUser{ID: u.ID, Username: u.Username}
+	}
+	ev := webhookPayload{Event: eventPing, OccurredAt: time.Now(), User: who}
+	ev.Content = ev.message(s.webhookLocalizer(c.Request.Context(), hook))
+	ev.Text = ev.Content
+	body, err := json.Marshal(ev)
+	if err == nil {
+		err = s.reqStore(c).QueueDeliveries([]WebhookDelivery{{WebhookID: hook.ID, Event: ev.Event, Payload: string(body),
+			Status: deliveryPending, CreatedAt: ev.OccurredAt, NextAttemptAt: ev.OccurredAt}})
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "webhooks: %v", err)
+		return
+	}
+	s.webhooks.wake()
+	s.renderWebhooks(c, http.StatusOK, accountNotice(c, "webhook.ok.test"))
+}
+
+// ==================== WEBHOOKS: TEST RECEIVER ====================
+
+// webhookReceiverCommand is "webhook-receiver [-listen addr] [-secret s]
+// [-fail n]": a stand-in endpoint that prints what arrives and checks the
+// signature. -fail answers the first n requests with 503 to watch retries.
+// Run the tracker with webhooks.allow_private to point a webhook at it.
+func webhookReceiverCommand(args []string) {
+	l := newLocalizer(defaultLocale)
+	fs := flag.NewFlagSet("webhook-receiver", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:9090", l.T("cli.receiver_flag_listen"))
+	secret := fs.String("secret", "", l.T("cli.receiver_flag_secret"))
+	fail := fs.Int("fail", 0, l.T("cli.receiver_flag_fail"))
+	fs.Parse(args)
+
+	var mu sync.Mutex
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature := "-"
+		if *secret != "" {
+			signature = "OK"
+			if !hmac.Equal([]byte(r.Header.Get("X-D2R-Signature")), []byte(webhookSignature(*secret, body))) {
+				signature = "BAD"
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		status := http.StatusNoContent
+		if *fail > 0 {
+			*fail--
+			status = http.StatusServiceUnavailable
+		}
+		var p webhookPayload
+		json.Unmarshal(body, &p)
+		fmt.Printf("%s #%s %-12s sig=%-3s → %d  %s\n", time.Now().Format(time.TimeOnly), r.Header.Get("X-D2R-Delivery"),
+			r.Header.Get("X-D2R-Event"), signature, status, p.Text)
+		w.WriteHeader(status)
+	})
+	log.Print(l.T("cli.receiver_listening", *listen))
+	fatal("webhook-receiver stopped", "err", http.ListenAndServe(*listen, handler))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func allowPrivateWebhooks(cfg *Config) { cfg.Webhooks.AllowPrivate = true }
+
+// hookDelivery is the only delivery of hook.
+func hookDelivery(t *testing.T, store Store, hook Webhook) WebhookDelivery {
+	t.Helper()
+	list, err := store.RecentDeliveries([]uint{hook.ID}, 10)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("deliveries = %+v (%v)", list, err)
+	}
+	return list[0]
+}
+
+func TestWebhookSignature(t *testing.T) {
+	const secret = "test-webhook-secret"
+	var got atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if r.Header.Get("X-D2R-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("signature %q does not match the body", r.Header.Get("X-D2R-Signature"))
+		}
+		var payload webhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil || payload.Event != r.Header.Get("X-D2R-Event") {
+			t.Errorf("event header %q, payload %s (%v)", r.Header.Get("X-D2R-Event"), body, err)
+		}
+		got.Add(1)
+	}))
+	defer receiver.Close()
+
+	ts := newTestServer(t, newMemStore(), allowPrivateWebhooks)
+	c := ts.user(t, "alice")
+	user, _ := ts.store.UserByUsername("alice")
+	hook := Webhook{UserID: user.ID, URL: receiver.URL, Secret: secret, Events: eventRunLogged, CreatedAt: time.Now()}
+	if err := ts.store.CreateWebhook(&hook); err != nil {
+		t.Fatal(err)
+	}
+	if res := c.post("/log-run", url.Values{"area": {"Mephisto"}, "difficulty": {"Hell"}}); res.Status != http.StatusOK {
+		t.Fatalf("log-run: %d %s", res.Status, res.Body)
+	}
+
+	ts.webhooks.sendDue(context.Background())
+	if got.Load() != 1 {
+		t.Fatalf("receiver got %d requests", got.Load())
+	}
+	if d := hookDelivery(t, ts.store, hook); d.Status != deliveryDelivered || d.ResponseCode != http.StatusOK {
+		t.Errorf("delivery = %+v", d)
+	}
+}
+
+func TestWebhookRetrySchedule(t *testing.T) {
+	var retryAfter atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := retryAfter.Load(); s > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(s)))
+		}
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	ts := newTestServer(t, newMemStore(), allowPrivateWebhooks)
+	ctx := context.Background()
+	queue := func() Webhook {
+		hook := Webhook{URL: receiver.URL, Secret: "s", Events: eventRunLogged, CreatedAt: time.Now()}
+		if err := ts.store.CreateWebhook(&hook); err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		if err := ts.store.QueueDeliveries([]WebhookDelivery{{WebhookID: hook.ID, Event: eventPing, Payload: "{}",
+			Status: deliveryPending, CreatedAt: now, NextAttemptAt: now}}); err != nil {
+			t.Fatal(err)
+		}
+		return hook
+	}
+
+	// Each 500 waits the next step of webhookBackoff; one more after the
+	// last step gives the delivery up.
+	hook := queue()
+	for i, wait := range webhookBackoff {
+		before := time.Now()
+		ts.webhooks.attempt(ctx, hookDelivery(t, ts.store, hook))
+		d := hookDelivery(t, ts.store, hook)
+		if d.Status != deliveryPending || d.Attempts != i+1 || d.ResponseCode != http.StatusInternalServerError {
+			t.Fatalf("after attempt %d: %+v", i+1, d)
+		}
+		if next := d.NextAttemptAt; next.Before(before.Add(wait)) || next.After(time.Now().Add(wait)) {
+			t.Errorf("after attempt %d: next attempt in %v, want %v", i+1, next.Sub(before), wait)
+		}
+	}
+	ts.webhooks.attempt(ctx, hookDelivery(t, ts.store, hook))
+	if d := hookDelivery(t, ts.store, hook); d.Status != deliveryFailed || d.Attempts != len(webhookBackoff)+1 {
+		t.Errorf("after the last retry: %+v", d)
+	}
+
+	// A longer Retry-After wins over the backoff.
+	retryAfter.Store(600)
+	hook = queue()
+	before := time.Now()
+	ts.webhooks.attempt(ctx, hookDelivery(t, ts.store, hook))
+	if d := hookDelivery(t, ts.store, hook); d.NextAttemptAt.Before(before.Add(10 * time.Minute)) {
+		t.Errorf("Retry-After ignored: next attempt in %v", d.NextAttemptAt.Sub(before))
+	}
+
+	// But a receiver cannot push the retry out indefinitely.
+	retryAfter.Store(10 * 365 * 24 * 3600)
+	hook = queue()
+	ts.webhooks.attempt(ctx, hookDelivery(t, ts.store, hook))
+	if d := hookDelivery(t, ts.store, hook); d.NextAttemptAt.After(time.Now().Add(webhookRetryAfterMax)) {
+		t.Errorf("Retry-After not capped: next attempt in %v", time.Until(d.NextAttemptAt))
+	}
+}
+
+// Unless webhooks.allow_private is set, no delivery reaches a loopback,
+// private or link-local address.
+func TestWebhookPrivateAddress(t *testing.T) {
+	var got atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { got.Add(1) }))
+	defer receiver.Close()
+
+	ts := newTestServer(t, newMemStore())
+	for _, target := range []string{
+		receiver.URL,
+		"http://localhost:1/hook",
+		"http://10.0.0.1/hook",
+		"http://10.255.1.2:8080/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]:1/hook",
+	} {
+		hook := Webhook{URL: target, Secret: "s"}
+		_, _, err := ts.webhooks.post(context.Background(), hook, WebhookDelivery{Event: eventPing, Payload: "{}"})
+		if !errors.Is(err, errPrivateAddress) {
+			t.Errorf("%s: %v, want errPrivateAddress", target, err)
+		}
+	}
+	if got.Load() != 0 {
+		t.Errorf("the loopback receiver got %d requests", got.Load())
+	}
+}
+
+// A claim is a lease: nobody else gets the delivery while it runs, and once
+// it runs out a sender that died mid-attempt no longer holds it.
+func TestWebhookLeaseReclaim(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		hook := Webhook{URL: "https://example.com/hook", Events: eventRunLogged, CreatedAt: time.Now()}
+		if err := store.CreateWebhook(&hook); err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		if err := store.QueueDeliveries([]WebhookDelivery{{WebhookID: hook.ID, Event: eventPing, Payload: "{}",
+			Status: deliveryPending, CreatedAt: now, NextAttemptAt: now}}); err != nil {
+			t.Fatal(err)
+		}
+		const lease = time.Minute
+		claim := func(at time.Time) []WebhookDelivery {
+			t.Helper()
+			got, err := store.ClaimDeliveries(at, lease, webhookBatch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return got
+		}
+
+		first := claim(now)
+		if len(first) != 1 {
+			t.Fatalf("first claim: %+v", first)
+		}
+		if got := claim(now.Add(lease / 2)); len(got) != 0 {
+			t.Errorf("claimed again while leased: %+v", got)
+		}
+		// The first sender never reports back.
+		reclaimed := claim(now.Add(lease + time.Second))
+		if len(reclaimed) != 1 || reclaimed[0].ID != first[0].ID {
+			t.Fatalf("after the lease: %+v", reclaimed)
+		}
+
+		d := reclaimed[0]
+		d.Status, d.Attempts = deliveryDelivered, 1
+		if err := store.SaveDelivery(&d); err != nil {
+			t.Fatal(err)
+		}
+		if got := claim(now.Add(time.Hour)); len(got) != 0 {
+			t.Errorf("delivered delivery claimed: %+v", got)
+		}
+	})
+}